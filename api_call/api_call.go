@@ -14,7 +14,7 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pb "ai-chat/pb"
-	"github.com/sashabaranov/go-openai"
+	"io"
 	"os"
 	"strings"
 	"time"
@@ -22,8 +22,18 @@ import (
 
 const (
 	timeout = 10 * time.Minute
+	// titles are a few words, the limit only guards against models which keep talking
+	titleMaxTokens = 30
 )
 
+// AIResponse is the answer of a model together with what it was billed for
+type AIResponse struct {
+	Text         string
+	Cost         float64
+	InputTokens  int
+	OutputTokens int
+}
+
 type AIClient struct {
 	client pb.AIServiceClient
 	conn   *grpc.ClientConn
@@ -36,10 +46,15 @@ func InitAIClient() *AIClient {
 	if err != nil {
 		panic(err)
 	}
+
+	llm, err := NewLLM()
+	if err != nil {
+		panic(err)
+	}
 	return &AIClient{
 		client: pb.NewAIServiceClient(conn),
 		conn:   conn,
-		llm:    NewLLM(os.Getenv("OPENAI_API_KEY")),
+		llm:    llm,
 	}
 }
 
@@ -47,78 +62,188 @@ func (c *AIClient) Close() {
 	c.conn.Close()
 }
 
-func (c *AIClient) AIApiCall(userId, sessionId, chat string, fileName []string, sessionPrompt string, chatHistory []structures.Chat, chatSummary, modelName, modelProvider string, balance float64) (string, float64, error) {
+func (c *AIClient) AIApiCall(userId, sessionId, chat string, fileName []string, sessionPrompt string, chatHistory []structures.Chat, chatSummary, modelName, modelProvider string, balance float64) (*AIResponse, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer func() {
 		cancel()
 	}()
 
-	chatHistoryStr, err := json.Marshal(chatHistory)
+	request, err := newRequest(userId, sessionId, chat, fileName, sessionPrompt, chatHistory, chatSummary, modelName, modelProvider, balance)
 	if err != nil {
-		return "", 0, errors.New(string(error_code.Error(error_code.ErrorCodeJSONMarshal)))
-	}
-
-	r, err := c.client.Process(ctx, &pb.Request{
-		UserId:        userId,
-		SessionId:     sessionId,
-		ChatMessage:   chat,
-		FileName:      fileName,
-		ModelName:     modelName,
-		ModelProvider: modelProvider,
-		SessionPrompt: sessionPrompt,
-		ChatSummary:   chatSummary,
-		ChatHistory:   string(chatHistoryStr),
-		Balance:       float32(balance),
-		Timestamp:     timestamppb.Now(),
-	})
+		return nil, err
+	}
+
+	r, err := c.client.Process(ctx, request)
 	if err != nil {
 		fmt.Println("API ERR: ", err)
-		return "", 0, err
+		return nil, err
+	}
+	return &AIResponse{
+		Text:         r.GetResponseText(),
+		Cost:         float64(r.GetCost()),
+		InputTokens:  int(r.GetInputTokens()),
+		OutputTokens: int(r.GetOutputTokens()),
+	}, nil
+}
+
+// AIApiCallStream works like AIApiCall but uses the streaming RPC, handing every chunk to onChunk
+// as it arrives. The fully assembled response text is returned once the stream is done.
+func (c *AIClient) AIApiCallStream(userId, sessionId, chat string, fileName []string, sessionPrompt string, chatHistory []structures.Chat, chatSummary, modelName, modelProvider string, balance float64, onChunk ChunkHandler) (*AIResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer func() {
+		cancel()
+	}()
+
+	request, err := newRequest(userId, sessionId, chat, fileName, sessionPrompt, chatHistory, chatSummary, modelName, modelProvider, balance)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := c.client.ProcessStream(ctx, request)
+	if err != nil {
+		fmt.Println("API STREAM ERR: ", err)
+		return nil, err
+	}
+
+	var text strings.Builder
+	for {
+		r, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("stream closed before the response was done")
+		}
+		if err != nil {
+			fmt.Println("API STREAM ERR: ", err)
+			return nil, err
+		}
+
+		if r.GetChunk() != "" {
+			text.WriteString(r.GetChunk())
+			if err := onChunk(r.GetChunk()); err != nil {
+				return nil, err
+			}
+		}
+
+		if r.GetDone() {
+			return &AIResponse{
+				Text:         text.String(),
+				Cost:         float64(r.GetCost()),
+				InputTokens:  int(r.GetInputTokens()),
+				OutputTokens: int(r.GetOutputTokens()),
+			}, nil
+		}
 	}
-	return r.GetResponseText(), float64(r.GetCost()), nil
 }
 
-func (c *AIClient) ApiSummary(summary, chats, model string) (string, float64, error) {
+func newRequest(userId, sessionId, chat string, fileName []string, sessionPrompt string, chatHistory []structures.Chat, chatSummary, modelName, modelProvider string, balance float64) (*pb.Request, error) {
+	// the AI service only reads the role and content of the history
+	type historyMessage struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	history := make([]historyMessage, 0, len(chatHistory))
+	for _, chat := range chatHistory {
+		history = append(history, historyMessage{Role: chat.Role, Content: chat.Content})
+	}
+
+	chatHistoryStr, err := json.Marshal(history)
+	if err != nil {
+		return nil, errors.New(string(error_code.Error(error_code.ErrorCodeJSONMarshal)))
+	}
+
+	return &pb.Request{
+		UserId:          userId,
+		SessionId:       sessionId,
+		ChatMessage:     chat,
+		FileName:        fileName,
+		ModelName:       modelName,
+		ModelProvider:   modelProvider,
+		SessionPrompt:   sessionPrompt,
+		ChatSummary:     chatSummary,
+		ChatHistory:     string(chatHistoryStr),
+		Balance:         float32(balance),
+		Timestamp:       timestamppb.Now(),
+		MaxOutputTokens: int32(helper_functions.MaxOutputTokens()),
+	}, nil
+}
+
+func (c *AIClient) ApiSummary(summary, chats, model string) (*AIResponse, error) {
 	prompt := GetSummaryPrompt(summary, chats)
 
-	resp, err := c.llm.Generate(model_data.GetModelProvider(model), model, prompt, "")
+	resp, err := c.llm.Generate(model_data.GetModelProvider(model), model, prompt, "", helper_functions.MaxOutputTokens())
 	if err != nil {
-		return "", 0, fmt.Errorf("error while calling llm : %w", err)
+		return nil, fmt.Errorf("error while calling llm : %w", err)
 	}
 
 	cost, err := helper_functions.EstimateOpenAIAPICost(model, resp.InputTokens, resp.OutputTokens)
 	if err != nil {
-		return "", 0, fmt.Errorf("error while estimating cost: %w", err)
+		return nil, fmt.Errorf("error while estimating cost: %w", err)
 	}
-	return resp.Text, cost, nil
+	return &AIResponse{
+		Text:         resp.Text,
+		Cost:         cost,
+		InputTokens:  resp.InputTokens,
+		OutputTokens: resp.OutputTokens,
+	}, nil
 }
 
-func ApiEmbedding(input string) ([]float32, error) {
-	input = strings.TrimSpace(input)
-	if input == "" {
-		return nil, fmt.Errorf("input is empty")
+// EstimateSummaryCost is the most ApiSummary can cost for the same arguments
+func EstimateSummaryCost(summary, chats, model string) (float64, error) {
+	return helper_functions.EstimatePromptCost(model, GetSummaryPrompt(summary, chats), helper_functions.MaxOutputTokens())
+}
+
+// ApiTitle asks model for a short title of the first exchange of a session
+func (c *AIClient) ApiTitle(message, reply, model string) (*AIResponse, error) {
+	prompt := GetTitlePrompt(message, reply)
+
+	resp, err := c.llm.Generate(model_data.GetModelProvider(model), model, prompt, "", titleMaxTokens)
+	if err != nil {
+		return nil, fmt.Errorf("error while calling llm : %w", err)
 	}
 
-	apiKey := os.Getenv("OPENAI_API_KEY")
+	cost, err := helper_functions.EstimateOpenAIAPICost(model, resp.InputTokens, resp.OutputTokens)
+	if err != nil {
+		return nil, fmt.Errorf("error while estimating cost: %w", err)
+	}
+	return &AIResponse{
+		Text:         resp.Text,
+		Cost:         cost,
+		InputTokens:  resp.InputTokens,
+		OutputTokens: resp.OutputTokens,
+	}, nil
+}
 
-	client := openai.NewClient(apiKey)
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+// EstimateTitleCost is the most ApiTitle can cost for the same arguments
+func EstimateTitleCost(message, reply, model string) (float64, error) {
+	return helper_functions.EstimatePromptCost(model, GetTitlePrompt(message, reply), titleMaxTokens)
+}
 
-	req := openai.EmbeddingRequest{
-		Input: []string{input},
-		Model: openai.AdaEmbeddingV2,
+// ApiEmbedding turns the text into an embedding vector with the model of the provider
+func (c *AIClient) ApiEmbedding(input, provider, model string) (structures.Vector, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return structures.Vector{}, fmt.Errorf("input is empty")
 	}
 
-	resp, err := client.CreateEmbeddings(ctx, req)
+	vectors, err := c.ApiEmbeddings([]string{input}, provider, model)
 	if err != nil {
-		return nil, fmt.Errorf("error creating embeddings: %w", err)
+		return structures.Vector{}, err
 	}
+	return vectors[0], nil
+}
 
-	if len(resp.Data) == 0 {
-		return nil, fmt.Errorf("no embeddings found for the input")
+// ApiEmbeddings turns every text into an embedding vector with one call to the provider
+func (c *AIClient) ApiEmbeddings(inputs []string, provider, model string) ([]structures.Vector, error) {
+	resp, err := c.llm.Embed(provider, model, inputs)
+	if err != nil {
+		return nil, fmt.Errorf("error while calling llm : %w", err)
 	}
 
-	embedding := resp.Data[0].Embedding
-	return embedding, nil
+	vectors := make([]structures.Vector, 0, len(resp.Vectors))
+	for _, vector := range resp.Vectors {
+		if len(vector) == 0 {
+			return nil, fmt.Errorf("no embeddings found for the input")
+		}
+		vectors = append(vectors, structures.Vector{Data: vector})
+	}
+	return vectors, nil
 }
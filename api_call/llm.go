@@ -1,22 +1,18 @@
 package api_call
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"github.com/sashabaranov/go-openai"
-	"io/ioutil"
-	"net"
-	"net/http"
 	"os"
 	"strings"
-	"time"
+	"sync"
 )
 
+// LLM routes generation requests to providers registered by name. The provider name of a model
+// in Model_Details selects which one answers.
 type LLM struct {
-	OpenAIAPIKey string
+	mu        sync.RWMutex
+	providers map[string]Provider
 }
 
 type GenerateResponse struct {
@@ -25,108 +21,73 @@ type GenerateResponse struct {
 	OutputTokens int
 }
 
-func NewLLM(openAIAPIKey string) *LLM {
-	return &LLM{
-		OpenAIAPIKey: openAIAPIKey,
-	}
-}
+// ChunkHandler receives every partial piece of text while a response is streamed.
+// Returning an error aborts the stream.
+type ChunkHandler func(chunk string) error
 
-func (l *LLM) Generate(provider, model, userPrompt, systemPrompt string) (*GenerateResponse, error) {
-	switch strings.ToLower(provider) {
-	case "ollama":
-		return l.generateOllama(model, userPrompt, systemPrompt)
-	case "openai":
-		return l.generateOpenAI(model, userPrompt, systemPrompt)
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", provider)
-	}
-}
-
-func (l *LLM) generateOllama(model, userPrompt, systemPrompt string) (*GenerateResponse, error) {
-	url := fmt.Sprintf("http://ollama:%s/api/generate", os.Getenv("OLLAMA_PORT"))
-	data := map[string]interface{}{
-		"model":  model,
-		"prompt": userPrompt,
-		"system": systemPrompt,
-		"stream": false,
-	}
+// NewLLM registers the built in "ollama" and "openai" providers together with every name listed
+// in LLM_PROVIDERS, each configured through its LLM_PROVIDER_<NAME>_* variables.
+func NewLLM() (*LLM, error) {
+	l := &LLM{providers: map[string]Provider{}}
 
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return nil, err
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		var err net.Error
-		if errors.As(err, &err) && err.Timeout() {
-			return nil, fmt.Errorf("request to Ollama API timed out. The server might be overloaded or not responding")
+	names := []string{ProviderTypeOllama, ProviderTypeOpenAI}
+	for _, name := range strings.Split(os.Getenv("LLM_PROVIDERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
 		}
-		return nil, fmt.Errorf("failed to connect to Ollama API. Is the server running? Error: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("ollama API returned non-OK status: %s", resp.Status)
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+	for _, name := range names {
+		provider, err := NewProvider(ProviderConfigFromEnv(name))
+		if err != nil {
+			return nil, fmt.Errorf("error creating provider %s: %w", name, err)
+		}
+		l.RegisterProvider(name, provider)
 	}
+	return l, nil
+}
 
-	var result map[string]interface{}
-	err = json.Unmarshal(body, &result)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal JSON response: %w", err)
-	}
+// RegisterProvider adds or replaces the provider answering for name
+func (l *LLM) RegisterProvider(name string, provider Provider) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.providers[strings.ToLower(name)] = provider
+}
 
-	return &GenerateResponse{
-		Text:         result["response"].(string),
-		InputTokens:  int(result["prompt_eval_count"].(float64)),
-		OutputTokens: int(result["eval_count"].(float64)),
-	}, nil
+func (l *LLM) Provider(name string) (Provider, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	provider, ok := l.providers[strings.ToLower(name)]
+	return provider, ok
 }
 
-func (l *LLM) generateOpenAI(model, userPrompt, systemPrompt string) (*GenerateResponse, error) {
-	if l.OpenAIAPIKey == "" {
-		return nil, fmt.Errorf("API key is required for OpenAI")
+func (l *LLM) Generate(provider, model, userPrompt, systemPrompt string, maxTokens int) (*GenerateResponse, error) {
+	p, ok := l.Provider(provider)
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", provider)
 	}
+	return p.Generate(context.Background(), GenerateRequest{Model: model, UserPrompt: userPrompt, SystemPrompt: systemPrompt, MaxTokens: maxTokens})
+}
 
-	client := openai.NewClient(l.OpenAIAPIKey)
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	chatReq := openai.ChatCompletionRequest{
-		Model: model,
-		Messages: []openai.ChatCompletionMessage{
-			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: userPrompt},
-		},
+// GenerateStream works like Generate but hands every partial piece of text to onChunk as soon
+// as the provider produces it. The returned response holds the fully assembled text.
+func (l *LLM) GenerateStream(provider, model, userPrompt, systemPrompt string, maxTokens int, onChunk ChunkHandler) (*GenerateResponse, error) {
+	p, ok := l.Provider(provider)
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", provider)
 	}
-	resp, err := client.CreateChatCompletion(ctx, chatReq)
+	return p.GenerateStream(context.Background(), GenerateRequest{Model: model, UserPrompt: userPrompt, SystemPrompt: systemPrompt, MaxTokens: maxTokens}, onChunk)
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("error creating summary: %w", err)
+// Embed returns the embedding vectors of the texts from a provider which implements EmbeddingProvider
+func (l *LLM) Embed(provider, model string, input []string) (*EmbedResponse, error) {
+	p, ok := l.Provider(provider)
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", provider)
 	}
-
-	if len(resp.Choices) == 0 {
-		return nil, fmt.Errorf("no summary found for the input")
+	embedder, ok := p.(EmbeddingProvider)
+	if !ok {
+		return nil, fmt.Errorf("provider %s does not support embeddings", provider)
 	}
-
-	return &GenerateResponse{
-		Text:         resp.Choices[0].Message.Content,
-		InputTokens:  resp.Usage.PromptTokens,
-		OutputTokens: resp.Usage.CompletionTokens,
-	}, nil
+	return embedder.Embed(context.Background(), EmbedRequest{Model: model, Input: input})
 }
@@ -0,0 +1,200 @@
+package api_call
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// OllamaProvider talks to the /api/generate endpoint of an Ollama server
+type OllamaProvider struct {
+	config ProviderConfig
+	client *http.Client
+}
+
+type ollamaResponse struct {
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`
+	Error           string `json:"error"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+}
+
+type ollamaEmbedResponse struct {
+	Embeddings      [][]float32 `json:"embeddings"`
+	Error           string      `json:"error"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+func NewOllamaProvider(config ProviderConfig) (Provider, error) {
+	if config.BaseURL == "" {
+		return nil, errors.New("base URL is required for Ollama")
+	}
+	return &OllamaProvider{config: config, client: newHTTPClient(config)}, nil
+}
+
+func (p *OllamaProvider) Generate(ctx context.Context, request GenerateRequest) (*GenerateResponse, error) {
+	ctx, cancel := withTimeout(ctx, p.config, defaultGenerateTimeout)
+	defer cancel()
+
+	resp, err := p.post(ctx, request, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var result ollamaResponse
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON response: %w", err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("ollama API returned error: %s", result.Error)
+	}
+
+	return &GenerateResponse{
+		Text:         result.Response,
+		InputTokens:  result.PromptEvalCount,
+		OutputTokens: result.EvalCount,
+	}, nil
+}
+
+func (p *OllamaProvider) GenerateStream(ctx context.Context, request GenerateRequest, onChunk ChunkHandler) (*GenerateResponse, error) {
+	ctx, cancel := withTimeout(ctx, p.config, timeout)
+	defer cancel()
+
+	resp, err := p.post(ctx, request, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Ollama streams one JSON object per line, the last one has done set and carries the token counts
+	var text strings.Builder
+	result := &GenerateResponse{}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var part ollamaResponse
+		if err := json.Unmarshal(scanner.Bytes(), &part); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON stream chunk: %w", err)
+		}
+		if part.Error != "" {
+			return nil, fmt.Errorf("ollama API returned error: %s", part.Error)
+		}
+
+		if part.Response != "" {
+			text.WriteString(part.Response)
+			if err := onChunk(part.Response); err != nil {
+				return nil, err
+			}
+		}
+
+		if part.Done {
+			result.InputTokens = part.PromptEvalCount
+			result.OutputTokens = part.EvalCount
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read response stream: %w", err)
+	}
+
+	result.Text = text.String()
+	return result, nil
+}
+
+// Embed uses the /api/embed endpoint, which embeds all the texts of the request at once
+func (p *OllamaProvider) Embed(ctx context.Context, request EmbedRequest) (*EmbedResponse, error) {
+	ctx, cancel := withTimeout(ctx, p.config, defaultGenerateTimeout)
+	defer cancel()
+
+	resp, err := p.send(ctx, "/api/embed", map[string]interface{}{
+		"model": request.Model,
+		"input": request.Input,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var result ollamaEmbedResponse
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON response: %w", err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("ollama API returned error: %s", result.Error)
+	}
+	if len(result.Embeddings) != len(request.Input) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(request.Input), len(result.Embeddings))
+	}
+
+	return &EmbedResponse{
+		Vectors:     result.Embeddings,
+		InputTokens: result.PromptEvalCount,
+	}, nil
+}
+
+func (p *OllamaProvider) post(ctx context.Context, request GenerateRequest, stream bool) (*http.Response, error) {
+	data := map[string]interface{}{
+		"model":  request.Model,
+		"prompt": request.UserPrompt,
+		"system": request.SystemPrompt,
+		"stream": stream,
+	}
+	if request.MaxTokens > 0 {
+		data["options"] = map[string]interface{}{"num_predict": request.MaxTokens}
+	}
+	return p.send(ctx, "/api/generate", data)
+}
+
+// send posts data as JSON to an endpoint of the server, responses without an OK status are returned as errors
+func (p *OllamaProvider) send(ctx context.Context, endpoint string, data map[string]interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimSuffix(p.config.BaseURL, "/") + endpoint
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.APIKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, fmt.Errorf("request to Ollama API timed out. The server might be overloaded or not responding")
+		}
+		return nil, fmt.Errorf("failed to connect to Ollama API. Is the server running? Error: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("ollama API returned non-OK status: %s", resp.Status)
+	}
+	return resp, nil
+}
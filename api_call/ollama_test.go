@@ -0,0 +1,182 @@
+package api_call
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newOllamaTestProvider(t *testing.T, config ProviderConfig, handler http.HandlerFunc) Provider {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	config.BaseURL = server.URL + "/"
+	provider, err := NewOllamaProvider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func decodeOllamaRequest(t *testing.T, r *http.Request) map[string]interface{} {
+	t.Helper()
+	if r.Method != http.MethodPost || r.URL.Path != "/api/generate" {
+		t.Errorf("request = %s %s", r.Method, r.URL.Path)
+	}
+	if got := r.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	var request map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		t.Errorf("failed to decode request: %v", err)
+	}
+	if request["model"] != "gpt-4o" || request["prompt"] != "hello" || request["system"] != "be brief" {
+		t.Errorf("request = %v", request)
+	}
+	return request
+}
+
+func TestOllamaProviderGenerate(t *testing.T) {
+	provider := newOllamaTestProvider(t, ProviderConfig{APIKey: "token"}, func(w http.ResponseWriter, r *http.Request) {
+		request := decodeOllamaRequest(t, r)
+		if request["stream"] != false {
+			t.Errorf("stream = %v, want false", request["stream"])
+		}
+		if options, _ := request["options"].(map[string]interface{}); options["num_predict"] != float64(64) {
+			t.Errorf("options = %v", request["options"])
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		fmt.Fprint(w, `{"response":"Hi there","done":true,"prompt_eval_count":12,"eval_count":3}`)
+	})
+
+	resp, err := provider.Generate(context.Background(), testRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Hi there" || resp.InputTokens != 12 || resp.OutputTokens != 3 {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestOllamaProviderGenerateWithoutLimit(t *testing.T) {
+	provider := newOllamaTestProvider(t, ProviderConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		if request := decodeOllamaRequest(t, r); request["options"] != nil {
+			t.Errorf("options = %v, want none without MaxTokens", request["options"])
+		}
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Errorf("Authorization = %q, want none", got)
+		}
+		fmt.Fprint(w, `{"response":"Hi","done":true}`)
+	})
+
+	request := testRequest
+	request.MaxTokens = 0
+	if _, err := provider.Generate(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOllamaProviderGenerateStream(t *testing.T) {
+	provider := newOllamaTestProvider(t, ProviderConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		if request := decodeOllamaRequest(t, r); request["stream"] != true {
+			t.Errorf("stream = %v, want true", request["stream"])
+		}
+		for _, line := range []string{
+			`{"response":"Hi","done":false}`,
+			`{"response":"","done":false}`,
+			`{"response":" there","done":false}`,
+			`{"response":"","done":true,"prompt_eval_count":12,"eval_count":2}`,
+		} {
+			fmt.Fprintln(w, line)
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	var chunks []string
+	resp, err := provider.GenerateStream(context.Background(), testRequest, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(chunks, "|") != "Hi| there" {
+		t.Errorf("chunks = %q", chunks)
+	}
+	if resp.Text != "Hi there" || resp.InputTokens != 12 || resp.OutputTokens != 2 {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestOllamaProviderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"not found", http.StatusNotFound, `{"error":"model 'gpt-4o' not found"}`},
+		{"server error", http.StatusInternalServerError, `internal error`},
+		{"error in body", http.StatusOK, `{"error":"out of memory"}`},
+		{"invalid json", http.StatusOK, `not json`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newOllamaTestProvider(t, ProviderConfig{}, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				fmt.Fprintln(w, test.body)
+			})
+
+			if _, err := provider.Generate(context.Background(), testRequest); err == nil {
+				t.Error("Generate() succeeded, want an error")
+			}
+			if _, err := provider.GenerateStream(context.Background(), testRequest, func(string) error { return nil }); err == nil {
+				t.Error("GenerateStream() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestOllamaProviderUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	provider, err := NewOllamaProvider(ProviderConfig{BaseURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Generate(context.Background(), testRequest); err == nil || !strings.Contains(err.Error(), "failed to connect") {
+		t.Errorf("Generate() error = %v, want a connection error", err)
+	}
+}
+
+func TestOllamaProviderEmbed(t *testing.T) {
+	provider := newOllamaTestProvider(t, ProviderConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embed" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		var request struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Model != "nomic-embed-text" || len(request.Input) != 2 {
+			t.Errorf("request = %+v, error %v", request, err)
+		}
+		fmt.Fprint(w, `{"embeddings":[[0.1,0.2],[0.3,0.4]],"prompt_eval_count":4}`)
+	})
+
+	resp, err := provider.(EmbeddingProvider).Embed(context.Background(), EmbedRequest{Model: "nomic-embed-text", Input: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Vectors) != 2 || resp.Vectors[1][0] != 0.3 || resp.InputTokens != 4 {
+		t.Errorf("response = %+v", resp)
+	}
+}
@@ -0,0 +1,167 @@
+package api_call
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"strings"
+)
+
+// OpenAIProvider talks to the chat completions API of OpenAI, Azure OpenAI or any OpenAI compatible
+// server such as vLLM, LM Studio or an internal gateway.
+type OpenAIProvider struct {
+	config ProviderConfig
+	client *openai.Client
+}
+
+func NewOpenAIProvider(config ProviderConfig) (Provider, error) {
+	var clientConfig openai.ClientConfig
+	switch strings.ToLower(config.Type) {
+	case ProviderTypeAzureOpenAI:
+		if config.BaseURL == "" || config.APIKey == "" {
+			return nil, errors.New("base URL and API key are required for Azure OpenAI")
+		}
+		clientConfig = openai.DefaultAzureConfig(config.APIKey, config.BaseURL)
+		if config.APIVersion != "" {
+			clientConfig.APIVersion = config.APIVersion
+		}
+	case ProviderTypeOpenAICompatible:
+		if config.BaseURL == "" {
+			return nil, errors.New("base URL is required for OpenAI compatible providers")
+		}
+		clientConfig = openai.DefaultConfig(config.APIKey)
+		clientConfig.BaseURL = config.BaseURL
+	default:
+		clientConfig = openai.DefaultConfig(config.APIKey)
+		if config.BaseURL != "" {
+			clientConfig.BaseURL = config.BaseURL
+		}
+	}
+	clientConfig.HTTPClient = newHTTPClient(config)
+
+	return &OpenAIProvider{config: config, client: openai.NewClientWithConfig(clientConfig)}, nil
+}
+
+func (p *OpenAIProvider) Generate(ctx context.Context, request GenerateRequest) (*GenerateResponse, error) {
+	if err := p.checkAPIKey(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, p.config, defaultGenerateTimeout)
+	defer cancel()
+
+	resp, err := p.client.CreateChatCompletion(ctx, p.chatRequest(request, false))
+	if err != nil {
+		return nil, fmt.Errorf("error creating completion: %w", err)
+	}
+
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("no completion found for the input")
+	}
+
+	return &GenerateResponse{
+		Text:         resp.Choices[0].Message.Content,
+		InputTokens:  resp.Usage.PromptTokens,
+		OutputTokens: resp.Usage.CompletionTokens,
+	}, nil
+}
+
+func (p *OpenAIProvider) GenerateStream(ctx context.Context, request GenerateRequest, onChunk ChunkHandler) (*GenerateResponse, error) {
+	if err := p.checkAPIKey(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, p.config, timeout)
+	defer cancel()
+
+	stream, err := p.client.CreateChatCompletionStream(ctx, p.chatRequest(request, true))
+	if err != nil {
+		return nil, fmt.Errorf("error creating completion stream: %w", err)
+	}
+	defer stream.Close()
+
+	var text strings.Builder
+	result := &GenerateResponse{}
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error receiving completion stream: %w", err)
+		}
+
+		// usage only arrives in the last chunk, which has no choices
+		if resp.Usage != nil {
+			result.InputTokens = resp.Usage.PromptTokens
+			result.OutputTokens = resp.Usage.CompletionTokens
+		}
+		if len(resp.Choices) == 0 || resp.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		text.WriteString(resp.Choices[0].Delta.Content)
+		if err := onChunk(resp.Choices[0].Delta.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Text = text.String()
+	return result, nil
+}
+
+func (p *OpenAIProvider) Embed(ctx context.Context, request EmbedRequest) (*EmbedResponse, error) {
+	if err := p.checkAPIKey(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, p.config, defaultGenerateTimeout)
+	defer cancel()
+
+	resp, err := p.client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: request.Input,
+		Model: openai.EmbeddingModel(request.Model),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating embeddings: %w", err)
+	}
+
+	if len(resp.Data) != len(request.Input) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(request.Input), len(resp.Data))
+	}
+
+	result := &EmbedResponse{Vectors: make([][]float32, len(resp.Data)), InputTokens: resp.Usage.PromptTokens}
+	for _, embedding := range resp.Data {
+		if embedding.Index < 0 || embedding.Index >= len(result.Vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", embedding.Index)
+		}
+		result.Vectors[embedding.Index] = embedding.Embedding
+	}
+	return result, nil
+}
+
+// checkAPIKey makes sure the hosted APIs have a key, self hosted compatible servers often don't need one
+func (p *OpenAIProvider) checkAPIKey() error {
+	if p.config.APIKey == "" && strings.ToLower(p.config.Type) != ProviderTypeOpenAICompatible {
+		return fmt.Errorf("API key is required for OpenAI")
+	}
+	return nil
+}
+
+func (p *OpenAIProvider) chatRequest(request GenerateRequest, stream bool) openai.ChatCompletionRequest {
+	chatReq := openai.ChatCompletionRequest{
+		Model: request.Model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: "system", Content: request.SystemPrompt},
+			{Role: "user", Content: request.UserPrompt},
+		},
+		MaxTokens: request.MaxTokens,
+	}
+	if stream {
+		chatReq.Stream = true
+		chatReq.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+	return chatReq
+}
@@ -0,0 +1,254 @@
+package api_call
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type openAITestRequest struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+	MaxTokens     int  `json:"max_tokens"`
+	Stream        bool `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+func decodeOpenAIRequest(t *testing.T, r *http.Request) openAITestRequest {
+	t.Helper()
+	var request openAITestRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		t.Errorf("failed to decode request: %v", err)
+	}
+	return request
+}
+
+func checkOpenAIMessages(t *testing.T, request openAITestRequest) {
+	t.Helper()
+	if len(request.Messages) != 2 ||
+		request.Messages[0].Role != "system" || request.Messages[0].Content != "be brief" ||
+		request.Messages[1].Role != "user" || request.Messages[1].Content != "hello" {
+		t.Errorf("messages = %+v", request.Messages)
+	}
+}
+
+func newOpenAITestProvider(t *testing.T, config ProviderConfig, handler http.HandlerFunc) Provider {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	config.BaseURL = server.URL + config.BaseURL
+	provider, err := NewOpenAIProvider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+var testRequest = GenerateRequest{Model: "gpt-4o", UserPrompt: "hello", SystemPrompt: "be brief", MaxTokens: 64}
+
+func TestOpenAIProviderGenerate(t *testing.T) {
+	provider := newOpenAITestProvider(t, ProviderConfig{Type: "openai", APIKey: "sk-test", BaseURL: "/v1"}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer sk-test" {
+			t.Errorf("Authorization = %q", got)
+		}
+		request := decodeOpenAIRequest(t, r)
+		if request.Model != "gpt-4o" || request.MaxTokens != 64 || request.Stream {
+			t.Errorf("request = %+v", request)
+		}
+		checkOpenAIMessages(t, request)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"Hi there"}}],"usage":{"prompt_tokens":12,"completion_tokens":3}}`)
+	})
+
+	resp, err := provider.Generate(context.Background(), testRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Hi there" || resp.InputTokens != 12 || resp.OutputTokens != 3 {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestOpenAIProviderGenerateStream(t *testing.T) {
+	provider := newOpenAITestProvider(t, ProviderConfig{Type: "openai", APIKey: "sk-test", BaseURL: "/v1"}, func(w http.ResponseWriter, r *http.Request) {
+		request := decodeOpenAIRequest(t, r)
+		if !request.Stream || request.StreamOptions == nil || !request.StreamOptions.IncludeUsage {
+			t.Errorf("stream request = %+v", request)
+		}
+		checkOpenAIMessages(t, request)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"choices":[{"index":0,"delta":{"role":"assistant"}}]}`,
+			`{"choices":[{"index":0,"delta":{"content":"Hi"}}]}`,
+			`{"choices":[{"index":0,"delta":{"content":" there"}}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":2}}`,
+			`[DONE]`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	var chunks []string
+	resp, err := provider.GenerateStream(context.Background(), testRequest, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(chunks, "|") != "Hi| there" {
+		t.Errorf("chunks = %q", chunks)
+	}
+	if resp.Text != "Hi there" || resp.InputTokens != 12 || resp.OutputTokens != 2 {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestOpenAIProviderGenerateStreamAborted(t *testing.T) {
+	provider := newOpenAITestProvider(t, ProviderConfig{Type: "openai", APIKey: "sk-test"}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+
+	abort := errors.New("client gone")
+	_, err := provider.GenerateStream(context.Background(), testRequest, func(chunk string) error {
+		return abort
+	})
+	if !errors.Is(err, abort) {
+		t.Errorf("GenerateStream() error = %v, want %v", err, abort)
+	}
+}
+
+func TestOpenAIProviderAzure(t *testing.T) {
+	provider := newOpenAITestProvider(t, ProviderConfig{Type: "azure", APIKey: "azure-key", APIVersion: "2024-06-01"}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openai/deployments/gpt-4o/chat/completions" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("api-version"); got != "2024-06-01" {
+			t.Errorf("api-version = %q", got)
+		}
+		if got := r.Header.Get("api-key"); got != "azure-key" {
+			t.Errorf("api-key = %q", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Errorf("Authorization = %q, Azure uses api-key", got)
+		}
+		checkOpenAIMessages(t, decodeOpenAIRequest(t, r))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"Hi"}}],"usage":{"prompt_tokens":5,"completion_tokens":1}}`)
+	})
+
+	resp, err := provider.Generate(context.Background(), testRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Hi" {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestOpenAIProviderCompatibleWithoutKey(t *testing.T) {
+	provider := newOpenAITestProvider(t, ProviderConfig{Type: "openai-compatible", BaseURL: "/v1"}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Errorf("Authorization = %q, want none", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"local"}}]}`)
+	})
+
+	resp, err := provider.Generate(context.Background(), testRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "local" {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestOpenAIProviderMissingKey(t *testing.T) {
+	called := false
+	provider := newOpenAITestProvider(t, ProviderConfig{Type: "openai"}, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	if _, err := provider.Generate(context.Background(), testRequest); err == nil {
+		t.Error("Generate() succeeded without an API key")
+	}
+	if _, err := provider.GenerateStream(context.Background(), testRequest, func(string) error { return nil }); err == nil {
+		t.Error("GenerateStream() succeeded without an API key")
+	}
+	if called {
+		t.Error("the server was called without an API key")
+	}
+}
+
+func TestOpenAIProviderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"rate limited", http.StatusTooManyRequests, `{"error":{"message":"slow down","type":"rate_limit"}}`},
+		{"server error", http.StatusInternalServerError, `upstream failed`},
+		{"no choices", http.StatusOK, `{"choices":[]}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newOpenAITestProvider(t, ProviderConfig{Type: "openai", APIKey: "sk-test"}, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.body)
+			})
+
+			if _, err := provider.Generate(context.Background(), testRequest); err == nil {
+				t.Error("Generate() succeeded, want an error")
+			}
+			if test.status != http.StatusOK {
+				if _, err := provider.GenerateStream(context.Background(), testRequest, func(string) error { return nil }); err == nil {
+					t.Error("GenerateStream() succeeded, want an error")
+				}
+			}
+		})
+	}
+}
+
+func TestOpenAIProviderEmbed(t *testing.T) {
+	provider := newOpenAITestProvider(t, ProviderConfig{Type: "openai", APIKey: "sk-test"}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		// the vectors may come back in any order, Index places them
+		fmt.Fprint(w, `{"data":[{"index":1,"embedding":[0.3,0.4]},{"index":0,"embedding":[0.1,0.2]}],"usage":{"prompt_tokens":4}}`)
+	})
+
+	resp, err := provider.(EmbeddingProvider).Embed(context.Background(), EmbedRequest{Model: "text-embedding-3-small", Input: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Vectors) != 2 || resp.Vectors[0][0] != 0.1 || resp.Vectors[1][0] != 0.3 || resp.InputTokens != 4 {
+		t.Errorf("response = %+v", resp)
+	}
+}
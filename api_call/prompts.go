@@ -1,6 +1,10 @@
 package api_call
 
-import "fmt"
+import (
+	"ai-chat/database/structures"
+	"fmt"
+	"strings"
+)
 
 func GetSummaryPrompt(existingSummary, newChats string) string {
 	return fmt.Sprintf(`
@@ -27,3 +31,49 @@ Provide only the updated summary as your output, without any additional text, he
 Updated Summary:
 `, existingSummary, newChats)
 }
+
+func GetTitlePrompt(message, reply string) string {
+	return fmt.Sprintf(`
+Write a title for the conversation below.
+
+User:
+%s
+
+Assistant:
+%s
+
+Instructions:
+1. Use at most 6 words which tell what the conversation is about.
+2. Write it in the language of the user.
+3. Don't use quotes, punctuation at the end or emojis.
+
+Provide only the title as your output, without any additional text, headings, or explanations.
+
+Title:
+`, message, reply)
+}
+
+func GetRecallPrompt(exchanges []string) string {
+	return fmt.Sprintf(`
+Excerpts of earlier conversations with the user which may relate to the next message. Use them only where they help to answer it.
+
+%s
+`, strings.Join(exchanges, "\n\n---\n\n"))
+}
+
+func GetDocumentPrompt(sources []structures.DocumentSource) string {
+	excerpts := make([]string, 0, len(sources))
+	for _, source := range sources {
+		location := source.SourceName
+		if source.Page > 0 {
+			location = fmt.Sprintf("%s, page %d", location, source.Page)
+		}
+		excerpts = append(excerpts, fmt.Sprintf("[%d] %s\n%s", source.Number, location, source.Content))
+	}
+
+	return fmt.Sprintf(`
+Excerpts of the files shared in this conversation which relate to the next message. Base the answer on them where they apply and cite them by their number, like [1].
+
+%s
+`, strings.Join(excerpts, "\n\n"))
+}
@@ -0,0 +1,167 @@
+package api_call
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGenerateTimeout = 30 * time.Second
+
+	ProviderTypeOllama           = "ollama"
+	ProviderTypeOpenAI           = "openai"
+	ProviderTypeOpenAICompatible = "openai-compatible"
+	ProviderTypeAzureOpenAI      = "azure"
+)
+
+type GenerateRequest struct {
+	Model        string
+	UserPrompt   string
+	SystemPrompt string
+	// MaxTokens limits the length of the response, 0 leaves it to the provider
+	MaxTokens int
+}
+
+// Provider is an LLM backend which can answer a prompt at once or chunk by chunk
+type Provider interface {
+	Generate(ctx context.Context, request GenerateRequest) (*GenerateResponse, error)
+	GenerateStream(ctx context.Context, request GenerateRequest, onChunk ChunkHandler) (*GenerateResponse, error)
+}
+
+// EmbedRequest asks for the embedding vectors of Input, one per text
+type EmbedRequest struct {
+	Model string
+	Input []string
+}
+
+type EmbedResponse struct {
+	Vectors     [][]float32
+	InputTokens int
+}
+
+// EmbeddingProvider is a provider which can also turn texts into embedding vectors
+type EmbeddingProvider interface {
+	Embed(ctx context.Context, request EmbedRequest) (*EmbedResponse, error)
+}
+
+// ProviderConfig configures one named provider.
+// A zero Timeout uses 30 seconds for Generate and the 10 minute request timeout for GenerateStream.
+type ProviderConfig struct {
+	Type       string
+	BaseURL    string
+	APIKey     string
+	APIVersion string
+	Timeout    time.Duration
+	Headers    map[string]string
+}
+
+// ProviderFactory creates a provider of one type from its configuration
+type ProviderFactory func(config ProviderConfig) (Provider, error)
+
+var providerTypes = struct {
+	sync.RWMutex
+	factories map[string]ProviderFactory
+}{
+	factories: map[string]ProviderFactory{
+		ProviderTypeOllama:           NewOllamaProvider,
+		ProviderTypeOpenAI:           NewOpenAIProvider,
+		ProviderTypeOpenAICompatible: NewOpenAIProvider,
+		ProviderTypeAzureOpenAI:      NewOpenAIProvider,
+	},
+}
+
+// RegisterProviderType makes a new provider type available to LLM_PROVIDER_<NAME>_TYPE
+func RegisterProviderType(providerType string, factory ProviderFactory) {
+	providerTypes.Lock()
+	defer providerTypes.Unlock()
+	providerTypes.factories[strings.ToLower(providerType)] = factory
+}
+
+// NewProvider creates a provider of config.Type
+func NewProvider(config ProviderConfig) (Provider, error) {
+	providerTypes.RLock()
+	factory, ok := providerTypes.factories[strings.ToLower(config.Type)]
+	providerTypes.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider type: %s", config.Type)
+	}
+	return factory(config)
+}
+
+// ProviderConfigFromEnv reads the LLM_PROVIDER_<NAME>_* variables of a provider:
+// TYPE, BASE_URL, API_KEY, API_VERSION, TIMEOUT_SECONDS and HEADERS as comma separated Name=Value pairs.
+// The built in "ollama" and "openai" providers fall back to OLLAMA_PORT and OPENAI_API_KEY.
+func ProviderConfigFromEnv(name string) ProviderConfig {
+	prefix := "LLM_PROVIDER_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name)) + "_"
+
+	config := ProviderConfig{
+		Type:       os.Getenv(prefix + "TYPE"),
+		BaseURL:    os.Getenv(prefix + "BASE_URL"),
+		APIKey:     os.Getenv(prefix + "API_KEY"),
+		APIVersion: os.Getenv(prefix + "API_VERSION"),
+		Headers:    map[string]string{},
+	}
+
+	if seconds, err := strconv.Atoi(os.Getenv(prefix + "TIMEOUT_SECONDS")); err == nil && seconds > 0 {
+		config.Timeout = time.Duration(seconds) * time.Second
+	}
+
+	for _, header := range strings.Split(os.Getenv(prefix+"HEADERS"), ",") {
+		if key, value, ok := strings.Cut(header, "="); ok {
+			config.Headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	switch strings.ToLower(name) {
+	case ProviderTypeOllama:
+		if config.Type == "" {
+			config.Type = ProviderTypeOllama
+		}
+		if config.BaseURL == "" {
+			config.BaseURL = fmt.Sprintf("http://ollama:%s", os.Getenv("OLLAMA_PORT"))
+		}
+	case ProviderTypeOpenAI:
+		if config.Type == "" {
+			config.Type = ProviderTypeOpenAI
+		}
+		if config.APIKey == "" {
+			config.APIKey = os.Getenv("OPENAI_API_KEY")
+		}
+	}
+	return config
+}
+
+// headerTransport adds the configured headers to every request of a provider
+type headerTransport struct {
+	headers map[string]string
+	base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func newHTTPClient(config ProviderConfig) *http.Client {
+	if len(config.Headers) == 0 {
+		return &http.Client{}
+	}
+	return &http.Client{Transport: &headerTransport{headers: config.Headers, base: http.DefaultTransport}}
+}
+
+// withTimeout bounds a request by the provider timeout, or by fallback when none is configured
+func withTimeout(ctx context.Context, config ProviderConfig, fallback time.Duration) (context.Context, context.CancelFunc) {
+	if config.Timeout > 0 {
+		fallback = config.Timeout
+	}
+	return context.WithTimeout(ctx, fallback)
+}
@@ -0,0 +1,139 @@
+package api_call
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProviderConfigFromEnv(t *testing.T) {
+	t.Setenv("LLM_PROVIDER_MY_GATEWAY_TYPE", "openai-compatible")
+	t.Setenv("LLM_PROVIDER_MY_GATEWAY_BASE_URL", "http://gateway:8000/v1")
+	t.Setenv("LLM_PROVIDER_MY_GATEWAY_API_KEY", "secret")
+	t.Setenv("LLM_PROVIDER_MY_GATEWAY_API_VERSION", "2024-06-01")
+	t.Setenv("LLM_PROVIDER_MY_GATEWAY_TIMEOUT_SECONDS", "45")
+	t.Setenv("LLM_PROVIDER_MY_GATEWAY_HEADERS", "X-Team = chat, X-Trace=on,invalid")
+
+	config := ProviderConfigFromEnv("my-gateway")
+
+	if config.Type != ProviderTypeOpenAICompatible {
+		t.Errorf("Type = %q, want %q", config.Type, ProviderTypeOpenAICompatible)
+	}
+	if config.BaseURL != "http://gateway:8000/v1" {
+		t.Errorf("BaseURL = %q", config.BaseURL)
+	}
+	if config.APIKey != "secret" {
+		t.Errorf("APIKey = %q", config.APIKey)
+	}
+	if config.APIVersion != "2024-06-01" {
+		t.Errorf("APIVersion = %q", config.APIVersion)
+	}
+	if config.Timeout != 45*time.Second {
+		t.Errorf("Timeout = %s, want 45s", config.Timeout)
+	}
+	if len(config.Headers) != 2 || config.Headers["X-Team"] != "chat" || config.Headers["X-Trace"] != "on" {
+		t.Errorf("Headers = %v", config.Headers)
+	}
+}
+
+func TestProviderConfigFromEnvInvalidTimeout(t *testing.T) {
+	for _, value := range []string{"", "soon", "0", "-5"} {
+		t.Setenv("LLM_PROVIDER_LOCAL_TIMEOUT_SECONDS", value)
+		if config := ProviderConfigFromEnv("local"); config.Timeout != 0 {
+			t.Errorf("TIMEOUT_SECONDS=%q gave Timeout %s, want 0", value, config.Timeout)
+		}
+	}
+}
+
+func TestProviderConfigFromEnvBuiltInFallbacks(t *testing.T) {
+	t.Setenv("OLLAMA_PORT", "11434")
+	t.Setenv("OPENAI_API_KEY", "sk-fallback")
+
+	ollama := ProviderConfigFromEnv("ollama")
+	if ollama.Type != ProviderTypeOllama || ollama.BaseURL != "http://ollama:11434" {
+		t.Errorf("ollama config = %+v", ollama)
+	}
+
+	openAI := ProviderConfigFromEnv("openai")
+	if openAI.Type != ProviderTypeOpenAI || openAI.APIKey != "sk-fallback" {
+		t.Errorf("openai config = %+v", openAI)
+	}
+
+	// the provider variables win over the fallbacks
+	t.Setenv("LLM_PROVIDER_OPENAI_TYPE", "azure")
+	t.Setenv("LLM_PROVIDER_OPENAI_API_KEY", "azure-key")
+	openAI = ProviderConfigFromEnv("openai")
+	if openAI.Type != ProviderTypeAzureOpenAI || openAI.APIKey != "azure-key" {
+		t.Errorf("overridden openai config = %+v", openAI)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ProviderConfig
+		wantErr bool
+	}{
+		{"ollama", ProviderConfig{Type: "ollama", BaseURL: "http://ollama:11434"}, false},
+		{"ollama without base URL", ProviderConfig{Type: "ollama"}, true},
+		{"openai", ProviderConfig{Type: "OpenAI", APIKey: "key"}, false},
+		{"compatible", ProviderConfig{Type: "openai-compatible", BaseURL: "http://vllm:8000/v1"}, false},
+		{"compatible without base URL", ProviderConfig{Type: "openai-compatible"}, true},
+		{"azure", ProviderConfig{Type: "azure", BaseURL: "https://example.openai.azure.com", APIKey: "key"}, false},
+		{"azure without key", ProviderConfig{Type: "azure", BaseURL: "https://example.openai.azure.com"}, true},
+		{"unknown", ProviderConfig{Type: "bedrock"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider, err := NewProvider(test.config)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("NewProvider() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if !test.wantErr && provider == nil {
+				t.Fatal("NewProvider() returned no provider")
+			}
+		})
+	}
+}
+
+func TestProviderHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Team"); got != "chat" {
+			t.Errorf("X-Team header = %q, want chat", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"response":"ok","done":true}`))
+	}))
+	defer server.Close()
+
+	provider, err := NewProvider(ProviderConfig{Type: "ollama", BaseURL: server.URL, Headers: map[string]string{"X-Team": "chat"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Generate(context.Background(), GenerateRequest{Model: "llama3"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProviderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	provider, err := NewProvider(ProviderConfig{Type: "ollama", BaseURL: server.URL, Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := provider.Generate(context.Background(), GenerateRequest{Model: "llama3"}); err == nil {
+		t.Fatal("Generate() succeeded, want a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Generate() took %s, the timeout wasn't applied", elapsed)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 	"log"
+	"os"
 )
 
 const (
@@ -48,7 +49,8 @@ func Unmarshal(data []byte, m *ClientResponse) {
 
 func main() {
 	// Connect to the WebSocket server
-	conn, _, _, err := ws.Dial(context.Background(), "ws://localhost:8080/ws")
+	// The server only accepts connections carrying a token signed with AUTH_SECRET_KEY
+	conn, _, _, err := ws.Dial(context.Background(), "ws://localhost:8080/ws?token="+os.Getenv("AUTH_TOKEN"))
 	if err != nil {
 		log.Fatalf("Failed to connect to server: %v", err)
 	}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"ai-chat/database/services"
+	"ai-chat/sync_worker/reconciler"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	"log"
+	"os"
+)
+
+// Runs one reconciliation on demand and prints the report as JSON.
+// Exits with status 1 when differences are left unrepaired.
+func main() {
+	repair := flag.String("repair", "none", "repair direction: none, cache (database wins) or database (cache wins)")
+	flag.Parse()
+
+	err := godotenv.Load(".env")
+	if err != nil {
+		log.Println("Unable to load .env")
+		return
+	}
+
+	direction, err := reconciler.ParseRepairDirection(*repair)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	database := services.GetDataBase()
+	report, err := reconciler.NewReconciler(database).Reconcile(context.Background(), direction)
+	if err != nil {
+		log.Fatalln("Reconciliation failed:", err)
+	}
+
+	data, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(data))
+
+	for _, difference := range report.Differences {
+		if !difference.Repaired {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"ai-chat/database/services"
+	"ai-chat/sync_worker/consumer"
+	"context"
+	"github.com/joho/godotenv"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Standalone stream consumer, use it instead of SYNC_WORKER_IN_PROCESS to scale persistence separately from the app.
+func main() {
+	err := godotenv.Load(".env")
+	if err != nil {
+		log.Println("Unable to load .env")
+		return
+	}
+
+	database := services.GetDataBase()
+	log.Println("Database connected")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := consumer.NewConsumer(database).Run(ctx); err != nil {
+		log.Println("Stream consumer failed:", err)
+	}
+}
@@ -1,6 +1,7 @@
 package initialize
 
 import (
+	"ai-chat/database/storage"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -20,6 +21,15 @@ func InitRedis() *redis.Client {
 	return redisDb
 }
 
+func InitStorage() storage.Storage {
+	files, err := storage.New()
+	if err != nil {
+		panic(err)
+	}
+
+	return files
+}
+
 func InitPostgres() *sqlx.DB {
 	databaseString := fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=disable",
 		os.Getenv("DB_HOST"),
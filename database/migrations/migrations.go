@@ -0,0 +1,81 @@
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Schema changes made after docker_postgres_init.sql are kept as numbered files in sql/ and applied in order
+// on startup. Applied files are recorded in Schema_Migrations so every file only runs once per database.
+
+//go:embed sql/*.sql
+var files embed.FS
+
+func Run(db *sqlx.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS Schema_Migrations (
+		Name VARCHAR(255) PRIMARY KEY,
+		Applied_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("unable to create migrations table: %w", err)
+	}
+
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".sql") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := apply(db, name); err != nil {
+			return fmt.Errorf("migration %s failed: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func apply(db *sqlx.DB, name string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// serialize instances starting at the same time
+	if _, err := tx.Exec(`LOCK TABLE Schema_Migrations IN EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	var applied bool
+	if err := tx.Get(&applied, `SELECT EXISTS (SELECT 1 FROM Schema_Migrations WHERE Name = $1)`, name); err != nil {
+		return err
+	}
+	if applied {
+		return nil
+	}
+
+	query, err := files.ReadFile("sql/" + name)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(string(query)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO Schema_Migrations (Name) VALUES ($1)`, name); err != nil {
+		return err
+	}
+
+	log.Println("Applied migration", name)
+	return tx.Commit()
+}
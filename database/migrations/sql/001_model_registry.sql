@@ -0,0 +1,24 @@
+-- Model_Details becomes the model registry: provider, pricing per 1K tokens, capabilities and an enabled flag
+ALTER TABLE Model_Details ADD COLUMN IF NOT EXISTS Provider VARCHAR(64) NOT NULL DEFAULT 'openai';
+ALTER TABLE Model_Details ADD COLUMN IF NOT EXISTS Input_Price DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE Model_Details ADD COLUMN IF NOT EXISTS Output_Price DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE Model_Details ADD COLUMN IF NOT EXISTS Capabilities TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE Model_Details ADD COLUMN IF NOT EXISTS Enabled BOOLEAN NOT NULL DEFAULT TRUE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_model_details_name ON Model_Details (Model_Name);
+
+-- Backfill the models which were seeded from the hard coded maps
+UPDATE Model_Details md
+SET Provider = seed.provider, Input_Price = seed.input_price, Output_Price = seed.output_price, Capabilities = seed.capabilities
+FROM (VALUES
+    ('gpt-3.5-turbo-0125', 'openai', 0.00050, 0.00150, ARRAY['chat', 'streaming']),
+    ('gpt-3.5-turbo', 'openai', 0.0030, 0.0060, ARRAY['chat', 'streaming']),
+    ('gpt-3.5-turbo-1106', 'openai', 0.0010, 0.0020, ARRAY['chat', 'streaming']),
+    ('gpt-3.5-turbo-instruct', 'openai', 0.00150, 0.00200, ARRAY['completion']),
+    ('gpt-4-turbo', 'openai', 0.0100, 0.0300, ARRAY['chat', 'streaming', 'vision']),
+    ('gpt-4-turbo-2024-04-09', 'openai', 0.0100, 0.0300, ARRAY['chat', 'streaming', 'vision']),
+    ('gpt-4', 'openai', 0.0300, 0.0600, ARRAY['chat', 'streaming']),
+    ('gpt-4-32k', 'openai', 0.0600, 0.1200, ARRAY['chat', 'streaming']),
+    ('llama3.1:8b', 'ollama', 0.00150, 0.00200, ARRAY['chat', 'streaming'])
+) AS seed (name, provider, input_price, output_price, capabilities)
+WHERE md.Model_Name = seed.name;
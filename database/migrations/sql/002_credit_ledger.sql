@@ -0,0 +1,59 @@
+-- Every balance change is recorded in Credit_Ledger, User_Data.Balance is kept equal to the sum of a user's entries
+CREATE TABLE IF NOT EXISTS Credit_Ledger (
+    Entry_Id UUID PRIMARY KEY,
+    User_Id UUID NOT NULL REFERENCES User_Data(User_Id) ON DELETE CASCADE,
+    Amount DOUBLE PRECISION NOT NULL,
+    Reason VARCHAR(32) NOT NULL,
+    Model_Id INT,
+    Session_Id UUID,
+    Input_Tokens INT NOT NULL DEFAULT 0,
+    Output_Tokens INT NOT NULL DEFAULT 0,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_ledger_user ON Credit_Ledger (User_Id, Created_At);
+
+-- The existing balances become the opening entries of the ledger
+UPDATE User_Data SET Balance = 0 WHERE Balance IS NULL;
+
+INSERT INTO Credit_Ledger (Entry_Id, User_Id, Amount, Reason)
+SELECT uuid_generate_v4(), User_Id, Balance, 'opening_balance'
+FROM User_Data ud
+WHERE NOT EXISTS (SELECT 1 FROM Credit_Ledger cl WHERE cl.User_Id = ud.User_Id);
+
+-- Apply every entry to User_Data.Balance. Opening entries mirror the balance a user was created with, so they are not applied again.
+CREATE OR REPLACE FUNCTION apply_credit_ledger() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.Reason <> 'opening_balance' THEN
+        UPDATE User_Data SET Balance = COALESCE(Balance, 0) + NEW.Amount WHERE User_Id = NEW.User_Id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_apply_credit_ledger ON Credit_Ledger;
+CREATE TRIGGER trg_apply_credit_ledger
+    AFTER INSERT ON Credit_Ledger
+    FOR EACH ROW
+    EXECUTE FUNCTION apply_credit_ledger();
+
+-- Users created with a balance get it recorded as their opening entry
+CREATE OR REPLACE FUNCTION open_credit_ledger() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO Credit_Ledger (Entry_Id, User_Id, Amount, Reason)
+    VALUES (uuid_generate_v4(), NEW.User_Id, COALESCE(NEW.Balance, 0), 'opening_balance');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_open_credit_ledger ON User_Data;
+CREATE TRIGGER trg_open_credit_ledger
+    AFTER INSERT ON User_Data
+    FOR EACH ROW
+    EXECUTE FUNCTION open_credit_ledger();
+
+-- Balance as derived from the ledger, for audits against User_Data.Balance
+CREATE OR REPLACE VIEW Ledger_Balance AS
+SELECT User_Id, SUM(Amount) AS Balance, COUNT(*) AS Entries, MAX(Created_At) AS Last_Entry_At
+FROM Credit_Ledger
+GROUP BY User_Id;
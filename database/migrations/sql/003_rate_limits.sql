@@ -0,0 +1,3 @@
+-- Users get a tier which selects their rate limits, models can limit how often each user calls them
+ALTER TABLE User_Data ADD COLUMN IF NOT EXISTS Tier VARCHAR(32) NOT NULL DEFAULT 'default';
+ALTER TABLE Model_Details ADD COLUMN IF NOT EXISTS Rate_Limit_Per_Minute INT NOT NULL DEFAULT 0;
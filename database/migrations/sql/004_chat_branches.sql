@@ -0,0 +1,3 @@
+-- Chats carry ids and parent ids so sessions can branch, the session remembers the last chat of the branch it continues on.
+-- Chats stored before carry no id, they get md5('<session_id>:<position>')::uuid when they're read.
+ALTER TABLE Chat_Details ADD COLUMN IF NOT EXISTS Active_Leaf_Id VARCHAR(36) NOT NULL DEFAULT '';
@@ -0,0 +1,69 @@
+-- Every chat is a row of Chat_Messages instead of an element of the Chat_Details.Chats array, so chats can be read
+-- a branch or a page at a time and carry the model, tokens and cost of the reply along with the files attached to them
+CREATE TABLE IF NOT EXISTS Chat_Messages (
+    Message_Id UUID PRIMARY KEY,
+    Session_Id UUID NOT NULL REFERENCES Session_Details(Session_Id) ON DELETE CASCADE,
+    Parent_Id UUID,
+    Position BIGINT GENERATED ALWAYS AS IDENTITY,
+    Role VARCHAR(32) NOT NULL,
+    Content TEXT NOT NULL,
+    Model_Id INT,
+    Input_Tokens INT NOT NULL DEFAULT 0,
+    Output_Tokens INT NOT NULL DEFAULT 0,
+    Cost DOUBLE PRECISION NOT NULL DEFAULT 0,
+    Attachments TEXT[] NOT NULL DEFAULT '{}',
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_messages_session ON Chat_Messages (Session_Id, Position);
+CREATE INDEX IF NOT EXISTS idx_chat_messages_parent ON Chat_Messages (Parent_Id);
+
+-- Chats stored without an id get md5('<session_id>:<position>')::uuid and follow the chat stored before them,
+-- the same ids they were given when they were read
+CREATE TEMP TABLE Exploded_Chats ON COMMIT DROP AS
+WITH numbered AS (
+    SELECT cd.Session_Id, c.Position, c.Chat,
+           NULLIF(c.Chat->>'id', '') AS Stored_Id,
+           COALESCE(NULLIF(c.Chat->>'id', ''), md5(cd.Session_Id::TEXT || ':' || c.Position)::UUID::TEXT) AS Message_Id
+    FROM Chat_Details cd
+    CROSS JOIN LATERAL jsonb_array_elements(cd.Chats) WITH ORDINALITY AS c(Chat, Position)
+)
+SELECT Session_Id, Position, Message_Id,
+       COALESCE(NULLIF(Chat->>'parent_id', ''),
+                CASE WHEN Stored_Id IS NULL THEN LAG(Message_Id) OVER (PARTITION BY Session_Id ORDER BY Position) END) AS Parent_Id,
+       COALESCE(Chat->>'role', '') AS Role,
+       COALESCE(Chat->>'content', '') AS Content
+FROM numbered;
+
+-- Files were stored as {"role": "file"} chats following the reply to the message they were sent with, they become
+-- attachments of that message and the chats following them follow the reply instead
+INSERT INTO Chat_Messages (Message_Id, Session_Id, Parent_Id, Role, Content, Attachments, Created_At)
+SELECT m.Message_Id::UUID,
+       m.Session_Id,
+       (CASE WHEN p.Role = 'file' THEN p.Parent_Id ELSE m.Parent_Id END)::UUID,
+       m.Role,
+       m.Content,
+       ARRAY(
+           SELECT f.Content
+           FROM Exploded_Chats r
+           JOIN Exploded_Chats f ON f.Session_Id = r.Session_Id AND f.Parent_Id = r.Message_Id AND f.Role = 'file'
+           WHERE r.Session_Id = m.Session_Id AND r.Parent_Id = m.Message_Id
+           ORDER BY f.Position
+       ),
+       sd.Created_At
+FROM Exploded_Chats m
+JOIN Session_Details sd ON sd.Session_Id = m.Session_Id
+LEFT JOIN Exploded_Chats p ON p.Session_Id = m.Session_Id AND p.Message_Id = m.Parent_Id
+WHERE m.Role <> 'file'
+ORDER BY m.Session_Id, m.Position
+ON CONFLICT (Message_Id) DO NOTHING;
+
+-- Chat_Details keeps the prompt, summary and active branch of the session
+DROP TRIGGER IF EXISTS trg_append_chat_jsonb ON Chat_Details;
+DROP FUNCTION IF EXISTS append_chat_jsonb();
+
+UPDATE Chat_Details cd SET Active_Leaf_Id = COALESCE(e.Parent_Id, '')
+FROM Exploded_Chats e
+WHERE e.Session_Id = cd.Session_Id AND e.Message_Id = cd.Active_Leaf_Id AND e.Role = 'file';
+
+ALTER TABLE Chat_Details DROP COLUMN IF EXISTS Chats;
@@ -0,0 +1,51 @@
+-- Sessions keep track of their activity, size and cost so they can be listed by their last message
+ALTER TABLE Session_Details ADD COLUMN IF NOT EXISTS Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE Session_Details ADD COLUMN IF NOT EXISTS Last_Message_At TIMESTAMP;
+ALTER TABLE Session_Details ADD COLUMN IF NOT EXISTS Message_Count INT NOT NULL DEFAULT 0;
+ALTER TABLE Session_Details ADD COLUMN IF NOT EXISTS Total_Cost DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+UPDATE Session_Details sd SET Message_Count = m.Messages, Last_Message_At = m.Last_Message_At, Updated_At = m.Last_Message_At
+FROM (SELECT Session_Id, COUNT(*) AS Messages, MAX(Created_At) AS Last_Message_At FROM Chat_Messages GROUP BY Session_Id) m
+WHERE m.Session_Id = sd.Session_Id;
+
+UPDATE Session_Details sd SET Total_Cost = l.Cost
+FROM (SELECT Session_Id, -SUM(Amount) AS Cost FROM Credit_Ledger WHERE Reason IN ('chat', 'summary') AND Session_Id IS NOT NULL GROUP BY Session_Id) l
+WHERE l.Session_Id = sd.Session_Id;
+
+UPDATE Session_Details SET Updated_At = Created_At WHERE Last_Message_At IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_session_details_activity ON Session_Details (User_Id, (COALESCE(Last_Message_At, Created_At)) DESC, Session_Id DESC);
+
+-- Every stored message counts towards the activity of its session
+CREATE OR REPLACE FUNCTION track_session_messages() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE Session_Details
+    SET Message_Count = Message_Count + 1,
+        Last_Message_At = GREATEST(Last_Message_At, NEW.Created_At),
+        Updated_At = GREATEST(Updated_At, NEW.Created_At)
+    WHERE Session_Id = NEW.Session_Id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_track_session_messages ON Chat_Messages;
+CREATE TRIGGER trg_track_session_messages
+    AFTER INSERT ON Chat_Messages
+    FOR EACH ROW
+    EXECUTE FUNCTION track_session_messages();
+
+-- Chats and summaries charged to a session add up to its cost
+CREATE OR REPLACE FUNCTION track_session_cost() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.Session_Id IS NOT NULL AND NEW.Reason IN ('chat', 'summary') THEN
+        UPDATE Session_Details SET Total_Cost = Total_Cost - NEW.Amount WHERE Session_Id = NEW.Session_Id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_track_session_cost ON Credit_Ledger;
+CREATE TRIGGER trg_track_session_cost
+    AFTER INSERT ON Credit_Ledger
+    FOR EACH ROW
+    EXECUTE FUNCTION track_session_cost();
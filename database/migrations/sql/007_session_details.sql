@@ -0,0 +1,6 @@
+-- Sessions can be pinned, archived and tagged by their user
+ALTER TABLE Session_Details ADD COLUMN IF NOT EXISTS Pinned BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE Session_Details ADD COLUMN IF NOT EXISTS Archived BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE Session_Details ADD COLUMN IF NOT EXISTS Tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_session_details_tags ON Session_Details USING GIN (Tags);
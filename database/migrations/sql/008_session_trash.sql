@@ -0,0 +1,4 @@
+-- Deleted sessions are kept in the trash until they are restored or purged
+ALTER TABLE Session_Details ADD COLUMN IF NOT EXISTS Deleted_At TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_session_details_deleted_at ON Session_Details (Deleted_At) WHERE Deleted_At IS NOT NULL;
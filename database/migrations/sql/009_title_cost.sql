@@ -0,0 +1,9 @@
+-- Generated session titles are charged to their session like chats and summaries
+CREATE OR REPLACE FUNCTION track_session_cost() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.Session_Id IS NOT NULL AND NEW.Reason IN ('chat', 'summary', 'title') THEN
+        UPDATE Session_Details SET Total_Cost = Total_Cost - NEW.Amount WHERE Session_Id = NEW.Session_Id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,5 @@
+-- Summaries are written by background jobs, the session remembers the last chat its summary covers.
+-- Summaries were updated after every turn before, so they cover the active branch.
+ALTER TABLE Chat_Details ADD COLUMN IF NOT EXISTS Summary_Leaf_Id VARCHAR(36) NOT NULL DEFAULT '';
+
+UPDATE Chat_Details SET Summary_Leaf_Id = Active_Leaf_Id WHERE Chats_Summary <> '';
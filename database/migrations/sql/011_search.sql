@@ -0,0 +1,4 @@
+-- Full text search over the chat messages and session names, the expressions must match the ones of SearchChats
+CREATE INDEX IF NOT EXISTS idx_chat_messages_search ON Chat_Messages USING GIN (to_tsvector('english', Content));
+
+CREATE INDEX IF NOT EXISTS idx_session_details_search ON Session_Details USING GIN (to_tsvector('english', Session_Name));
@@ -0,0 +1,13 @@
+-- Uploaded documents are split into chunks which are embedded for retrieval, the status of every file is tracked here
+CREATE TABLE IF NOT EXISTS Document_Ingestion (
+    File_Name VARCHAR(255) PRIMARY KEY,
+    Session_Id UUID NOT NULL REFERENCES Session_Details(Session_Id) ON DELETE CASCADE,
+    Source_Name TEXT NOT NULL DEFAULT '',
+    Status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    Chunks INT NOT NULL DEFAULT 0,
+    Error TEXT NOT NULL DEFAULT '',
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_ingestion_session ON Document_Ingestion (Session_Id);
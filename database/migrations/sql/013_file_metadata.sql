@@ -0,0 +1,34 @@
+-- Every uploaded file is a row of File_Metadata instead of an element of the File_Data.File_Name array, so the name it
+-- was uploaded with, its type, size, uploader and hash are kept. Position keeps the files of a session in upload order.
+CREATE TABLE IF NOT EXISTS File_Metadata (
+    File_Name VARCHAR(255) PRIMARY KEY,
+    Session_Id UUID NOT NULL REFERENCES Session_Details(Session_Id) ON DELETE CASCADE,
+    Position BIGINT GENERATED ALWAYS AS IDENTITY,
+    Original_Name TEXT NOT NULL DEFAULT '',
+    Mime_Type VARCHAR(255) NOT NULL DEFAULT 'application/octet-stream',
+    Size BIGINT NOT NULL DEFAULT 0,
+    Uploaded_By UUID REFERENCES User_Data(User_Id) ON DELETE SET NULL,
+    Content_Hash VARCHAR(64) NOT NULL DEFAULT '',
+    Status VARCHAR(16) NOT NULL DEFAULT 'stored',
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_metadata_session ON File_Metadata (Session_Id, Position);
+
+-- The arrays only kept the generated names; the original name of ingested documents is known, the size and hash
+-- of older files are left empty and their owner is taken to be the uploader
+DO $$
+BEGIN
+    IF EXISTS (SELECT * FROM information_schema.tables WHERE table_name = 'file_data') THEN
+        INSERT INTO File_Metadata (File_Name, Session_Id, Original_Name, Uploaded_By, Created_At)
+        SELECT f.File_Name, fd.Session_Id, COALESCE(di.Source_Name, f.File_Name), sd.User_Id, fd.Created_At
+        FROM File_Data fd
+        JOIN Session_Details sd ON sd.Session_Id = fd.Session_Id
+        CROSS JOIN LATERAL unnest(fd.File_Name) WITH ORDINALITY AS f(File_Name, Position)
+        LEFT JOIN Document_Ingestion di ON di.File_Name = f.File_Name
+        ORDER BY fd.Session_Id, f.Position
+        ON CONFLICT (File_Name) DO NOTHING;
+
+        DROP TABLE File_Data;
+    END IF;
+END $$;
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultCacheTTL = 24 * time.Hour
+
+// cacheTTL is how long an untouched user or session hash stays in Redis, CACHE_TTL_MINUTES=0 disables expiry
+func cacheTTL() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("CACHE_TTL_MINUTES"))
+	if err != nil || minutes < 0 {
+		return defaultCacheTTL
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// setCachedFieldsScript writes the field value pairs ARGV[2..] into the hash KEYS[1] and extends its expiry to
+// ARGV[1] seconds, 0 keeps it. Nothing is written when the hash isn't cached; a hash holding only these fields
+// couldn't be read and would hide the stored values until it expired.
+var setCachedFieldsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 2))
+local ttl = tonumber(ARGV[1])
+if ttl > 0 then
+	redis.call('EXPIRE', KEYS[1], ttl)
+else
+	redis.call('PERSIST', KEYS[1])
+end
+return 1
+`)
+
+// setCachedFields writes fields into a cached hash and extends its expiry, a hash which isn't cached is skipped
+func (dataBase *Database) setCachedFields(ctx context.Context, key string, fields map[string]interface{}) error {
+	args := make([]interface{}, 0, 1+2*len(fields))
+	args = append(args, int64(cacheTTL()/time.Second))
+	for field, value := range fields {
+		args = append(args, field, value)
+	}
+	return setCachedFieldsScript.Run(ctx, dataBase.Cache, []string{key}, args...).Err()
+}
+
+// touchCache extends the expiry of a cached hash after it was written or loaded
+func (dataBase *Database) touchCache(ctx context.Context, key string) error {
+	ttl := cacheTTL()
+	if ttl == 0 {
+		return dataBase.Cache.Persist(ctx, key).Err()
+	}
+	return dataBase.Cache.Expire(ctx, key, ttl).Err()
+}
+
+// loadUserIntoCache reads the user from Postgres into the user:<id> hash after a cache miss.
+// redis.Nil is returned when the user doesn't exist. Ledger entries still waiting in the stream are applied to the
+// balance, otherwise the expired hash would come back without them.
+func (dataBase *Database) loadUserIntoCache(ctx context.Context, userId string) error {
+	user, err := dataBase.GetUserRecord(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("error loading user from database: %w", err)
+	}
+	if user == nil {
+		return redis.Nil
+	}
+
+	user.Balance, err = dataBase.pendingBalance(ctx, userId, user.Balance)
+	if err != nil {
+		return fmt.Errorf("error applying pending ledger entries: %w", err)
+	}
+
+	return dataBase.CacheUserRecord(ctx, *user)
+}
+
+// loadSessionIntoCache reads the session from Postgres into the user:<id>:session:<id> hash after a cache miss.
+// redis.Nil is returned when the session doesn't exist or is in the trash, ErrSessionForbidden when it belongs to
+// another user.
+func (dataBase *Database) loadSessionIntoCache(ctx context.Context, userId string, sessionId string) error {
+	session, err := dataBase.GetSessionRecord(ctx, sessionId)
+	if err != nil {
+		return fmt.Errorf("error loading session from database: %w", err)
+	}
+	if session == nil || session.DeletedAt != nil {
+		return redis.Nil
+	}
+	if session.UserId != userId {
+		return ErrSessionForbidden
+	}
+
+	return dataBase.CacheSessionRecord(*session)
+}
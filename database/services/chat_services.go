@@ -0,0 +1,221 @@
+package services
+
+import (
+	"ai-chat/database/structures"
+	"ai-chat/utils/helper_functions"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"os"
+	"strconv"
+	"time"
+)
+
+const chatMessageColumns = `Message_Id, Parent_Id, Role, Content, Model_Id, Input_Tokens, Output_Tokens, Cost, Attachments, Created_At`
+
+// GetSessionChats loads every chat of every branch of the session in the order they were written
+func (dataBase *Database) GetSessionChats(ctx context.Context, sessionId string) ([]structures.Chat, error) {
+	query := `SELECT ` + chatMessageColumns + ` FROM Chat_Messages WHERE Session_Id = $1 ORDER BY Position`
+	rows, err := dataBase.Db.QueryContext(ctx, query, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanChats(rows)
+}
+
+// GetBranchChats loads at most limit chats of the branch ending at leafId, oldest first, the whole branch when limit
+// is 0. An empty leafId selects the chat written last, nothing is returned for an unknown one.
+func (dataBase *Database) GetBranchChats(ctx context.Context, sessionId string, leafId string, limit int) ([]structures.Chat, error) {
+	query := `
+	WITH RECURSIVE leaf AS (
+		SELECT Message_Id FROM Chat_Messages
+		WHERE Session_Id = $1 AND ($2 = '' OR Message_Id::TEXT = $2)
+		ORDER BY Position DESC
+		LIMIT 1
+	), branch AS (
+		SELECT m.*, 1 AS Depth FROM Chat_Messages m JOIN leaf ON m.Message_Id = leaf.Message_Id
+		UNION ALL
+		SELECT m.*, b.Depth + 1 FROM Chat_Messages m JOIN branch b ON m.Message_Id = b.Parent_Id
+		WHERE $3::INT <= 0 OR b.Depth < $3
+	)
+	SELECT ` + chatMessageColumns + ` FROM branch ORDER BY Depth DESC
+	`
+	rows, err := dataBase.Db.QueryContext(ctx, query, sessionId, leafId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanChats(rows)
+}
+
+func scanChats(rows *sql.Rows) ([]structures.Chat, error) {
+	var chats []structures.Chat
+	for rows.Next() {
+		var chat structures.Chat
+		var parentId sql.NullString
+		var modelId sql.NullInt64
+		if err := rows.Scan(&chat.Id, &parentId, &chat.Role, &chat.Content, &modelId, &chat.InputTokens, &chat.OutputTokens,
+			&chat.Cost, pq.Array(&chat.Attachments), &chat.CreatedAt); err != nil {
+			return nil, err
+		}
+		chat.ParentId = parentId.String
+		chat.ModelId = int(modelId.Int64)
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+// addChatMessages stores the chats written by one chat turn and returns the id of the last one. Stream entries can
+// be delivered more than once, so chats which are already stored are skipped. Entries written before chats had ids
+// get new ones, following the active branch of the session.
+func addChatMessages(ctx context.Context, tx *sqlx.Tx, sessionId string, chats string) (string, error) {
+	var chatsList []structures.Chat
+	if err := json.Unmarshal([]byte(chats), &chatsList); err != nil {
+		return "", fmt.Errorf("error parsing chats data: %w", err)
+	}
+	if len(chatsList) == 0 {
+		return "", nil
+	}
+
+	var leafId string
+	if chatsList[0].Id == "" {
+		if err := tx.GetContext(ctx, &leafId, `SELECT Active_Leaf_Id FROM Chat_Details WHERE Session_Id = $1`, sessionId); err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+	}
+	for i := range chatsList {
+		if chatsList[i].Id == "" {
+			chatsList[i].Id = uuid.NewString()
+			chatsList[i].ParentId = leafId
+		}
+		leafId = chatsList[i].Id
+	}
+
+	query := `
+	INSERT INTO Chat_Messages (Message_Id, Session_Id, Parent_Id, Role, Content, Model_Id, Input_Tokens, Output_Tokens, Cost, Attachments, Created_At)
+	VALUES ($1, $2, NULLIF($3, '')::UUID, $4, $5, NULLIF($6, 0), $7, $8, $9, $10, $11)
+	ON CONFLICT (Message_Id) DO NOTHING
+	`
+	chatsList, leafId = helper_functions.FoldFileChats(chatsList, leafId)
+	for _, chat := range chatsList {
+		createdAt := chat.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now().UTC()
+		}
+		attachments := chat.Attachments
+		if attachments == nil {
+			attachments = []string{}
+		}
+
+		if _, err := tx.ExecContext(ctx, query, chat.Id, sessionId, chat.ParentId, chat.Role, chat.Content, chat.ModelId,
+			chat.InputTokens, chat.OutputTokens, chat.Cost, pq.Array(attachments), createdAt); err != nil {
+			return "", fmt.Errorf("failed to add chat message: %w", err)
+		}
+	}
+	return leafId, nil
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// ChatPageSize is the number of chats sent per page when the client doesn't ask for a number, CHAT_PAGE_SIZE
+// overrides it. Pages never hold more than maxPageSize chats.
+func ChatPageSize(limit int) int {
+	return pageSize(limit, "CHAT_PAGE_SIZE")
+}
+
+func pageSize(limit int, env string) int {
+	if limit <= 0 {
+		limit = defaultPageSize
+		if size, err := strconv.Atoi(os.Getenv(env)); err == nil && size > 0 {
+			limit = size
+		}
+	}
+	return min(limit, maxPageSize)
+}
+
+// GetBranchPage returns at most limit chats of the branch ending at startId, newest first, along with the id of
+// the chat before them, which is empty once the first chat of the session is reached. The latest chats come from
+// the cached session, which holds those not persisted yet, older ones from Postgres.
+func (dataBase *Database) GetBranchPage(ctx context.Context, sessionData structures.SessionData, startId string, limit int) ([]structures.Chat, string, error) {
+	cached := make(map[string]structures.Chat, len(sessionData.Chats))
+	for _, chat := range sessionData.Chats {
+		cached[chat.Id] = chat
+	}
+
+	var page []structures.Chat
+	id := startId
+	for id != "" && len(page) < limit {
+		chat, ok := cached[id]
+		if !ok {
+			break
+		}
+		page = append(page, chat)
+		id = chat.ParentId
+	}
+
+	if id != "" && len(page) < limit {
+		stored, err := dataBase.GetBranchChats(ctx, sessionData.SessionId, id, limit-len(page))
+		if err != nil {
+			return nil, "", err
+		}
+		for i := len(stored) - 1; i >= 0; i-- {
+			page = append(page, stored[i])
+		}
+	}
+
+	if len(page) == 0 {
+		return nil, "", nil
+	}
+	return page, page[len(page)-1].ParentId, nil
+}
+
+// GetPageBranches lists, for every chat of the page with alternatives, the ids of all chats sharing its parent
+func (dataBase *Database) GetPageBranches(ctx context.Context, sessionData structures.SessionData, page []structures.Chat) (map[string][]string, error) {
+	parentIds := make([]string, 0, len(page))
+	for _, chat := range page {
+		parentIds = append(parentIds, chat.ParentId)
+	}
+
+	query := `
+	SELECT Message_Id, COALESCE(Parent_Id::TEXT, '') FROM Chat_Messages
+	WHERE Session_Id = $1 AND COALESCE(Parent_Id::TEXT, '') = ANY($2)
+	ORDER BY Position
+	`
+	rows, err := dataBase.Db.QueryContext(ctx, query, sessionData.SessionId, pq.Array(parentIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var siblings []structures.Chat
+	stored := make(map[string]bool)
+	for rows.Next() {
+		var chat structures.Chat
+		if err := rows.Scan(&chat.Id, &chat.ParentId); err != nil {
+			return nil, err
+		}
+		siblings = append(siblings, chat)
+		stored[chat.Id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// cached chats which aren't persisted yet were written last
+	for _, chat := range sessionData.Chats {
+		if !stored[chat.Id] {
+			siblings = append(siblings, chat)
+		}
+	}
+	return helper_functions.Branches(siblings, page), nil
+}
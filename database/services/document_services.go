@@ -0,0 +1,267 @@
+package services
+
+import (
+	"ai-chat/database/structures"
+	"ai-chat/utils/documents"
+	"ai-chat/utils/helper_functions"
+	"ai-chat/utils/response_code/messages"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultDocumentChunkSize    = 1000
+	defaultDocumentChunkOverlap = 150
+	defaultDocumentMaxChunks    = 500
+	defaultDocumentTopK         = 4
+	defaultIngestionWorkers     = 2
+
+	documentIndex     = "idx:documents"
+	documentKeyPrefix = "document:session:"
+	ingestionQueueKey = "ingestion:queue"
+	// chunks are embedded this many at a time
+	documentEmbedBatch = 64
+	// errors are cut to this many characters before they're stored and sent
+	maxDocumentErrorLength = 255
+)
+
+// documentSetting reads a positive number from the variable env, fallback when it isn't set
+func documentSetting(env string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(env)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+// UserEventsChannel is the Redis channel of the messages sent to every WebSocket connection of the user
+func UserEventsChannel(userId string) string {
+	return fmt.Sprintf("user:%s:events", userId)
+}
+
+// EnsureDocumentIndex creates the Redis Stack vector index of the document chunks unless it exists
+func (dataBase *Database) EnsureDocumentIndex(ctx context.Context) error {
+	return dataBase.createVectorIndex(ctx, documentIndex, documentKeyPrefix, "session_id", "file_name")
+}
+
+// EnqueueIngestion marks the file as pending and adds it to the queue worked off by RunIngestionWorkers
+func (dataBase *Database) EnqueueIngestion(ctx context.Context, job structures.IngestionJob) (structures.DocumentInfo, error) {
+	query := `
+	INSERT INTO Document_Ingestion (File_Name, Session_Id, Source_Name, Status)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (File_Name) DO UPDATE SET Status = EXCLUDED.Status, Chunks = 0, Error = '', Updated_At = CURRENT_TIMESTAMP
+	RETURNING File_Name, Source_Name, Status, Chunks, Error, Updated_At
+	`
+	var info structures.DocumentInfo
+	if err := dataBase.Db.GetContext(ctx, &info, query, job.FileName, job.SessionId, job.SourceName, structures.DocumentStatusPending); err != nil {
+		return info, fmt.Errorf("failed to store document status: %w", err)
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return info, err
+	}
+	if err := dataBase.Cache.RPush(ctx, ingestionQueueKey, data).Err(); err != nil {
+		return info, err
+	}
+
+	dataBase.publishDocumentStatus(ctx, job.UserId, job.SessionId, info)
+	return info, nil
+}
+
+// RunIngestionWorkers works off the ingestion queue with INGESTION_WORKERS workers until the context is cancelled
+func RunIngestionWorkers(ctx context.Context, dataBase *Database) {
+	dataBase.runQueueWorkers(ctx, ingestionQueueKey, "INGESTION_WORKERS", defaultIngestionWorkers, func(ctx context.Context, data []byte) error {
+		var job structures.IngestionJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			return fmt.Errorf("failed to parse ingestion job: %w", err)
+		}
+		if err := dataBase.IngestDocument(ctx, job); err != nil {
+			return fmt.Errorf("failed to ingest file %s: %w", job.FileName, err)
+		}
+		return nil
+	})
+}
+
+// IngestDocument extracts the text of an uploaded file, splits it into chunks of DOCUMENT_CHUNK_SIZE characters
+// and stores their embeddings in the document index. The file is marked ready or failed and the user's connections
+// are told either way.
+func (dataBase *Database) IngestDocument(ctx context.Context, job structures.IngestionJob) error {
+	chunks, err := dataBase.storeDocumentChunks(ctx, job)
+	status, message := structures.DocumentStatusReady, ""
+	if err != nil {
+		status, message = structures.DocumentStatusFailed, helper_functions.TruncateText(err.Error(), maxDocumentErrorLength)
+	}
+
+	query := `
+	UPDATE Document_Ingestion SET Status = $2, Chunks = $3, Error = $4, Updated_At = CURRENT_TIMESTAMP
+	WHERE File_Name = $1
+	RETURNING File_Name, Source_Name, Status, Chunks, Error, Updated_At
+	`
+	var info structures.DocumentInfo
+	if updateErr := dataBase.Db.GetContext(ctx, &info, query, job.FileName, status, chunks, message); errors.Is(updateErr, sql.ErrNoRows) {
+		// the file was removed while it was ingested
+		return dataBase.deleteKeys(ctx, documentKeyPrefix+job.SessionId+":"+job.FileName+":*")
+	} else if updateErr != nil {
+		return fmt.Errorf("failed to store document status: %w", updateErr)
+	}
+
+	dataBase.publishDocumentStatus(ctx, job.UserId, job.SessionId, info)
+	return err
+}
+
+// storeDocumentChunks replaces the chunks of the file in the document index and returns how many there are
+func (dataBase *Database) storeDocumentChunks(ctx context.Context, job structures.IngestionJob) (int, error) {
+	file, _, err := dataBase.Files.Get(ctx, job.FileName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open the file: %w", err)
+	}
+	pages, err := documents.Extract(file, job.FileName)
+	file.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	chunks := documents.Split(pages,
+		documentSetting("DOCUMENT_CHUNK_SIZE", defaultDocumentChunkSize),
+		documentSetting("DOCUMENT_CHUNK_OVERLAP", defaultDocumentChunkOverlap))
+	if len(chunks) == 0 {
+		return 0, fmt.Errorf("no text found in the file")
+	}
+	if maxChunks := documentSetting("DOCUMENT_MAX_CHUNKS", defaultDocumentMaxChunks); len(chunks) > maxChunks {
+		return 0, fmt.Errorf("the file is split into %d chunks, at most %d are allowed", len(chunks), maxChunks)
+	}
+
+	if err := dataBase.deleteKeys(ctx, documentKeyPrefix+job.SessionId+":"+job.FileName+":*"); err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(chunks); start += documentEmbedBatch {
+		batch := chunks[start:min(start+documentEmbedBatch, len(chunks))]
+		inputs := make([]string, 0, len(batch))
+		for _, chunk := range batch {
+			inputs = append(inputs, chunk.Content)
+		}
+
+		vectors, err := dataBase.AIService.ApiEmbeddings(inputs, EmbeddingProvider(), EmbeddingModel())
+		if err != nil {
+			return 0, fmt.Errorf("unable to embed the file: %w", err)
+		}
+
+		pipe := dataBase.Cache.Pipeline()
+		for i, chunk := range batch {
+			if len(vectors[i].Data) != EmbeddingDimensions() {
+				return 0, fmt.Errorf("embedding has %d dimensions, the index expects %d", len(vectors[i].Data), EmbeddingDimensions())
+			}
+			key := fmt.Sprintf("%s%s:%s:%d", documentKeyPrefix, job.SessionId, job.FileName, chunk.Index)
+			pipe.HSet(ctx, key,
+				"session_id", job.SessionId,
+				"file_name", job.FileName,
+				"source_name", job.SourceName,
+				"chunk", chunk.Index,
+				"page", chunk.Page,
+				"content", chunk.Content,
+				"vector", vectorBytes(vectors[i]),
+			)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return 0, err
+		}
+	}
+	return len(chunks), nil
+}
+
+// publishDocumentStatus sends the status of the file to the user's WebSocket connections
+func (dataBase *Database) publishDocumentStatus(ctx context.Context, userId string, sessionId string, info structures.DocumentInfo) {
+	data := structures.DocumentStatusResponse{
+		UserId:    userId,
+		SessionId: sessionId,
+		Documents: []structures.DocumentInfo{info},
+	}
+
+	response, err := data.Marshal()
+	if err != nil {
+		return
+	}
+	toSend := structures.ClientResponse{
+		MessageType: messages.MessageCodeDocumentStatus,
+		Data:        response,
+	}
+	response, err = toSend.Marshal()
+	if err != nil {
+		return
+	}
+	if err := dataBase.Cache.Publish(ctx, UserEventsChannel(userId), response).Err(); err != nil {
+		fmt.Println("Unable to publish document status: ", err)
+	}
+}
+
+// GetDocumentStatuses lists the ingestion status of the files of the user's session, oldest first
+func (dataBase *Database) GetDocumentStatuses(ctx context.Context, userId string, sessionId string) ([]structures.DocumentInfo, error) {
+	query := `
+	SELECT di.File_Name, di.Source_Name, di.Status, di.Chunks, di.Error, di.Updated_At
+	FROM Document_Ingestion di
+	JOIN Session_Details sd ON sd.Session_Id = di.Session_Id
+	WHERE sd.User_Id = $1 AND di.Session_Id = $2
+	ORDER BY di.Created_At, di.File_Name
+	`
+	statuses := []structures.DocumentInfo{}
+	if err := dataBase.Db.SelectContext(ctx, &statuses, query, userId, sessionId); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// RetrieveDocumentChunks returns the DOCUMENT_TOP_K chunks of the files which relate most to the message, numbered
+// from 1 in the order they're cited
+func (dataBase *Database) RetrieveDocumentChunks(ctx context.Context, sessionId string, fileNames []string, message string) ([]structures.DocumentSource, error) {
+	if len(fileNames) == 0 {
+		return nil, nil
+	}
+
+	vector, err := dataBase.AIService.ApiEmbedding(helper_functions.TruncateText(message, maxEmbeddingTextLength), EmbeddingProvider(), EmbeddingModel())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnableToEmbed, err)
+	}
+
+	escaped := make([]string, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		escaped = append(escaped, escapeTag(fileName))
+	}
+	filter := "@session_id:{" + escapeTag(sessionId) + "} @file_name:{" + strings.Join(escaped, " | ") + "}"
+	found, err := dataBase.searchVectors(ctx, documentIndex, filter, vector, documentSetting("DOCUMENT_TOP_K", defaultDocumentTopK),
+		"file_name", "source_name", "chunk", "page", "content")
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]structures.DocumentSource, 0, len(found))
+	for _, document := range found {
+		distance, _ := strconv.ParseFloat(document["distance"], 64)
+		chunk, _ := strconv.Atoi(document["chunk"])
+		page, _ := strconv.Atoi(document["page"])
+		sources = append(sources, structures.DocumentSource{
+			Number:     len(sources) + 1,
+			FileName:   document["file_name"],
+			SourceName: document["source_name"],
+			Page:       page,
+			Chunk:      chunk,
+			Content:    document["content"],
+			Score:      1 - distance,
+		})
+	}
+	return sources, nil
+}
+
+// DeleteDocument removes the chunks and the ingestion status of a file of the session
+func (dataBase *Database) DeleteDocument(ctx context.Context, sessionId string, fileName string) error {
+	if _, err := dataBase.Db.ExecContext(ctx, `DELETE FROM Document_Ingestion WHERE File_Name = $1`, fileName); err != nil {
+		return err
+	}
+	return dataBase.deleteKeys(ctx, documentKeyPrefix+sessionId+":"+fileName+":*")
+}
@@ -0,0 +1,369 @@
+package services
+
+import (
+	"ai-chat/database/structures"
+	"ai-chat/utils/helper_functions"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultEmbeddingProvider   = "openai"
+	defaultEmbeddingModel      = "text-embedding-ada-002"
+	defaultEmbeddingDimensions = 1536
+	defaultEmbeddingWorkers    = 2
+	defaultRecallMaxTopK       = 5
+
+	embeddingIndex     = "idx:embeddings"
+	embeddingKeyPrefix = "embedding:session:"
+	embeddingQueueKey  = "embedding:queue"
+	// exchanges are cut to this many characters before they're embedded and stored
+	maxEmbeddingTextLength = 2000
+	maxSemanticSearchLimit = 50
+	defaultSemanticLimit   = 10
+)
+
+// ErrUnableToEmbed is returned when the embedding provider couldn't embed the query
+var ErrUnableToEmbed = errors.New("unable to embed")
+
+// EmbeddingProvider is the LLM provider which embeds the exchanges and queries, EMBEDDING_PROVIDER overrides it.
+// It has to implement api_call.EmbeddingProvider, which the built in openai and ollama providers do.
+func EmbeddingProvider() string {
+	if provider := os.Getenv("EMBEDDING_PROVIDER"); provider != "" {
+		return provider
+	}
+	return defaultEmbeddingProvider
+}
+
+// EmbeddingModel is the model of the EmbeddingProvider, EMBEDDING_MODEL overrides it
+func EmbeddingModel() string {
+	if model := os.Getenv("EMBEDDING_MODEL"); model != "" {
+		return model
+	}
+	return defaultEmbeddingModel
+}
+
+// EmbeddingDimensions is the length of the vectors of the EmbeddingModel, EMBEDDING_DIMENSIONS overrides it
+func EmbeddingDimensions() int {
+	if dimensions, err := strconv.Atoi(os.Getenv("EMBEDDING_DIMENSIONS")); err == nil && dimensions > 0 {
+		return dimensions
+	}
+	return defaultEmbeddingDimensions
+}
+
+// RecallMaxTopK is the most exchanges a chat message may recall, RECALL_MAX_TOP_K overrides it
+func RecallMaxTopK() int {
+	if topK, err := strconv.Atoi(os.Getenv("RECALL_MAX_TOP_K")); err == nil && topK >= 0 {
+		return topK
+	}
+	return defaultRecallMaxTopK
+}
+
+// recallMinScore is the similarity below which exchanges aren't recalled, RECALL_MIN_SCORE sets it
+func recallMinScore() float64 {
+	score, err := strconv.ParseFloat(os.Getenv("RECALL_MIN_SCORE"), 64)
+	if err != nil {
+		return 0
+	}
+	return score
+}
+
+// EnsureEmbeddingIndex creates the Redis Stack vector index of the embedded exchanges unless it exists. The index
+// keeps the dimensions it was created with, it has to be dropped with FT.DROPINDEX when the embedding model changes.
+func (dataBase *Database) EnsureEmbeddingIndex(ctx context.Context) error {
+	return dataBase.createVectorIndex(ctx, embeddingIndex, embeddingKeyPrefix, "user_id", "session_id")
+}
+
+// createVectorIndex creates an index over the hashes under prefix unless it exists, with TAG fields and the vector
+// field holding EmbeddingDimensions FLOAT32 values
+func (dataBase *Database) createVectorIndex(ctx context.Context, index string, prefix string, tags ...string) error {
+	args := []interface{}{"FT.CREATE", index, "ON", "HASH", "PREFIX", 1, prefix, "SCHEMA"}
+	for _, tag := range tags {
+		args = append(args, tag, "TAG")
+	}
+	args = append(args, "vector", "VECTOR", "HNSW", 6, "TYPE", "FLOAT32", "DIM", EmbeddingDimensions(), "DISTANCE_METRIC", "COSINE")
+
+	err := dataBase.Cache.Do(ctx, args...).Err()
+	if err != nil && strings.Contains(err.Error(), "Index already exists") {
+		return nil
+	}
+	return err
+}
+
+// EnqueueEmbedding adds an exchange to the queue worked off by RunEmbeddingWorkers
+func (dataBase *Database) EnqueueEmbedding(ctx context.Context, job structures.EmbeddingJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return dataBase.Cache.RPush(ctx, embeddingQueueKey, data).Err()
+}
+
+// RunEmbeddingWorkers works off the embedding queue with EMBEDDING_WORKERS workers until the context is cancelled
+func RunEmbeddingWorkers(ctx context.Context, dataBase *Database) {
+	dataBase.runQueueWorkers(ctx, embeddingQueueKey, "EMBEDDING_WORKERS", defaultEmbeddingWorkers, func(ctx context.Context, data []byte) error {
+		var job structures.EmbeddingJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			return fmt.Errorf("failed to parse embedding job: %w", err)
+		}
+		if err := dataBase.EmbedExchange(ctx, job); err != nil {
+			return fmt.Errorf("failed to embed message %s: %w", job.MessageId, err)
+		}
+		return nil
+	})
+}
+
+// EmbedExchange embeds a message and its reply and stores them in the vector index
+func (dataBase *Database) EmbedExchange(ctx context.Context, job structures.EmbeddingJob) error {
+	content := formatExchange(job.Message, job.Reply)
+	vector, err := dataBase.AIService.ApiEmbedding(content, EmbeddingProvider(), EmbeddingModel())
+	if err != nil {
+		return err
+	}
+	if len(vector.Data) != EmbeddingDimensions() {
+		return fmt.Errorf("embedding has %d dimensions, the index expects %d", len(vector.Data), EmbeddingDimensions())
+	}
+
+	key := embeddingKeyPrefix + job.SessionId + ":" + job.MessageId
+	return dataBase.Cache.HSet(ctx, key,
+		"user_id", job.UserId,
+		"session_id", job.SessionId,
+		"message_id", job.MessageId,
+		"content", content,
+		"created_at", job.CreatedAt.Unix(),
+		"vector", vectorBytes(vector),
+	).Err()
+}
+
+// SemanticSearch returns at most limit exchanges of the user's sessions closest in meaning to the query, best
+// first. Exchanges of excludeSessionId and of sessions in the trash are left out.
+func (dataBase *Database) SemanticSearch(ctx context.Context, userId string, query string, excludeSessionId string, limit int) ([]structures.SemanticSearchHit, error) {
+	query = strings.TrimSpace(query)
+	if query == "" || len([]rune(query)) > maxSearchQueryLength {
+		return nil, ErrInvalidSearchQuery
+	}
+	if limit <= 0 {
+		limit = defaultSemanticLimit
+	}
+	return dataBase.nearestExchanges(ctx, userId, query, excludeSessionId, min(limit, maxSemanticSearchLimit))
+}
+
+// nearestExchanges embeds the text and returns the limit closest exchanges of the user's sessions in the index
+func (dataBase *Database) nearestExchanges(ctx context.Context, userId string, text string, excludeSessionId string, limit int) ([]structures.SemanticSearchHit, error) {
+	vector, err := dataBase.AIService.ApiEmbedding(text, EmbeddingProvider(), EmbeddingModel())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnableToEmbed, err)
+	}
+
+	filter := "@user_id:{" + escapeTag(userId) + "}"
+	if excludeSessionId != "" {
+		filter += " -@session_id:{" + escapeTag(excludeSessionId) + "}"
+	}
+	// twice as many neighbours are asked for as exchanges of trashed sessions are only filtered out afterwards
+	neighbours := limit * 2
+	documents, err := dataBase.searchVectors(ctx, embeddingIndex, filter, vector, neighbours, "session_id", "message_id", "content", "created_at")
+	if err != nil {
+		return nil, err
+	}
+	if len(documents) == 0 {
+		return []structures.SemanticSearchHit{}, nil
+	}
+
+	sessionIds := make([]string, 0, len(documents))
+	for _, document := range documents {
+		sessionIds = append(sessionIds, document["session_id"])
+	}
+	sessionNames, err := dataBase.activeSessionNames(ctx, userId, sessionIds)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := []structures.SemanticSearchHit{}
+	for _, document := range documents {
+		sessionName, ok := sessionNames[document["session_id"]]
+		if !ok {
+			continue
+		}
+		distance, _ := strconv.ParseFloat(document["distance"], 64)
+		createdAt, _ := strconv.ParseInt(document["created_at"], 10, 64)
+		hits = append(hits, structures.SemanticSearchHit{
+			SessionId:   document["session_id"],
+			SessionName: sessionName,
+			MessageId:   document["message_id"],
+			Content:     document["content"],
+			Score:       1 - distance,
+			CreatedAt:   time.Unix(createdAt, 0).UTC(),
+		})
+		if len(hits) == limit {
+			break
+		}
+	}
+	return hits, nil
+}
+
+// RecallExchanges returns the content of at most topK exchanges of the user's other sessions which relate to the
+// message, those scoring below RECALL_MIN_SCORE are left out
+func (dataBase *Database) RecallExchanges(ctx context.Context, userId string, sessionId string, message string, topK int) ([]string, error) {
+	topK = min(topK, RecallMaxTopK())
+	if topK <= 0 {
+		return nil, nil
+	}
+
+	hits, err := dataBase.nearestExchanges(ctx, userId, helper_functions.TruncateText(message, maxEmbeddingTextLength), sessionId, topK)
+	if err != nil {
+		return nil, err
+	}
+
+	minScore := recallMinScore()
+	var exchanges []string
+	for _, hit := range hits {
+		if hit.Score >= minScore {
+			exchanges = append(exchanges, hit.Content)
+		}
+	}
+	return exchanges, nil
+}
+
+// searchVectors returns the fields of the k documents of the index matching filter which are closest to the vector,
+// closest first, along with their cosine distance as distance
+func (dataBase *Database) searchVectors(ctx context.Context, index string, filter string, vector structures.Vector, k int, fields ...string) ([]map[string]string, error) {
+	args := []interface{}{"FT.SEARCH", index,
+		"(" + filter + ")=>[KNN $k @vector $vector AS distance]",
+		"PARAMS", 4, "k", k, "vector", vectorBytes(vector),
+		"SORTBY", "distance",
+		"RETURN", len(fields) + 1,
+	}
+	for _, field := range fields {
+		args = append(args, field)
+	}
+	args = append(args, "distance", "LIMIT", 0, k, "DIALECT", 2)
+
+	reply, err := dataBase.Cache.Do(ctx, args...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseSearchReply(reply)
+}
+
+// DeleteSessionEmbeddings removes the embedded exchanges and document chunks of the sessions from the vector indexes
+func (dataBase *Database) DeleteSessionEmbeddings(ctx context.Context, sessionIds []string) error {
+	for _, sessionId := range sessionIds {
+		for _, pattern := range []string{embeddingKeyPrefix + sessionId + ":*", documentKeyPrefix + sessionId + ":*"} {
+			if err := dataBase.deleteKeys(ctx, pattern); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteKeys deletes every key matching the pattern
+func (dataBase *Database) deleteKeys(ctx context.Context, pattern string) error {
+	iter := dataBase.Cache.Scan(ctx, 0, pattern, 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return dataBase.Cache.Del(ctx, keys...).Err()
+	}
+	return nil
+}
+
+// activeSessionNames returns the names of the sessions which belong to the user and aren't in the trash
+func (dataBase *Database) activeSessionNames(ctx context.Context, userId string, sessionIds []string) (map[string]string, error) {
+	query := `SELECT Session_Id, Session_Name FROM Session_Details WHERE User_Id = $1 AND Session_Id::TEXT = ANY($2) AND Deleted_At IS NULL`
+	rows, err := dataBase.Db.QueryContext(ctx, query, userId, pq.Array(sessionIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string]string, len(sessionIds))
+	for rows.Next() {
+		var sessionId, sessionName string
+		if err := rows.Scan(&sessionId, &sessionName); err != nil {
+			return nil, err
+		}
+		names[sessionId] = sessionName
+	}
+	return names, rows.Err()
+}
+
+// formatExchange writes a message and its reply the way they're embedded and recalled
+func formatExchange(message, reply string) string {
+	return helper_functions.TruncateText(fmt.Sprintf("User: %s\n\nAssistant: %s", message, reply), maxEmbeddingTextLength)
+}
+
+// vectorBytes encodes the vector as the little endian FLOAT32 blob the index expects
+func vectorBytes(vector structures.Vector) []byte {
+	data := make([]byte, 4*len(vector.Data))
+	for i, value := range vector.Data {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(value))
+	}
+	return data
+}
+
+// escapeTag escapes the punctuation of a value used in a TAG query, such as the dashes of a UUID
+func escapeTag(value string) string {
+	var escaped strings.Builder
+	for _, r := range value {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// parseSearchReply returns the fields of the documents of an FT.SEARCH reply, which is an array with RESP2 and
+// a map with RESP3
+func parseSearchReply(reply interface{}) ([]map[string]string, error) {
+	switch reply := reply.(type) {
+	case []interface{}:
+		// total, then the key and the field value pairs of every document
+		var documents []map[string]string
+		for i := 2; i < len(reply); i += 2 {
+			fields, ok := reply[i].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("unexpected search document %T", reply[i])
+			}
+			document := map[string]string{}
+			for j := 0; j+1 < len(fields); j += 2 {
+				document[fmt.Sprint(fields[j])] = fmt.Sprint(fields[j+1])
+			}
+			documents = append(documents, document)
+		}
+		return documents, nil
+	case map[interface{}]interface{}:
+		results, _ := reply["results"].([]interface{})
+		documents := make([]map[string]string, 0, len(results))
+		for _, result := range results {
+			entry, ok := result.(map[interface{}]interface{})
+			if !ok {
+				return nil, fmt.Errorf("unexpected search result %T", result)
+			}
+			attributes, _ := entry["extra_attributes"].(map[interface{}]interface{})
+			document := make(map[string]string, len(attributes))
+			for field, value := range attributes {
+				document[fmt.Sprint(field)] = fmt.Sprint(value)
+			}
+			documents = append(documents, document)
+		}
+		return documents, nil
+	default:
+		return nil, fmt.Errorf("unexpected search reply %T", reply)
+	}
+}
@@ -1,74 +1,137 @@
 package services
 
 import (
+	"ai-chat/database/storage"
+	"ai-chat/database/structures"
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/gofiber/fiber/v2"
-	"github.com/lib/pq"
+	"io"
 	"log"
+	"mime"
 	"mime/multipart"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func (dataBase *Database) SaveFile(conn *fiber.Ctx, sessionId string, fileName string, isNew string, file *multipart.FileHeader) error {
-	var query string
-	var err error
+const defaultFileURLExpiry = time.Hour
 
-	// Start a transaction
-	tx, err := dataBase.Db.BeginTxx(conn.Context(), nil) // Notice the use of BeginTxx for better context support
+// sessionFilesColumn selects the stored files of the session sd in upload order, as the File_Name array of the
+// session data
+const sessionFilesColumn = `ARRAY(SELECT fm.File_Name FROM File_Metadata fm WHERE fm.Session_Id = sd.Session_Id AND fm.Status = 'stored' ORDER BY fm.Position)`
+
+// fileMetadataColumns are the File_Metadata columns scanned into structures.FileMetadata
+const fileMetadataColumns = `File_Name, Session_Id, Original_Name, Mime_Type, Size, COALESCE(Uploaded_By::TEXT, '') AS Uploaded_By,
+	Content_Hash, Status, Created_At`
+
+// SaveFile stores a file uploaded by the user to the session under fileName along with its metadata. The row is
+// added as uploading before the file is written and marked stored with the SHA-256 of the content once it is, or
+// failed when it can't be written.
+func (dataBase *Database) SaveFile(ctx context.Context, userId string, sessionId string, fileName string, file *multipart.FileHeader) (structures.FileMetadata, error) {
+	var metadata structures.FileMetadata
+
+	reader, err := file.Open()
 	if err != nil {
-		return fmt.Errorf("failed to start file save transaction: %w", err)
+		return metadata, fmt.Errorf("failed to open uploaded file: %w", err)
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
+	defer reader.Close()
 
-	// save image to public dir
-	err = conn.SaveFile(file, fmt.Sprintf("./%s/%s", os.Getenv("PUBLIC_DIR"), fileName))
+	// the type is judged by the extension and the content, the one sent by the client isn't trusted
+	head := make([]byte, 512)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return metadata, fmt.Errorf("failed to read uploaded file: %w", err)
+	}
+	head = head[:n]
+	mimeType := fileMimeType(file.Filename, head)
+
+	query := `
+	INSERT INTO File_Metadata (File_Name, Session_Id, Original_Name, Mime_Type, Size, Uploaded_By, Status)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING ` + fileMetadataColumns
+	err = dataBase.Db.GetContext(ctx, &metadata, query, fileName, sessionId, filepath.Base(file.Filename), mimeType, file.Size,
+		userId, structures.FileStatusUploading)
 	if err != nil {
-		log.Println("file save error --> ", err)
-		return conn.JSON(fiber.Map{"status": 500, "message": "Server error", "data": nil})
+		return metadata, fmt.Errorf("failed to store file metadata: %w", err)
 	}
 
-	fmt.Println("Adding Files By: ", isNew)
-
-	// Prepare the SQL query using named parameters
-	if isNew == "NEW" {
-		query = `INSERT INTO File_Data (Session_Id, File_Name) VALUES (:session_id, :file_name)`
+	// the content is hashed while it is written
+	hash := sha256.New()
+	content := io.TeeReader(io.MultiReader(bytes.NewReader(head), reader), hash)
+	status, contentHash := structures.FileStatusStored, ""
+	putErr := dataBase.Files.Put(ctx, fileName, content, file.Size, mimeType)
+	if putErr != nil {
+		log.Println("file save error --> ", putErr)
+		status = structures.FileStatusFailed
 	} else {
-		query = `UPDATE File_Data SET File_Name = File_Name || :file_name WHERE Session_Id = :session_id`
+		contentHash = hex.EncodeToString(hash.Sum(nil))
 	}
 
-	params := map[string]interface{}{
-		"session_id": sessionId,
-		"file_name":  pq.Array([]string{fileName}),
+	query = `UPDATE File_Metadata SET Status = $2, Content_Hash = $3 WHERE File_Name = $1 RETURNING ` + fileMetadataColumns
+	if err := dataBase.Db.GetContext(ctx, &metadata, query, fileName, status, contentHash); err != nil {
+		return metadata, fmt.Errorf("failed to update file metadata: %w", err)
 	}
-
-	// Execute the query
-	result, err := tx.NamedExecContext(conn.Context(), query, params)
-	if err != nil {
-		return fmt.Errorf("failed to execute file save query: %w", err)
+	if putErr != nil {
+		return metadata, fmt.Errorf("failed to store file: %w", putErr)
 	}
+	return metadata, nil
+}
 
-	// Check how many rows were affected
-	affected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("in file save failed to get affected rows: %w", err)
-	}
-	if affected == 0 {
-		return errors.New("in file save no rows were affected, possible invalid user_id or session_id")
+// fileMimeType returns the type of a file named fileName starting with head, known extensions win over the content
+func fileMimeType(fileName string, head []byte) string {
+	if mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(fileName))); mimeType != "" {
+		return mimeType
 	}
+	return http.DetectContentType(head)
+}
 
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("in file save failed to commit transaction: %w", err)
+// FileURLExpiry is how long the URLs of uploaded files given to the clients stay valid, STORAGE_URL_EXPIRY_MINUTES
+// overrides the default of an hour. S3 compatible storage accepts at most seven days.
+func FileURLExpiry() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("STORAGE_URL_EXPIRY_MINUTES"))
+	if err != nil || minutes <= 0 {
+		return defaultFileURLExpiry
 	}
+	return time.Duration(minutes) * time.Minute
+}
 
-	return nil
+// GetFileMetadata returns the metadata of a stored file of one of the user's sessions, sql.ErrNoRows when there is
+// no such file
+func (dataBase *Database) GetFileMetadata(ctx context.Context, userId string, fileName string) (structures.FileMetadata, error) {
+	query := `
+	SELECT ` + fileMetadataColumns + `
+	FROM File_Metadata
+	WHERE File_Name = $2 AND Status = $3
+	AND Session_Id IN (SELECT Session_Id FROM Session_Details WHERE User_Id = $1)
+	`
+	var metadata structures.FileMetadata
+	err := dataBase.Db.GetContext(ctx, &metadata, query, userId, fileName, structures.FileStatusStored)
+	return metadata, err
+}
+
+// ListSessionFiles returns the metadata of the files uploaded to the user's session in upload order, failed
+// uploads included
+func (dataBase *Database) ListSessionFiles(ctx context.Context, userId string, sessionId string) ([]structures.FileMetadata, error) {
+	query := `
+	SELECT ` + fileMetadataColumns + `
+	FROM File_Metadata
+	WHERE Session_Id = $2
+	AND Session_Id IN (SELECT Session_Id FROM Session_Details WHERE User_Id = $1)
+	ORDER BY Position
+	`
+	files := []structures.FileMetadata{}
+	if err := dataBase.Db.SelectContext(ctx, &files, query, userId, sessionId); err != nil {
+		return nil, err
+	}
+	return files, nil
 }
 
 func (dataBase *Database) DeleteFile(conn context.Context, sessionId string, fileName string) error {
@@ -86,14 +149,7 @@ func (dataBase *Database) DeleteFile(conn context.Context, sessionId string, fil
 		}
 	}()
 
-	// save image to public dir
-	err = os.Remove(fmt.Sprintf("./%s/%s", os.Getenv("PUBLIC_DIR"), fileName))
-	if err != nil {
-		log.Println("delete file error --> ", err)
-		return fmt.Errorf("error while deleting file: %w", err)
-	}
-
-	query = `UPDATE File_Data SET File_Name = array_remove(File_Name, :file_name) WHERE Session_Id = :session_id AND :file_name = ANY(File_Name);`
+	query = `DELETE FROM File_Metadata WHERE File_Name = :file_name AND Session_Id = :session_id`
 
 	params := map[string]interface{}{
 		"session_id": sessionId,
@@ -120,6 +176,11 @@ func (dataBase *Database) DeleteFile(conn context.Context, sessionId string, fil
 		return fmt.Errorf("in file save failed to commit transaction: %w", err)
 	}
 
+	// the file is only removed once its row is gone, one which can't be removed is left behind in the storage
+	if err := dataBase.Files.Delete(conn, fileName); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Println("delete file error --> ", err)
+	}
+
 	return nil
 }
 
@@ -170,7 +231,8 @@ func (dataBase *Database) AddSession(ctx context.Context, userId string, session
 	return nil
 }
 
-func (dataBase *Database) AddChat(ctx context.Context, sessionId string, prompt string, chats string, chatSummary string) error {
+// AddChat stores the prompt and summary of a new session along with its first chats
+func (dataBase *Database) AddChat(ctx context.Context, sessionId string, prompt string, chats string, chatSummary string, activeLeafId string) error {
 	var query string
 	var rows sql.Result
 	var err error = nil
@@ -189,8 +251,8 @@ func (dataBase *Database) AddChat(ctx context.Context, sessionId string, prompt
 		}
 	}()
 
-	query = `INSERT INTO Chat_Details (Session_Id, Session_Prompt, Chats, Chats_Summary) VALUES ($1, $2, $3::JSONB, $4)`
-	rows, err = tx.ExecContext(ctx, query, sessionId, prompt, chats, chatSummary)
+	query = `INSERT INTO Chat_Details (Session_Id, Session_Prompt, Chats_Summary, Active_Leaf_Id) VALUES ($1, $2, $3, $4)`
+	rows, err = tx.ExecContext(ctx, query, sessionId, prompt, chatSummary, activeLeafId)
 
 	if err != nil {
 		fmt.Println(err)
@@ -206,6 +268,18 @@ func (dataBase *Database) AddChat(ctx context.Context, sessionId string, prompt
 		return errors.New("no rows were affected, check session ID")
 	}
 
+	lastChatId, err := addChatMessages(ctx, tx, sessionId, chats)
+	if err != nil {
+		return err
+	}
+
+	// entries written before chats could branch carry no active leaf
+	if activeLeafId == "" && lastChatId != "" {
+		if _, err = tx.ExecContext(ctx, `UPDATE Chat_Details SET Active_Leaf_Id = $2 WHERE Session_Id = $1`, sessionId, lastChatId); err != nil {
+			return fmt.Errorf("error executing query: %v", err)
+		}
+	}
+
 	// Commit the transaction
 	if err = tx.Commit(); err != nil {
 		return errors.New("unable to commit the transaction")
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"ai-chat/database/structures"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultHoldTTL = 15 * time.Minute
+
+// ErrBalanceNotCovered is returned by background jobs which were skipped because the balance of the user doesn't
+// cover their estimated cost
+var ErrBalanceNotCovered = errors.New("balance does not cover the estimated cost")
+
+// changeBalanceScript adds ARGV[1] to the cached balance in one step, so concurrent messages of a user can't
+// overwrite each other, and releases the hold ARGV[3] in KEYS[2] if one is given. Nothing is written when the
+// user isn't cached, the caller reads it through and retries; otherwise a bare hash holding only a balance
+// would be left behind.
+var changeBalanceScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return false
+end
+if ARGV[3] ~= '' then
+	redis.call('HDEL', KEYS[2], ARGV[3])
+end
+local balance = redis.call('HINCRBYFLOAT', KEYS[1], 'balance', ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl > 0 then
+	redis.call('EXPIRE', KEYS[1], ttl)
+end
+return balance
+`)
+
+// reserveBalanceScript places the hold ARGV[1] of ARGV[2] in KEYS[2] if the cached balance in KEYS[1] covers it
+// together with the holds already placed. Holds are stored as "amount:expires_at", ARGV[3] is the current unix
+// time and expired holds are dropped. Returns {placed, available balance before the hold}.
+var reserveBalanceScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return false
+end
+local now = tonumber(ARGV[3])
+local held = 0
+local holds = redis.call('HGETALL', KEYS[2])
+for i = 1, #holds, 2 do
+	local amount, expiresAt = string.match(holds[i + 1], '^([^:]+):(.+)$')
+	if tonumber(expiresAt) <= now then
+		redis.call('HDEL', KEYS[2], holds[i])
+	else
+		held = held + tonumber(amount)
+	end
+end
+local available = tonumber(redis.call('HGET', KEYS[1], 'balance') or '0') - held
+if available < tonumber(ARGV[2]) then
+	return {0, tostring(available)}
+end
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2] .. ':' .. ARGV[4])
+redis.call('EXPIRE', KEYS[2], ARGV[5])
+return {1, tostring(available)}
+`)
+
+// holdTTL is how long a hold is kept when it's neither settled nor released, read from BALANCE_HOLD_TTL_SECONDS.
+// It has to outlast the longest AI call.
+func holdTTL() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("BALANCE_HOLD_TTL_SECONDS"))
+	if err != nil || seconds <= 0 {
+		return defaultHoldTTL
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// ReserveBalance places a hold of amount on the cached balance of a user for a request which is about to be made.
+// ok is false when the balance left after the other holds doesn't cover it. The hold has to be settled with
+// SettleHold or released with ReleaseHold, otherwise it expires after BALANCE_HOLD_TTL_SECONDS.
+func (dataBase *Database) ReserveBalance(ctx context.Context, userId string, amount float64) (holdId string, ok bool, err error) {
+	holdId = uuid.New().String()
+
+	ok, err = dataBase.reserveCachedBalance(ctx, userId, holdId, amount)
+	if err == redis.Nil {
+		// not cached, read it through from the database
+		if err := dataBase.loadUserIntoCache(ctx, userId); err != nil {
+			return "", false, err
+		}
+		ok, err = dataBase.reserveCachedBalance(ctx, userId, holdId, amount)
+	}
+	if err != nil || !ok {
+		return "", false, err
+	}
+	return holdId, true, nil
+}
+
+// ReleaseHold drops a hold without charging anything, for requests which failed
+func (dataBase *Database) ReleaseHold(ctx context.Context, userId string, holdId string) error {
+	return dataBase.Cache.HDel(ctx, holdsKey(userId), holdId).Err()
+}
+
+// ChargeBalance applies the ledger entries of a user to the cached balance and returns the new balance.
+// Entries without an id get one, the same entries are later written to Credit_Ledger through the chat stream.
+func (dataBase *Database) ChargeBalance(ctx context.Context, userId string, entries []structures.LedgerEntry) (float64, error) {
+	return dataBase.SettleHold(ctx, userId, "", entries)
+}
+
+// SettleHold works like ChargeBalance and releases the hold in the same step, so the reserved amount is
+// replaced by the actual cost.
+func (dataBase *Database) SettleHold(ctx context.Context, userId string, holdId string, entries []structures.LedgerEntry) (float64, error) {
+	var amount float64
+	for i := range entries {
+		if entries[i].EntryId == "" {
+			entries[i].EntryId = uuid.New().String()
+		}
+		entries[i].UserId = userId
+		amount += entries[i].Amount
+	}
+
+	balance, err := dataBase.changeCachedBalance(ctx, userId, amount, holdId)
+	if err == redis.Nil {
+		// not cached, read it through from the database
+		if err := dataBase.loadUserIntoCache(ctx, userId); err != nil {
+			return 0, err
+		}
+		balance, err = dataBase.changeCachedBalance(ctx, userId, amount, holdId)
+	}
+	if err != nil {
+		return balance, err
+	}
+
+	// the entries are only in the cached balance until the stream consumer stores them
+	pending := make(map[string]interface{}, len(entries))
+	for _, entry := range entries {
+		pending[entry.EntryId] = entry.Amount
+	}
+	if len(pending) > 0 {
+		if err := dataBase.Cache.HSet(ctx, pendingLedgerKey(userId), pending).Err(); err != nil {
+			return balance, fmt.Errorf("failed to track pending ledger entries: %w", err)
+		}
+	}
+	return balance, nil
+}
+
+// ClearPendingLedger forgets the ledger entries the stream consumer stored in Credit_Ledger
+func (dataBase *Database) ClearPendingLedger(ctx context.Context, entries []structures.LedgerEntry) error {
+	pipe := dataBase.Cache.Pipeline()
+	for _, entry := range entries {
+		pipe.HDel(ctx, pendingLedgerKey(entry.UserId), entry.EntryId)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// pendingBalance returns the balance of the user in Postgres together with the ledger entries applied to the cached
+// balance which haven't reached Credit_Ledger yet. The balance and the stored entries are read in one statement, so
+// an entry stored meanwhile is counted exactly once. Stored entries are no longer tracked as pending.
+func (dataBase *Database) pendingBalance(ctx context.Context, userId string, balance float64) (float64, error) {
+	pending, err := dataBase.Cache.HGetAll(ctx, pendingLedgerKey(userId)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(pending) == 0 {
+		return balance, nil
+	}
+
+	entryIds := make([]string, 0, len(pending))
+	for entryId := range pending {
+		entryIds = append(entryIds, entryId)
+	}
+
+	query := `
+	SELECT COALESCE(Balance, 0), ARRAY(SELECT Entry_Id::TEXT FROM Credit_Ledger WHERE Entry_Id = ANY($2::UUID[]))
+	FROM User_Data WHERE User_Id = $1
+	`
+	var stored []string
+	if err := dataBase.Db.QueryRowContext(ctx, query, userId, pq.Array(entryIds)).Scan(&balance, pq.Array(&stored)); err != nil {
+		return 0, err
+	}
+
+	for _, entryId := range stored {
+		delete(pending, entryId)
+	}
+	for _, amount := range pending {
+		value, err := strconv.ParseFloat(amount, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid pending ledger amount %q: %w", amount, err)
+		}
+		balance += value
+	}
+
+	if len(stored) > 0 {
+		if err := dataBase.Cache.HDel(ctx, pendingLedgerKey(userId), stored...).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return balance, nil
+}
+
+// AddCredit records a credit, or a debit for a negative amount, in Credit_Ledger and applies it to the cached
+// balance if the user is cached. Users which aren't cached read the new balance through on their next request.
+func (dataBase *Database) AddCredit(ctx context.Context, userId string, amount float64, reason string) error {
+	entry := structures.LedgerEntry{
+		EntryId: uuid.New().String(),
+		UserId:  userId,
+		Amount:  amount,
+		Reason:  reason,
+	}
+	if err := dataBase.AddLedgerEntries(ctx, []structures.LedgerEntry{entry}); err != nil {
+		return err
+	}
+
+	_, err := dataBase.changeCachedBalance(ctx, userId, amount, "")
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
+
+// AddLedgerEntries inserts the entries into Credit_Ledger, the trg_apply_credit_ledger trigger updates
+// User_Data.Balance. Entries which were already inserted are skipped.
+func (dataBase *Database) AddLedgerEntries(ctx context.Context, entries []structures.LedgerEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	tx, err := dataBase.Db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO Credit_Ledger (Entry_Id, User_Id, Amount, Reason, Model_Id, Session_Id, Input_Tokens, Output_Tokens)
+	VALUES (:entry_id, :user_id, :amount, :reason, :model_id, :session_id, :input_tokens, :output_tokens)
+	ON CONFLICT (Entry_Id) DO NOTHING`
+	for _, entry := range entries {
+		if _, err := tx.NamedExecContext(ctx, query, entry); err != nil {
+			return fmt.Errorf("failed to insert ledger entry %s: %w", entry.EntryId, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (dataBase *Database) changeCachedBalance(ctx context.Context, userId string, amount float64, holdId string) (float64, error) {
+	userKey := fmt.Sprintf("user:%s", userId)
+	ttl := int(cacheTTL().Seconds())
+
+	balance, err := changeBalanceScript.Run(ctx, dataBase.Cache, []string{userKey, holdsKey(userId)}, amount, ttl, holdId).Text()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(balance, 64)
+}
+
+func (dataBase *Database) reserveCachedBalance(ctx context.Context, userId string, holdId string, amount float64) (bool, error) {
+	userKey := fmt.Sprintf("user:%s", userId)
+	now := time.Now()
+	expiresAt := now.Add(holdTTL())
+
+	result, err := reserveBalanceScript.Run(ctx, dataBase.Cache, []string{userKey, holdsKey(userId)},
+		holdId, amount, now.Unix(), expiresAt.Unix(), int(holdTTL().Seconds())).Slice()
+	if err != nil {
+		return false, err
+	}
+	if len(result) != 2 {
+		return false, fmt.Errorf("unexpected reserve result: %v", result)
+	}
+
+	placed, _ := result[0].(int64)
+	return placed == 1, nil
+}
+
+// holdsKey is the hash of the open holds of a user, kept apart from user:<id> so the cached user stays as loaded
+// pendingLedgerKey holds the amounts of the ledger entries applied to the cached balance which aren't stored in
+// Credit_Ledger yet, by entry id
+func pendingLedgerKey(userId string) string {
+	return fmt.Sprintf("user:%s:pending_ledger", userId)
+}
+
+func holdsKey(userId string) string {
+	return fmt.Sprintf("user:%s:holds", userId)
+}
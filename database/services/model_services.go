@@ -0,0 +1,63 @@
+package services
+
+import (
+	"ai-chat/utils/model_data"
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultModelRefresh = 5 * time.Minute
+
+// LoadModelRegistry reads Model_Details into the in memory model registry
+func LoadModelRegistry(db *sqlx.DB) error {
+	query := `SELECT Model_Id, Model_Name, context_length, Provider, Input_Price, Output_Price, Capabilities, Enabled, Rate_Limit_Per_Minute FROM Model_Details;`
+	rows, err := db.Query(query)
+	if err != nil {
+		return fmt.Errorf("error loading models: %w", err)
+	}
+	defer rows.Close()
+
+	var models []model_data.Model
+	for rows.Next() {
+		var model model_data.Model
+		if err := rows.Scan(&model.Id, &model.Name, &model.ContextLength, &model.Provider, &model.InputPrice,
+			&model.OutputPrice, pq.Array(&model.Capabilities), &model.Enabled, &model.RateLimitPerMinute); err != nil {
+			return fmt.Errorf("error parsing model: %w", err)
+		}
+		models = append(models, model)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error loading models: %w", err)
+	}
+
+	model_data.SetModels(models)
+	return nil
+}
+
+// RefreshModelRegistry reloads the registry every MODEL_REFRESH_MINUTES so models added or disabled
+// in Model_Details are picked up without a restart.
+func RefreshModelRegistry(ctx context.Context, db *sqlx.DB) {
+	interval := defaultModelRefresh
+	if minutes, err := strconv.Atoi(os.Getenv("MODEL_REFRESH_MINUTES")); err == nil && minutes > 0 {
+		interval = time.Duration(minutes) * time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := LoadModelRegistry(db); err != nil {
+				log.Println("Unable to refresh model registry:", err)
+			}
+		}
+	}
+}
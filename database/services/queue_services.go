@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// queuePoll is how long a worker waits for a job before checking whether it should stop
+const queuePoll = 5 * time.Second
+
+// runQueueWorkers works off the Redis list queueKey with as many workers as the workersEnv variable asks for,
+// defaultWorkers when it isn't set, until the context is cancelled. A job which fails is logged and dropped.
+func (dataBase *Database) runQueueWorkers(ctx context.Context, queueKey string, workersEnv string, defaultWorkers int, handle func(ctx context.Context, job []byte) error) {
+	workers, err := strconv.Atoi(os.Getenv(workersEnv))
+	if err != nil || workers <= 0 {
+		workers = defaultWorkers
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dataBase.runQueueWorker(ctx, queueKey, handle)
+		}()
+	}
+	wg.Wait()
+}
+
+func (dataBase *Database) runQueueWorker(ctx context.Context, queueKey string, handle func(ctx context.Context, job []byte) error) {
+	for {
+		result, err := dataBase.Cache.BLPop(ctx, queuePoll, queueKey).Result()
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			log.Println("Unable to read queue", queueKey, ":", err)
+			time.Sleep(queuePoll)
+			continue
+		}
+
+		if err := handle(ctx, []byte(result[1])); err != nil {
+			log.Println("Unable to process job of", queueKey, ":", err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"ai-chat/utils/model_data"
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Actions with their own rate limit
+const (
+	RateLimitActionChat   = "chat"
+	RateLimitActionUpload = "upload"
+)
+
+const defaultUserTier = "default"
+
+var defaultRateLimits = map[string]int{
+	RateLimitActionChat:   20,
+	RateLimitActionUpload: 5,
+}
+
+// RateLimit is a token bucket refilled with PerMinute tokens a minute and holding at most Burst
+type RateLimit struct {
+	PerMinute int
+	Burst     int
+}
+
+// takeTokensScript takes one token from every bucket in KEYS, or none if one of them is empty.
+// ARGV holds the tokens per second and burst of each bucket. Redis' own clock is used, so all app
+// instances refill the buckets at the same rate. Returns the milliseconds to wait, 0 if the tokens were taken.
+var takeTokensScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local tokens = {}
+local wait = 0
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[i * 2 - 1])
+	local burst = tonumber(ARGV[i * 2])
+	local bucket = redis.call('HMGET', key, 'tokens', 'updated_at')
+	local available = tonumber(bucket[1]) or burst
+	local updatedAt = tonumber(bucket[2]) or now
+	available = math.min(burst, available + (now - updatedAt) / 1000 * rate)
+	if available < 1 then
+		wait = math.max(wait, math.ceil((1 - available) / rate * 1000))
+	end
+	tokens[i] = available
+end
+if wait > 0 then
+	return wait
+end
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[i * 2 - 1])
+	local burst = tonumber(ARGV[i * 2])
+	redis.call('HSET', key, 'tokens', tokens[i] - 1, 'updated_at', now)
+	redis.call('PEXPIRE', key, math.ceil(burst / rate * 1000))
+end
+return 0
+`)
+
+// UserRateLimit returns the limit of an action for a tier. RATE_LIMIT_<TIER>_<ACTION>_PER_MINUTE and _BURST
+// override RATE_LIMIT_<ACTION>_PER_MINUTE and _BURST, a limit of 0 disables it.
+func UserRateLimit(action string, tier string) RateLimit {
+	limit := RateLimit{PerMinute: defaultRateLimits[action]}
+	prefixes := []string{"RATE_LIMIT_" + strings.ToUpper(action)}
+	if tier != "" && tier != defaultUserTier {
+		prefixes = append(prefixes, "RATE_LIMIT_"+strings.ToUpper(tier)+"_"+strings.ToUpper(action))
+	}
+
+	for _, prefix := range prefixes {
+		if perMinute, err := strconv.Atoi(os.Getenv(prefix + "_PER_MINUTE")); err == nil && perMinute >= 0 {
+			limit.PerMinute = perMinute
+			limit.Burst = 0
+		}
+		if burst, err := strconv.Atoi(os.Getenv(prefix + "_BURST")); err == nil && burst > 0 {
+			limit.Burst = burst
+		}
+	}
+
+	if limit.Burst == 0 {
+		limit.Burst = limit.PerMinute
+	}
+	return limit
+}
+
+// CheckRateLimit takes a token from the bucket of the user for the action and, if a model is given, from the
+// bucket of the user for the model. A zero duration means the request may go ahead, otherwise it's how long
+// the user has to wait.
+func (dataBase *Database) CheckRateLimit(ctx context.Context, userId string, action string, modelName string) (time.Duration, error) {
+	tier, err := dataBase.GetUserTier(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	var args []interface{}
+	add := func(key string, limit RateLimit) {
+		if limit.PerMinute <= 0 {
+			return
+		}
+		keys = append(keys, key)
+		args = append(args, float64(limit.PerMinute)/60, limit.Burst)
+	}
+
+	add(fmt.Sprintf("ratelimit:%s:user:%s", action, userId), UserRateLimit(action, tier))
+	if model, ok := model_data.GetModel(modelName); ok {
+		add(fmt.Sprintf("ratelimit:model:%d:user:%s", model.Id, userId), RateLimit{PerMinute: model.RateLimitPerMinute, Burst: model.RateLimitPerMinute})
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	wait, err := takeTokensScript.Run(ctx, dataBase.Cache, keys, args...).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// GetUserTier returns the rate limit tier of a user
+func (dataBase *Database) GetUserTier(ctx context.Context, userId string) (string, error) {
+	userKey := fmt.Sprintf("user:%s", userId)
+
+	exists, err := dataBase.Cache.Exists(ctx, userKey).Result()
+	if err != nil {
+		return "", err
+	}
+	if exists == 0 {
+		// not cached, read it through from the database
+		if err := dataBase.loadUserIntoCache(ctx, userId); err != nil {
+			return "", err
+		}
+	}
+
+	tier, err := dataBase.Cache.HGet(ctx, userKey, "tier").Result()
+	if err == redis.Nil || tier == "" {
+		return defaultUserTier, nil
+	}
+	return tier, err
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"ai-chat/database/structures"
+	"ai-chat/utils/helper_functions"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+)
+
+// GetUserRecord loads the user row as stored in Postgres, nil is returned if the user doesn't exist.
+func (dataBase *Database) GetUserRecord(ctx context.Context, userId string) (*structures.UserRecord, error) {
+	var userName sql.NullString
+	var models []uint8
+	var balance sql.NullFloat64
+	var tier string
+
+	query := `SELECT UserName, Models, Balance, Tier FROM User_Data WHERE User_Id = $1`
+	err := dataBase.Db.QueryRowContext(ctx, query, userId).Scan(&userName, &models, &balance, &tier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &structures.UserRecord{
+		UserId:   userId,
+		UserName: userName.String,
+		Models:   string(models),
+		Balance:  balance.Float64,
+		Tier:     tier,
+	}, nil
+}
+
+// GetSessionRecord loads the session with its chats and files as stored in Postgres, nil is returned if the session doesn't exist.
+// The chats are all chats of every branch, sessions in the trash are returned with their DeletedAt set.
+func (dataBase *Database) GetSessionRecord(ctx context.Context, sessionId string) (*structures.SessionRecord, error) {
+	var userId, sessionName string
+	var modelId int
+	var sessionPrompt, chatsSummary, activeLeafId, summaryLeafId sql.NullString
+	var fileName []string
+	var lastMessageAt, deletedAt sql.NullTime
+	var pinned, archived bool
+	var tags []string
+
+	query := `
+	SELECT sd.User_Id, sd.Session_Name, sd.Model_Id, sd.Last_Message_At, sd.Pinned, sd.Archived, sd.Tags, sd.Deleted_At, cd.Session_Prompt, cd.Chats_Summary, cd.Active_Leaf_Id, cd.Summary_Leaf_Id, ` + sessionFilesColumn + `
+	FROM Session_Details sd
+	LEFT JOIN Chat_Details cd ON sd.Session_Id = cd.Session_Id
+	WHERE sd.Session_Id = $1
+	`
+	err := dataBase.Db.QueryRowContext(ctx, query, sessionId).Scan(&userId, &sessionName, &modelId, &lastMessageAt, &pinned, &archived, pq.Array(&tags), &deletedAt, &sessionPrompt, &chatsSummary, &activeLeafId, &summaryLeafId, pq.Array(&fileName))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chatsList, err := dataBase.GetSessionChats(ctx, sessionId)
+	if err != nil {
+		return nil, fmt.Errorf("error loading chats: %w", err)
+	}
+
+	session := &structures.SessionRecord{
+		UserId: userId,
+		SessionData: structures.SessionData{
+			SessionId:     sessionId,
+			SessionName:   sessionName,
+			ModelId:       modelId,
+			Prompt:        sessionPrompt.String,
+			ChatSummary:   chatsSummary.String,
+			FileName:      fileName,
+			Chats:         chatsList,
+			ActiveLeafId:  activeLeafId.String,
+			SummaryLeafId: summaryLeafId.String,
+			LastMessageAt: lastMessageAt.Time,
+			Pinned:        pinned,
+			Archived:      archived,
+			Tags:          tags,
+		},
+	}
+	if deletedAt.Valid {
+		session.DeletedAt = &deletedAt.Time
+	}
+	return session, nil
+}
+
+// CacheUserRecord overwrites the cached user hash with the values from Postgres
+func (dataBase *Database) CacheUserRecord(ctx context.Context, user structures.UserRecord) error {
+	userKey := fmt.Sprintf("user:%s", user.UserId)
+	err := dataBase.Cache.HSet(ctx, userKey, map[string]interface{}{
+		"username": user.UserName,
+		"models":   user.Models,
+		"balance":  user.Balance,
+		"tier":     user.Tier,
+	}).Err()
+	if err != nil {
+		return err
+	}
+	return dataBase.touchCache(ctx, userKey)
+}
+
+// CacheSessionRecord overwrites the cached session hash with the values from Postgres, keeping the active branch
+// up to MAX_CHAT_HISTORY_CONTEXT chats
+func (dataBase *Database) CacheSessionRecord(session structures.SessionRecord) error {
+	sessionData := session.SessionData
+	sessionData.Chats = helper_functions.BranchPath(sessionData.Chats, sessionData.ActiveLeafId)
+	if len(sessionData.Chats) > 0 {
+		sessionData.ActiveLeafId = sessionData.Chats[len(sessionData.Chats)-1].Id
+	}
+	sessionData.Chats = helper_functions.LatestChats(sessionData.Chats)
+	return dataBase.SetSessionValues(session.UserId, sessionData)
+}
+
+// UpdateSessionRecord overwrites the session row, prompt, summary and file list in Postgres with the cached values.
+// Chats are left alone as the cache only holds the latest ones.
+func (dataBase *Database) UpdateSessionRecord(ctx context.Context, sessionData structures.SessionData) error {
+	tx, err := dataBase.Db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	tags := sessionData.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE Session_Details SET Session_Name = $2, Model_Id = $3, Pinned = $4, Archived = $5, Tags = $6 WHERE Session_Id = $1`,
+		sessionData.SessionId, sessionData.SessionName, sessionData.ModelId, sessionData.Pinned, sessionData.Archived, pq.Array(tags)); err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE Chat_Details SET Session_Prompt = $2, Chats_Summary = $3, Active_Leaf_Id = $4, Summary_Leaf_Id = $5 WHERE Session_Id = $1`,
+		sessionData.SessionId, sessionData.Prompt, sessionData.ChatSummary, sessionData.ActiveLeafId, sessionData.SummaryLeafId); err != nil {
+		return fmt.Errorf("failed to update chat details: %w", err)
+	}
+
+	fileName := sessionData.FileName
+	if fileName == nil {
+		fileName = []string{}
+	}
+
+	// stored files missing from the cache are dropped, files only the cache knows get metadata with their name alone
+	if _, err = tx.ExecContext(ctx, `DELETE FROM File_Metadata WHERE Session_Id = $1 AND Status = $3 AND NOT (File_Name = ANY($2))`,
+		sessionData.SessionId, pq.Array(fileName), structures.FileStatusStored); err != nil {
+		return fmt.Errorf("failed to delete file metadata: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, `
+	INSERT INTO File_Metadata (File_Name, Session_Id, Original_Name, Uploaded_By)
+	SELECT f.File_Name, sd.Session_Id, f.File_Name, sd.User_Id
+	FROM Session_Details sd
+	CROSS JOIN LATERAL unnest($2::TEXT[]) WITH ORDINALITY AS f(File_Name, Position)
+	WHERE sd.Session_Id = $1
+	ORDER BY f.Position
+	ON CONFLICT (File_Name) DO NOTHING`,
+		sessionData.SessionId, pq.Array(fileName)); err != nil {
+		return fmt.Errorf("failed to insert file metadata: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
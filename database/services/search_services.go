@@ -0,0 +1,144 @@
+package services
+
+import (
+	"ai-chat/database/structures"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidSearchQuery is returned for an empty or too long query
+var ErrInvalidSearchQuery = errors.New("invalid search query")
+
+const (
+	maxSearchQueryLength = 256
+	// ts_headline wraps the matched words in these control characters, they are replaced with <mark></mark> once
+	// the snippet is escaped so the text of the messages can't inject markup
+	searchHighlightStart = "\x02"
+	searchHighlightStop  = "\x03"
+)
+
+var searchHeadlineOptions = "StartSel=" + searchHighlightStart + ", StopSel=" + searchHighlightStop +
+	", MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=\" … \""
+
+// searchCursor points behind the last hit of a page, hits are ranked so pages are numbered by their offset
+type searchCursor struct {
+	Query  string `json:"q"`
+	Offset int    `json:"o"`
+}
+
+// SearchPageSize is the number of hits returned per page when the client doesn't ask for a number,
+// SEARCH_PAGE_SIZE overrides it. Pages never hold more than maxPageSize hits.
+func SearchPageSize(limit int) int {
+	return pageSize(limit, "SEARCH_PAGE_SIZE")
+}
+
+// SearchChats searches the messages and session names of the user's sessions, the trash left out, and returns
+// a page of the hits ranked best first. Messages are searchable once the stream consumer persisted them.
+func (dataBase *Database) SearchChats(userId string, request structures.SearchRequest) (structures.SearchResponse, error) {
+	searchQuery := strings.TrimSpace(request.Query)
+	if searchQuery == "" || utf8.RuneCountInString(searchQuery) > maxSearchQueryLength {
+		return structures.SearchResponse{}, ErrInvalidSearchQuery
+	}
+	limit := SearchPageSize(request.Limit)
+
+	offset := 0
+	if request.Cursor != "" {
+		cursor, err := decodeSearchCursor(request.Cursor)
+		if err != nil || cursor.Query != searchQuery || cursor.Offset < 0 {
+			return structures.SearchResponse{}, ErrInvalidCursor
+		}
+		offset = cursor.Offset
+	}
+
+	// the to_tsvector expressions match the indexes of the 011_search migration, snippets and positions are
+	// only computed for the hits of the page
+	query := `
+	WITH search AS (SELECT websearch_to_tsquery('english', $2) AS q),
+	hits AS (
+		SELECT cm.Session_Id, cm.Message_Id::TEXT AS Message_Id, cm.Position, cm.Role, cm.Content AS Document, cm.Created_At,
+			ts_rank(to_tsvector('english', cm.Content), search.q) AS Rank
+		FROM Chat_Messages cm
+		JOIN Session_Details sd ON sd.Session_Id = cm.Session_Id
+		CROSS JOIN search
+		WHERE sd.User_Id = $1 AND sd.Deleted_At IS NULL AND to_tsvector('english', cm.Content) @@ search.q
+		UNION ALL
+		SELECT sd.Session_Id, '', 0, '', sd.Session_Name, sd.Created_At,
+			ts_rank(to_tsvector('english', sd.Session_Name), search.q)
+		FROM Session_Details sd
+		CROSS JOIN search
+		WHERE sd.User_Id = $1 AND sd.Deleted_At IS NULL AND to_tsvector('english', sd.Session_Name) @@ search.q
+		ORDER BY Rank DESC, Created_At DESC, Session_Id, Message_Id
+		LIMIT $3 OFFSET $4
+	)
+	SELECT hits.Session_Id, sd.Session_Name, hits.Message_Id, hits.Role, hits.Rank, hits.Created_At,
+		CASE WHEN hits.Position = 0 THEN 0 ELSE (
+			SELECT COUNT(*) FROM Chat_Messages p WHERE p.Session_Id = hits.Session_Id AND p.Position <= hits.Position
+		) END,
+		ts_headline('english', hits.Document, search.q, $5)
+	FROM hits
+	JOIN Session_Details sd ON sd.Session_Id = hits.Session_Id
+	CROSS JOIN search
+	ORDER BY hits.Rank DESC, hits.Created_At DESC, hits.Session_Id, hits.Message_Id
+	`
+	rows, err := dataBase.Db.QueryContext(context.Background(), query, userId, searchQuery, limit+1, offset, searchHeadlineOptions)
+	if err != nil {
+		return structures.SearchResponse{}, err
+	}
+	defer rows.Close()
+
+	hits := []structures.SearchHit{}
+	hasMore := false
+	for rows.Next() {
+		if len(hits) == limit {
+			hasMore = true
+			break
+		}
+
+		var hit structures.SearchHit
+		if err := rows.Scan(&hit.SessionId, &hit.SessionName, &hit.MessageId, &hit.Role, &hit.Rank, &hit.CreatedAt,
+			&hit.Position, &hit.Snippet); err != nil {
+			return structures.SearchResponse{}, err
+		}
+		hit.Snippet = highlightSnippet(hit.Snippet)
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return structures.SearchResponse{}, err
+	}
+
+	response := structures.SearchResponse{
+		UserId: userId,
+		Query:  searchQuery,
+		Hits:   hits,
+	}
+	if hasMore {
+		response.NextCursor = encodeSearchCursor(searchCursor{Query: searchQuery, Offset: offset + limit})
+	}
+	return response, nil
+}
+
+// highlightSnippet escapes a ts_headline snippet and turns its highlight markers into <mark></mark>
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	return strings.NewReplacer(searchHighlightStart, "<mark>", searchHighlightStop, "</mark>").Replace(snippet)
+}
+
+func encodeSearchCursor(cursor searchCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSearchCursor(value string) (searchCursor, error) {
+	var cursor searchCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
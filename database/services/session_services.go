@@ -0,0 +1,274 @@
+package services
+
+import (
+	"ai-chat/database/structures"
+	"ai-chat/utils/model_data"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrInvalidCursor is returned for a cursor which wasn't handed out for the requested order
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrUnknownSessionOrder is returned for an order which isn't one of the SessionOrder constants
+	ErrUnknownSessionOrder = errors.New("unknown session order")
+)
+
+// sessionOrder is how sessions are sorted, ties are broken by the session id in the same direction
+type sessionOrder struct {
+	key        string
+	descending bool
+}
+
+var sessionOrders = map[string]sessionOrder{
+	structures.SessionOrderActivity: {key: "COALESCE(sd.Last_Message_At, sd.Created_At)", descending: true},
+	structures.SessionOrderCreated:  {key: "sd.Created_At", descending: true},
+	structures.SessionOrderName:     {key: "sd.Session_Name"},
+}
+
+// sessionCursor points behind the last session of a page
+type sessionCursor struct {
+	OrderBy   string `json:"o"`
+	Key       string `json:"k"`
+	SessionId string `json:"s"`
+}
+
+// SessionPageSize is the number of sessions listed per page when the client doesn't ask for a number,
+// SESSION_PAGE_SIZE overrides it. Pages never hold more than maxPageSize sessions.
+func SessionPageSize(limit int) int {
+	return pageSize(limit, "SESSION_PAGE_SIZE")
+}
+
+// GetSessionsByUserId lists a page of the user's sessions
+func (dataBase *Database) GetSessionsByUserId(userId string, options structures.SessionListOptions) (structures.UserSessionResponse, error) {
+	if options.OrderBy == "" {
+		options.OrderBy = structures.SessionOrderActivity
+	}
+	order, ok := sessionOrders[options.OrderBy]
+	if !ok {
+		return structures.UserSessionResponse{}, ErrUnknownSessionOrder
+	}
+	limit := SessionPageSize(options.Limit)
+
+	comparison, direction := ">", "ASC"
+	if order.descending {
+		comparison, direction = "<", "DESC"
+	}
+	// the key is compared as text for names and cast back for times, which keeps the precision of Postgres
+	keyType := "TIMESTAMP"
+	if options.OrderBy == structures.SessionOrderName {
+		keyType = "TEXT"
+	}
+
+	conditions := []string{"sd.User_Id = $1", "sd.Deleted_At IS NULL"}
+	if options.Trashed {
+		conditions[1] = "sd.Deleted_At IS NOT NULL"
+	}
+	args := []interface{}{userId}
+	if options.ModelId != 0 {
+		args = append(args, options.ModelId)
+		conditions = append(conditions, fmt.Sprintf("sd.Model_Id = $%d", len(args)))
+	}
+	if options.Pinned != nil {
+		args = append(args, *options.Pinned)
+		conditions = append(conditions, fmt.Sprintf("sd.Pinned = $%d", len(args)))
+	}
+	if options.Archived != nil {
+		args = append(args, *options.Archived)
+		conditions = append(conditions, fmt.Sprintf("sd.Archived = $%d", len(args)))
+	}
+	if options.Tag != "" {
+		args = append(args, pq.Array([]string{options.Tag}))
+		conditions = append(conditions, fmt.Sprintf("sd.Tags @> $%d", len(args)))
+	}
+	if options.Cursor != "" {
+		cursor, err := decodeSessionCursor(options.Cursor)
+		if err != nil || cursor.OrderBy != options.OrderBy {
+			return structures.UserSessionResponse{}, ErrInvalidCursor
+		}
+		args = append(args, cursor.Key, cursor.SessionId)
+		conditions = append(conditions, fmt.Sprintf("(%s, sd.Session_Id) %s ($%d::%s, $%d::UUID)", order.key, comparison, len(args)-1, keyType, len(args)))
+	}
+	args = append(args, limit+1)
+
+	query := fmt.Sprintf(`
+	SELECT sd.Session_Id, sd.Session_Name, sd.Model_Id, sd.Created_At, sd.Updated_At, sd.Last_Message_At, sd.Message_Count, sd.Total_Cost,
+		sd.Pinned, sd.Archived, sd.Tags, sd.Deleted_At, %s::TEXT
+	FROM Session_Details sd
+	WHERE %s
+	ORDER BY %s %s, sd.Session_Id %s
+	LIMIT $%d
+	`, order.key, strings.Join(conditions, " AND "), order.key, direction, direction, len(args))
+
+	rows, err := dataBase.Db.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return structures.UserSessionResponse{}, err
+	}
+	defer rows.Close()
+
+	sessionInfo := []structures.SessionInfo{}
+	var lastKey string
+	hasMore := false
+	for rows.Next() {
+		if len(sessionInfo) == limit {
+			hasMore = true
+			break
+		}
+
+		var session structures.SessionInfo
+		var modelId int
+		var lastMessageAt, deletedAt sql.NullTime
+		if err := rows.Scan(&session.SessionId, &session.SessionName, &modelId, &session.CreatedAt, &session.UpdatedAt,
+			&lastMessageAt, &session.MessageCount, &session.TotalCost, &session.Pinned, &session.Archived, pq.Array(&session.Tags), &deletedAt, &lastKey); err != nil {
+			return structures.UserSessionResponse{}, err
+		}
+		if lastMessageAt.Valid {
+			session.LastMessageAt = &lastMessageAt.Time
+		}
+		if deletedAt.Valid {
+			purgeAt := deletedAt.Time.Add(TrashRetention())
+			session.DeletedAt = &deletedAt.Time
+			session.PurgeAt = &purgeAt
+		}
+		session.ModelName = model_data.ModelName(modelId)
+		sessionInfo = append(sessionInfo, session)
+	}
+	if err := rows.Err(); err != nil {
+		return structures.UserSessionResponse{}, err
+	}
+
+	response := structures.UserSessionResponse{
+		UserId:  userId,
+		Session: sessionInfo,
+	}
+	if hasMore {
+		response.NextCursor = encodeSessionCursor(sessionCursor{
+			OrderBy:   options.OrderBy,
+			Key:       lastKey,
+			SessionId: sessionInfo[len(sessionInfo)-1].SessionId,
+		})
+	}
+	return response, nil
+}
+
+func encodeSessionCursor(cursor sessionCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSessionCursor(value string) (sessionCursor, error) {
+	var cursor sessionCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
+
+const (
+	maxSessionNameLength = 255
+	maxSessionTags       = 20
+	maxTagLength         = 50
+)
+
+// ErrInvalidSessionDetails is returned for an empty name or too many or too long tags
+var ErrInvalidSessionDetails = errors.New("invalid session details")
+
+// NormalizeSessionDetails trims the name and tags, drops empty and repeated tags and checks the limits
+func NormalizeSessionDetails(details structures.SessionDetails) (structures.SessionDetails, error) {
+	details.SessionName = strings.TrimSpace(details.SessionName)
+	if details.SessionName == "" || utf8.RuneCountInString(details.SessionName) > maxSessionNameLength {
+		return details, ErrInvalidSessionDetails
+	}
+
+	tags := make([]string, 0, len(details.Tags))
+	for _, tag := range details.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || slices.Contains(tags, tag) {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return details, ErrInvalidSessionDetails
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxSessionTags {
+		return details, ErrInvalidSessionDetails
+	}
+	details.Tags = tags
+	return details, nil
+}
+
+// UpdateSessionDetails changes the settings of the user's session with update. The cache is changed right away
+// and the settings are published to the stream together with the ledger entries of generating them, to be
+// persisted after the chats written before.
+func (dataBase *Database) UpdateSessionDetails(userId string, sessionId string, update func(*structures.SessionDetails), ledger []structures.LedgerEntry) (structures.SessionDetails, error) {
+	sessionData, err := dataBase.GetUserSessionData(userId, sessionId)
+	if err != nil {
+		return structures.SessionDetails{}, err
+	}
+
+	details := sessionData.Details()
+	update(&details)
+	if details, err = NormalizeSessionDetails(details); err != nil {
+		return structures.SessionDetails{}, err
+	}
+
+	if err := dataBase.SetSessionDetails(userId, sessionId, details); err != nil {
+		return structures.SessionDetails{}, err
+	}
+	if err := dataBase.Stream.AddSessionDetailsToStream(context.Background(), userId, sessionId, details, ledger); err != nil {
+		return structures.SessionDetails{}, err
+	}
+	return details, nil
+}
+
+// SetSessionDetails writes only the settings of a cached session, leaving its chats alone. A session which is no
+// longer cached is skipped, it reads the stored settings on its next use.
+func (dataBase *Database) SetSessionDetails(userId string, sessionId string, details structures.SessionDetails) error {
+	tagsJSON, err := json.Marshal(details.Tags)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("user:%s:session:%s", userId, sessionId)
+	return dataBase.setCachedFields(context.Background(), key, map[string]interface{}{
+		"session_name": details.SessionName,
+		"pinned":       details.Pinned,
+		"archived":     details.Archived,
+		"tags":         tagsJSON,
+	})
+}
+
+// StoreSessionDetails persists the settings of a session. Updates of sessions which aren't stored yet fail,
+// so the stream entry is retried once the session is.
+func (dataBase *Database) StoreSessionDetails(ctx context.Context, sessionId string, details structures.SessionDetails) error {
+	tags := details.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	query := `UPDATE Session_Details SET Session_Name = $2, Pinned = $3, Archived = $4, Tags = $5, Updated_At = CURRENT_TIMESTAMP WHERE Session_Id = $1`
+	result, err := dataBase.Db.ExecContext(ctx, query, sessionId, details.SessionName, details.Pinned, details.Archived, pq.Array(tags))
+	if err != nil {
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return errors.New("no rows were affected, possible invalid session_id")
+	}
+	return nil
+}
@@ -2,38 +2,85 @@ package services
 
 import (
 	"ai-chat/database/structures"
+	"ai-chat/utils/helper_functions"
 	"ai-chat/utils/model_data"
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// LoadAllModels seeds Model_Details with the default models on the first run, afterwards the table
+// is the source of truth and models are managed there.
 func LoadAllModels(db *sqlx.DB) error {
-	// Load all the models details
-	insertQuery := "INSERT INTO Model_Details (Model_Id, Model_Name, context_length) VALUES (:id, :name, :len);"
-	for id, name := range model_data.GetModelNumberMapping() {
-		contextLength := model_data.ModelContextLength(id)
-		_, err := db.NamedExec(insertQuery, map[string]interface{}{"id": id, "name": name, "len": contextLength})
+	var count int
+	if err := db.Get(&count, "SELECT COUNT(*) FROM Model_Details;"); err != nil {
+		return fmt.Errorf("error counting models: %v", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	insertQuery := `INSERT INTO Model_Details (Model_Id, Model_Name, context_length, Provider, Input_Price, Output_Price, Capabilities, Enabled)
+	VALUES (:id, :name, :len, :provider, :input_price, :output_price, :capabilities, :enabled);`
+	for _, model := range model_data.DefaultModels {
+		_, err := db.NamedExec(insertQuery, map[string]interface{}{
+			"id":           model.Id,
+			"name":         model.Name,
+			"len":          model.ContextLength,
+			"provider":     model.Provider,
+			"input_price":  model.InputPrice,
+			"output_price": model.OutputPrice,
+			"capabilities": pq.Array(model.Capabilities),
+			"enabled":      model.Enabled,
+		})
 		if err != nil {
 			if strings.Contains(err.Error(), "duplicate") {
 				continue
 			}
-			return fmt.Errorf("error inserting model with id %d: %v", id, err)
+			return fmt.Errorf("error inserting model with id %d: %v", model.Id, err)
 		}
 	}
 
 	return nil
 }
 
-func LoadAllUsers(db *Database) error {
-	query := `SELECT User_Id, UserName, Models, Balance FROM User_Data;`
-	rows, err := db.Db.Query(query)
+// WarmUpCache loads the sessions created in the last CACHE_WARMUP_DAYS days, at most CACHE_WARMUP_LIMIT of them,
+// together with their users into the cache. Everything else is read through on first use, so startup doesn't
+// depend on the amount of stored data.
+func WarmUpCache(db *Database) error {
+	days, err := strconv.Atoi(os.Getenv("CACHE_WARMUP_DAYS"))
+	if err != nil || days <= 0 {
+		return nil
+	}
+
+	limit, err := strconv.Atoi(os.Getenv("CACHE_WARMUP_LIMIT"))
+	if err != nil || limit <= 0 {
+		limit = 1000
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	if err := LoadActiveUsers(db, since, limit); err != nil {
+		return err
+	}
+	return PopulateRedisCache(db, since, limit)
+}
+
+// LoadActiveUsers caches the users owning the latest sessions created after since
+func LoadActiveUsers(db *Database, since time.Time, limit int) error {
+	query := `
+	SELECT ud.User_Id, ud.UserName, ud.Models, ud.Balance, ud.Tier FROM User_Data ud
+	WHERE ud.User_Id IN (
+		SELECT User_Id FROM (
+			SELECT User_Id FROM Session_Details WHERE Created_At >= $1 AND Deleted_At IS NULL ORDER BY Created_At DESC LIMIT $2
+		) recent
+	);`
+	rows, err := db.Db.Query(query, since, limit)
 	if err != nil {
 		return err
 	}
@@ -43,7 +90,8 @@ func LoadAllUsers(db *Database) error {
 		var userIDTemp, userNameTemp sql.NullString
 		var models []uint8
 		var balance float64
-		if err := rows.Scan(&userIDTemp, &userNameTemp, &models, &balance); err != nil {
+		var tier string
+		if err := rows.Scan(&userIDTemp, &userNameTemp, &models, &balance, &tier); err != nil {
 			return err
 		}
 
@@ -55,14 +103,13 @@ func LoadAllUsers(db *Database) error {
 		userID = userIDTemp.String
 		userName = userNameTemp.String
 
-		// Redis key for storing user data
-		userKey := fmt.Sprintf("user:%s", userID)
-
-		_, err = db.Cache.HSet(context.Background(), userKey, map[string]interface{}{
-			"username": userName,
-			"models":   models,
-			"balance":  balance,
-		}).Result()
+		err = db.CacheUserRecord(context.Background(), structures.UserRecord{
+			UserId:   userID,
+			UserName: userName,
+			Models:   string(models),
+			Balance:  balance,
+			Tier:     tier,
+		})
 		if err != nil {
 			return err
 		}
@@ -72,86 +119,73 @@ func LoadAllUsers(db *Database) error {
 	return nil
 }
 
-func PopulateRedisCache(db *Database) error {
-	maxHistoryLength, err := strconv.Atoi(os.Getenv("MAX_CHAT_HISTORY_CONTEXT"))
-	if err != nil {
-		return err
-	}
-
+// PopulateRedisCache caches the latest sessions created after since along with the latest chats of their active branch
+func PopulateRedisCache(db *Database, since time.Time, limit int) error {
 	query := `
-	SELECT sd.Session_Id, sd.Session_Name, sd.User_Id, sd.Model_Id, cd.Session_Prompt, cd.Chats, cd.Chats_Summary, fd.File_Name
+	SELECT sd.Session_Id, sd.Session_Name, sd.User_Id, sd.Model_Id, sd.Last_Message_At, sd.Pinned, sd.Archived, sd.Tags, cd.Session_Prompt, cd.Chats_Summary, cd.Active_Leaf_Id, cd.Summary_Leaf_Id, ` + sessionFilesColumn + `
 	FROM Session_Details sd 
 	LEFT JOIN Chat_Details cd ON sd.Session_Id = cd.Session_Id 
-	LEFT JOIN File_Data fd ON sd.Session_Id = fd.Session_Id
+	WHERE sd.Created_At >= $1 AND sd.Deleted_At IS NULL
 	ORDER BY sd.Created_At DESC
+	LIMIT $2
 	`
-	rows, err := db.Db.Query(query)
+	rows, err := db.Db.Query(query, since, limit)
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 
+	var sessions []structures.SessionRecord
 	for rows.Next() {
-		var sessionIDTemp, sessionNameTemp, userIDTemp, modelIDTemp, sessionPromptTemp, chatsTemp, chatsSummaryTemp sql.NullString
-		var fileName []string
-		if err := rows.Scan(&sessionIDTemp, &sessionNameTemp, &userIDTemp, &modelIDTemp, &sessionPromptTemp, &chatsTemp, &chatsSummaryTemp, pq.Array(&fileName)); err != nil {
+		var sessionIDTemp, sessionNameTemp, userIDTemp, sessionPromptTemp, chatsSummaryTemp, activeLeafTemp, summaryLeafTemp sql.NullString
+		var modelIDTemp sql.NullInt64
+		var lastMessageAt sql.NullTime
+		var pinned, archived bool
+		var fileName, tags []string
+		if err := rows.Scan(&sessionIDTemp, &sessionNameTemp, &userIDTemp, &modelIDTemp, &lastMessageAt, &pinned, &archived, pq.Array(&tags), &sessionPromptTemp, &chatsSummaryTemp, &activeLeafTemp, &summaryLeafTemp, pq.Array(&fileName)); err != nil {
 			return err
 		}
 
 		if !modelIDTemp.Valid || !sessionNameTemp.Valid || !userIDTemp.Valid || !sessionIDTemp.Valid {
 			continue
 		}
-		var sessionID, userID, modelID, sessionPrompt, chats, chatsSummary, sessionName string
-		sessionID = sessionIDTemp.String
-		userID = userIDTemp.String
-		modelID = modelIDTemp.String
-		sessionPrompt = sessionPromptTemp.String
-		chats = chatsTemp.String
-		chatsSummary = chatsSummaryTemp.String
-		sessionName = sessionNameTemp.String
-
-		// Redis key for storing session data
-		key := fmt.Sprintf("user:%s:session:%s", userID, sessionID)
-
-		fmt.Println("DATA: ", sessionID, sessionPrompt, chats)
-
-		fileNameJSON, err := json.Marshal(fileName)
-		if err != nil {
-			return err
-		}
 
-		var chatsList []structures.Chat
-		if err := json.Unmarshal([]byte(chats), &chatsList); err != nil {
-			return fmt.Errorf("error parsing chats data: %w", err)
-		}
+		sessions = append(sessions, structures.SessionRecord{
+			UserId: userIDTemp.String,
+			SessionData: structures.SessionData{
+				SessionId:     sessionIDTemp.String,
+				SessionName:   sessionNameTemp.String,
+				ModelId:       int(modelIDTemp.Int64),
+				Prompt:        sessionPromptTemp.String,
+				ChatSummary:   chatsSummaryTemp.String,
+				FileName:      fileName,
+				ActiveLeafId:  activeLeafTemp.String,
+				SummaryLeafId: summaryLeafTemp.String,
+				LastMessageAt: lastMessageAt.Time,
+				Pinned:        pinned,
+				Archived:      archived,
+				Tags:          tags,
+			},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
 
-		// Keep only the latest 10 chats
-		if len(chatsList) > maxHistoryLength {
-			chatsList = chatsList[len(chatsList)-maxHistoryLength:]
-		}
+	for _, session := range sessions {
+		sessionID := session.SessionData.SessionId
 
-		// Marshal the updated chats list back to JSON
-		chatsUpdated, err := json.Marshal(chatsList)
+		// only the latest chats of the active branch are cached
+		session.SessionData.Chats, err = db.GetBranchChats(context.Background(), sessionID, session.SessionData.ActiveLeafId, helper_functions.MaxCachedChats())
 		if err != nil {
-			return fmt.Errorf("error marshaling updated chats data: %w", err)
+			return fmt.Errorf("error loading chats: %w", err)
 		}
 
-		// Redis hash fields
-		sessionData := map[string]interface{}{
-			"session_name":   sessionName,
-			"model_id":       modelID,
-			"session_prompt": sessionPrompt,
-			"chat_summary":   chatsSummary,
-			"file_name":      fileNameJSON,
-			"chats":          chatsUpdated,
-		}
-
-		_, err = db.Cache.HSet(context.Background(), key, sessionData).Result()
-		if err != nil {
+		if err = db.CacheSessionRecord(session); err != nil {
 			return err
 		}
 		fmt.Println("Loaded session:", sessionID)
-
 	}
 	return nil
 }
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"ai-chat/api_call"
+	"ai-chat/database/structures"
+	"ai-chat/utils/helper_functions"
+	"ai-chat/utils/model_data"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSummaryModel   = "gpt-3.5-turbo-0125"
+	defaultSummaryWorkers = 2
+
+	// SummaryPolicyTurns summarizes every SUMMARY_EVERY_TURNS turns
+	SummaryPolicyTurns = "turns"
+	// SummaryPolicyOverflow only summarizes once chats which aren't summarized no longer fit the context window
+	SummaryPolicyOverflow = "overflow"
+
+	summaryQueueKey = "summary:queue"
+	// a job holds the lock of its session while the model is called
+	summaryLockTTL = 5 * time.Minute
+)
+
+// setSummaryScript writes the summary ARGV[1] up to the chat ARGV[2] into the cached session KEYS[1]. Nothing is
+// written when the session isn't cached, it reads the stored summary on its next use.
+var setSummaryScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'chat_summary', ARGV[1], 'summary_leaf', ARGV[2])
+return 1
+`)
+
+// SummaryModel is the model which writes the summaries of all sessions, SUMMARY_MODEL overrides it
+func SummaryModel() string {
+	if model := os.Getenv("SUMMARY_MODEL"); model != "" {
+		return model
+	}
+	return defaultSummaryModel
+}
+
+// SummaryDue reports whether the session should be summarized after a chat turn. sentChats is the history which
+// was sent to the model along with the message. SUMMARY_POLICY=turns summarizes after SUMMARY_EVERY_TURNS replies,
+// overflow once the history didn't fit the context window anymore. Either way the session is summarized before
+// chats which aren't summarized drop out of the cached history.
+func SummaryDue(sessionData structures.SessionData, sentChats []structures.Chat) bool {
+	pending := unsummarizedChats(sessionData.Chats, sessionData.SummaryLeafId)
+	if len(pending) == 0 {
+		return false
+	}
+
+	if maxChats := helper_functions.MaxCachedChats(); maxChats > 0 && len(pending) >= maxChats {
+		return true
+	}
+
+	if os.Getenv("SUMMARY_POLICY") == SummaryPolicyOverflow {
+		// the new exchange is the last two chats, the history before the first chat sent was left out.
+		// The first chat sent may already be trimmed from the cached history, which was then sent in full.
+		sent := 0
+		if len(sentChats) > 0 {
+			sent = len(sessionData.Chats) - 2
+			for i, chat := range sessionData.Chats {
+				if chat.Id == sentChats[0].Id {
+					sent = len(sessionData.Chats) - 2 - i
+					break
+				}
+			}
+		}
+		return len(pending) > sent+2
+	}
+
+	everyTurns, err := strconv.Atoi(os.Getenv("SUMMARY_EVERY_TURNS"))
+	if err != nil || everyTurns <= 0 {
+		everyTurns = 1
+	}
+	turns := 0
+	for _, chat := range pending {
+		if chat.Role == "assistant" {
+			turns++
+		}
+	}
+	return turns >= everyTurns
+}
+
+// unsummarizedChats returns the chats after the last one the summary covers, all of them when it isn't cached
+func unsummarizedChats(chats []structures.Chat, summaryLeafId string) []structures.Chat {
+	for i := len(chats) - 1; i >= 0; i-- {
+		if chats[i].Id == summaryLeafId {
+			return chats[i+1:]
+		}
+	}
+	return chats
+}
+
+// EnqueueSummary adds a summary job for the session to the queue worked off by RunSummaryWorkers
+func (dataBase *Database) EnqueueSummary(ctx context.Context, userId string, sessionId string) error {
+	job, err := json.Marshal(structures.SummaryJob{UserId: userId, SessionId: sessionId})
+	if err != nil {
+		return err
+	}
+	return dataBase.Cache.RPush(ctx, summaryQueueKey, job).Err()
+}
+
+// RunSummaryWorkers works off the summary queue with SUMMARY_WORKERS workers until the context is cancelled
+func RunSummaryWorkers(ctx context.Context, dataBase *Database) {
+	dataBase.runQueueWorkers(ctx, summaryQueueKey, "SUMMARY_WORKERS", defaultSummaryWorkers, func(ctx context.Context, data []byte) error {
+		var job structures.SummaryJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			return fmt.Errorf("failed to parse summary job: %w", err)
+		}
+		if err := dataBase.SummarizeSession(ctx, job); err != nil {
+			return fmt.Errorf("failed to summarize session %s: %w", job.SessionId, err)
+		}
+		return nil
+	})
+}
+
+// SummarizeSession adds the chats of the session which aren't summarized yet to its summary with the SummaryModel
+// and charges the user for it. The previous summary is kept when the model call fails, the chats are picked up again
+// by the next job.
+func (dataBase *Database) SummarizeSession(ctx context.Context, job structures.SummaryJob) error {
+	lockKey := fmt.Sprintf("summary:lock:%s", job.SessionId)
+	locked, err := dataBase.Cache.SetNX(ctx, lockKey, 1, summaryLockTTL).Result()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		// another job is summarizing the session
+		return nil
+	}
+	defer dataBase.Cache.Del(context.Background(), lockKey)
+
+	sessionData, err := dataBase.GetUserSessionData(job.UserId, job.SessionId)
+	if err != nil {
+		return fmt.Errorf("failed to load session: %w", err)
+	}
+	pending := unsummarizedChats(sessionData.Chats, sessionData.SummaryLeafId)
+	if len(pending) == 0 {
+		return nil
+	}
+
+	model := SummaryModel()
+	modelId, err := model_data.ModelNumber(model)
+	if err != nil {
+		return fmt.Errorf("unknown summary model %s: %w", model, err)
+	}
+
+	// the summary is held like a chat turn, so it can't take the balance below zero
+	chats := formatSummaryChats(pending)
+	estimate, err := api_call.EstimateSummaryCost(sessionData.ChatSummary, chats, model)
+	if err != nil {
+		return fmt.Errorf("failed to estimate summary cost: %w", err)
+	}
+	holdId, ok, err := dataBase.ReserveBalance(ctx, job.UserId, estimate)
+	if err != nil {
+		return fmt.Errorf("failed to hold summary cost: %w", err)
+	} else if !ok {
+		log.Printf("Skipping summary of session %s, the balance does not cover %f\n", job.SessionId, estimate)
+		return nil
+	}
+	settled := false
+	defer func() {
+		if !settled {
+			if err := dataBase.ReleaseHold(context.Background(), job.UserId, holdId); err != nil {
+				log.Println("Unable to release summary hold:", err)
+			}
+		}
+	}()
+
+	summary, err := dataBase.GetUpdatedSummary(sessionData.ChatSummary, chats, model)
+	if err != nil {
+		return err
+	}
+	summaryLeafId := pending[len(pending)-1].Id
+
+	ledger := []structures.LedgerEntry{{
+		EntryId:      uuid.New().String(),
+		UserId:       job.UserId,
+		Amount:       -summary.Cost,
+		Reason:       structures.LedgerReasonSummary,
+		ModelId:      &modelId,
+		SessionId:    &job.SessionId,
+		InputTokens:  summary.InputTokens,
+		OutputTokens: summary.OutputTokens,
+	}}
+	if err := dataBase.Stream.AddSummaryToStream(ctx, job.UserId, job.SessionId, summary.Text, summaryLeafId, ledger); err != nil {
+		return fmt.Errorf("failed to publish summary: %w", err)
+	}
+
+	key := fmt.Sprintf("user:%s:session:%s", job.UserId, job.SessionId)
+	if err := setSummaryScript.Run(ctx, dataBase.Cache, []string{key}, summary.Text, summaryLeafId).Err(); err != nil {
+		return fmt.Errorf("failed to cache summary: %w", err)
+	}
+
+	balance, err := dataBase.SettleHold(ctx, job.UserId, holdId, ledger)
+	settled = err == nil
+	if err != nil {
+		return fmt.Errorf("failed to charge summary: %w", err)
+	}
+	log.Printf("Summary Cost: %f for session %s, remaining balance: %f\n", summary.Cost, job.SessionId, balance)
+	return nil
+}
+
+// formatSummaryChats writes the chats as the conversation the summary prompt expects
+func formatSummaryChats(chats []structures.Chat) string {
+	lines := make([]string, 0, len(chats))
+	for _, chat := range chats {
+		role := chat.Role
+		switch role {
+		case "user":
+			role = "User"
+		case "assistant":
+			role = "Assistant"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", role, chat.Content))
+	}
+	return strings.Join(lines, "\n\n")
+}
+
+// StoreSessionSummary persists the summary of a session up to summaryLeafId. Summaries of sessions which aren't
+// stored yet fail, so the stream entry is retried once the session is.
+func (dataBase *Database) StoreSessionSummary(ctx context.Context, sessionId string, summary string, summaryLeafId string) error {
+	query := `UPDATE Chat_Details SET Chats_Summary = $2, Summary_Leaf_Id = $3 WHERE Session_Id = $1`
+	result, err := dataBase.Db.ExecContext(ctx, query, sessionId, summary, summaryLeafId)
+	if err != nil {
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return errors.New("no rows were affected, possible invalid session_id")
+	}
+	return nil
+}
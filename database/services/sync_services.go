@@ -0,0 +1,113 @@
+package services
+
+import (
+	"ai-chat/database/structures"
+	"ai-chat/sync_worker/worker"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ApplyStreamEntry persists one chat turn, session update or summary read from the Redis stream.
+// Entries can be delivered more than once, so new sessions and their first chats are skipped if they already exist.
+// A session deleted while its first turn waited in the stream is already stored in the trash and stays there.
+func (dataBase *Database) ApplyStreamEntry(ctx context.Context, entry worker.StreamEntry) error {
+	if entry.Kind == worker.EntryKindSession {
+		if err := dataBase.StoreSessionDetails(ctx, entry.SessionId, entry.Details); err != nil {
+			return fmt.Errorf("error while storing session details: %w", err)
+		}
+		if err := dataBase.addStreamLedgerEntries(ctx, entry.Ledger); err != nil {
+			return fmt.Errorf("error while adding ledger entries: %w", err)
+		}
+		return nil
+	}
+	if entry.Kind == worker.EntryKindSummary {
+		if err := dataBase.StoreSessionSummary(ctx, entry.SessionId, entry.ChatsSummary, entry.SummaryLeafId); err != nil {
+			return fmt.Errorf("error while storing summary: %w", err)
+		}
+		if err := dataBase.addStreamLedgerEntries(ctx, entry.Ledger); err != nil {
+			return fmt.Errorf("error while adding ledger entries: %w", err)
+		}
+		return nil
+	}
+
+	if entry.IsNew {
+		err := dataBase.AddSession(ctx, entry.UserId, entry.SessionId, entry.ModelId, entry.SessionName)
+		if err != nil && !strings.Contains(err.Error(), "duplicate") {
+			return fmt.Errorf("error while adding session: %w", err)
+		}
+
+		err = dataBase.AddChat(ctx, entry.SessionId, entry.SessionPrompt, entry.Chats, entry.ChatsSummary, entry.ActiveLeafId)
+		if err != nil && !strings.Contains(err.Error(), "duplicate") {
+			return fmt.Errorf("error while adding chat: %w", err)
+		}
+	} else {
+		if err := dataBase.AppendChat(ctx, entry.SessionId, entry.Chats, entry.ChatsSummary, entry.ActiveLeafId); err != nil {
+			return fmt.Errorf("error while appending chat: %w", err)
+		}
+	}
+
+	if err := dataBase.addStreamLedgerEntries(ctx, entry.Ledger); err != nil {
+		return fmt.Errorf("error while adding ledger entries: %w", err)
+	}
+
+	return nil
+}
+
+// addStreamLedgerEntries stores the ledger entries of a stream entry, they are no longer pending afterwards
+func (dataBase *Database) addStreamLedgerEntries(ctx context.Context, entries []structures.LedgerEntry) error {
+	if err := dataBase.AddLedgerEntries(ctx, entries); err != nil {
+		return err
+	}
+	if err := dataBase.ClearPendingLedger(ctx, entries); err != nil {
+		// left pending, they are found in Credit_Ledger and dropped when the user is loaded
+		log.Println("Unable to clear pending ledger entries:", err)
+	}
+	return nil
+}
+
+// AppendChat adds new chats to the session. Entries written before summaries were generated in the background
+// carry the summary, newer ones leave it to the summary entries.
+// Entries written before chats could branch carry no active leaf, their last chat becomes the active leaf.
+func (dataBase *Database) AppendChat(ctx context.Context, sessionId string, chats string, chatSummary string, activeLeafId string) error {
+	tx, err := dataBase.Db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	lastChatId, err := addChatMessages(ctx, tx, sessionId, chats)
+	if err != nil {
+		return err
+	}
+	if activeLeafId == "" {
+		activeLeafId = lastChatId
+	}
+
+	query := `UPDATE Chat_Details SET Chats_Summary = COALESCE(NULLIF($2, ''), Chats_Summary), Active_Leaf_Id = COALESCE(NULLIF($3, ''), Active_Leaf_Id) WHERE Session_Id = $1`
+	result, err := tx.ExecContext(ctx, query, sessionId, chatSummary, activeLeafId)
+	if err != nil {
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		err = errors.New("no rows were affected, possible invalid session_id")
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
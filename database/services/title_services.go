@@ -0,0 +1,110 @@
+package services
+
+import (
+	"ai-chat/api_call"
+	"ai-chat/database/structures"
+	"ai-chat/utils/helper_functions"
+	"ai-chat/utils/model_data"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	defaultTitleModel = "gpt-3.5-turbo-0125"
+	maxTitleLength    = 60
+)
+
+// ErrSessionRenamed is returned when the session keeps its name, because it got another one while the title was
+// generated or no title came back
+var ErrSessionRenamed = errors.New("session was renamed")
+
+// TitleModel is the model which names sessions after their first exchange, TITLE_MODEL overrides it
+func TitleModel() string {
+	if model := os.Getenv("TITLE_MODEL"); model != "" {
+		return model
+	}
+	return defaultTitleModel
+}
+
+// GenerateSessionTitle asks the title model for a title of the first exchange of a session and renames the session
+// with it, unless it's no longer called name. The cost is held before the call, ErrBalanceNotCovered is returned
+// without calling the model when the balance doesn't cover it. The call is charged to the user either way, unless
+// the session is gone.
+func (dataBase *Database) GenerateSessionTitle(userId string, sessionId string, name string, message string, reply string) (structures.SessionDetails, error) {
+	model := TitleModel()
+	modelId, err := model_data.ModelNumber(model)
+	if err != nil {
+		return structures.SessionDetails{}, fmt.Errorf("unknown title model %s: %w", model, err)
+	}
+
+	estimate, err := api_call.EstimateTitleCost(message, reply, model)
+	if err != nil {
+		return structures.SessionDetails{}, fmt.Errorf("failed to estimate title cost: %w", err)
+	}
+	holdId, ok, err := dataBase.ReserveBalance(context.Background(), userId, estimate)
+	if err != nil {
+		return structures.SessionDetails{}, fmt.Errorf("failed to hold title cost: %w", err)
+	} else if !ok {
+		return structures.SessionDetails{}, ErrBalanceNotCovered
+	}
+	settled := false
+	defer func() {
+		if !settled {
+			if err := dataBase.ReleaseHold(context.Background(), userId, holdId); err != nil {
+				log.Println("Unable to release title hold:", err)
+			}
+		}
+	}()
+
+	response, err := dataBase.AIService.ApiTitle(message, reply, model)
+	if err != nil {
+		return structures.SessionDetails{}, fmt.Errorf("failed to generate title: %w", err)
+	}
+
+	// the entries are persisted with the new name, the cached balance is charged once the session took it
+	ledger := []structures.LedgerEntry{{
+		EntryId:      uuid.New().String(),
+		UserId:       userId,
+		Amount:       -response.Cost,
+		Reason:       structures.LedgerReasonTitle,
+		ModelId:      &modelId,
+		SessionId:    &sessionId,
+		InputTokens:  response.InputTokens,
+		OutputTokens: response.OutputTokens,
+	}}
+
+	title := cleanTitle(response.Text)
+	renamed := false
+	details, err := dataBase.UpdateSessionDetails(userId, sessionId, func(details *structures.SessionDetails) {
+		if title != "" && details.SessionName == name {
+			details.SessionName = title
+			renamed = true
+		}
+	}, ledger)
+	if err != nil {
+		return structures.SessionDetails{}, err
+	}
+	_, err = dataBase.SettleHold(context.Background(), userId, holdId, ledger)
+	settled = err == nil
+	if err != nil {
+		return structures.SessionDetails{}, fmt.Errorf("failed to charge title: %w", err)
+	}
+
+	if !renamed {
+		return details, ErrSessionRenamed
+	}
+	return details, nil
+}
+
+// cleanTitle keeps the first line of a generated title without the quotes and labels models like to add
+func cleanTitle(text string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	title = strings.TrimSpace(strings.TrimPrefix(title, "Title:"))
+	title = strings.Trim(title, "\"'`*#. ")
+	return helper_functions.TruncateText(title, maxTitleLength)
+}
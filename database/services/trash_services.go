@@ -0,0 +1,269 @@
+package services
+
+import (
+	"ai-chat/database/storage"
+	"ai-chat/database/structures"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultTrashRetention = 30 * 24 * time.Hour
+	// minutes between two runs of the trash purger
+	defaultTrashPurgeInterval = 60
+)
+
+// ErrNoSessionsAffected is returned when none of the requested sessions belongs to the user in the required state
+var ErrNoSessionsAffected = errors.New("no sessions were affected")
+
+// TrashRetention is how long deleted sessions stay in the trash before they are purged, TRASH_RETENTION_DAYS overrides it
+func TrashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days < 0 {
+		return defaultTrashRetention
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// DeleteSession moves the user's sessions to the trash and drops them from the cache,
+// sessions which are already in the trash or belong to another user are skipped. Sessions whose first turn is still
+// in the stream only exist in the cache, they are stored in the trash so the stream can't bring them back.
+func (dataBase *Database) DeleteSession(userId string, sessionIds []string) (structures.SessionDeleteResponse, error) {
+	ctx := context.Background()
+
+	var deleted []string
+	var deletedAt time.Time
+	query := `
+	UPDATE Session_Details SET Deleted_At = CURRENT_TIMESTAMP, Updated_At = CURRENT_TIMESTAMP
+	WHERE Session_Id = ANY($2) AND User_Id = $1 AND Deleted_At IS NULL
+	RETURNING Session_Id, Deleted_At
+	`
+	rows, err := dataBase.Db.QueryContext(ctx, query, userId, pq.Array(sessionIds))
+	if err != nil {
+		return structures.SessionDeleteResponse{}, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sessionId string
+		if err := rows.Scan(&sessionId, &deletedAt); err != nil {
+			return structures.SessionDeleteResponse{}, err
+		}
+		deleted = append(deleted, sessionId)
+	}
+	if err := rows.Err(); err != nil {
+		return structures.SessionDeleteResponse{}, err
+	}
+
+	keys := make([]string, 0, len(deleted))
+	for _, sessionId := range deleted {
+		keys = append(keys, fmt.Sprintf("user:%s:session:%s", userId, sessionId))
+	}
+	if len(keys) > 0 {
+		if err := dataBase.Cache.Del(ctx, keys...).Err(); err != nil {
+			return structures.SessionDeleteResponse{}, err
+		}
+	}
+
+	for _, sessionId := range sessionIds {
+		if slices.Contains(deleted, sessionId) {
+			continue
+		}
+		cachedDeletedAt, err := dataBase.trashCachedSession(ctx, userId, sessionId)
+		if errors.Is(err, ErrNoSessionsAffected) {
+			continue
+		} else if err != nil {
+			return structures.SessionDeleteResponse{}, err
+		}
+		deleted = append(deleted, sessionId)
+		deletedAt = cachedDeletedAt
+	}
+	if len(deleted) == 0 {
+		return structures.SessionDeleteResponse{}, ErrNoSessionsAffected
+	}
+
+	return structures.SessionDeleteResponse{
+		UserId:     userId,
+		SessionIds: deleted,
+		PurgeAt:    deletedAt.Add(TrashRetention()),
+	}, nil
+}
+
+// trashCachedSession stores a session which only exists in the cache in the trash and drops it from the cache.
+// When its first turn is applied from the stream later on, the session is already stored and stays in the trash.
+// ErrNoSessionsAffected is returned when the session isn't cached or was already in the trash.
+func (dataBase *Database) trashCachedSession(ctx context.Context, userId, sessionId string) (time.Time, error) {
+	key := fmt.Sprintf("user:%s:session:%s", userId, sessionId)
+	values, err := dataBase.Cache.HMGet(ctx, key, "model_id", "session_name").Result()
+	if err != nil {
+		return time.Time{}, err
+	}
+	modelIdValue, _ := values[0].(string)
+	sessionName, _ := values[1].(string)
+	if modelIdValue == "" {
+		return time.Time{}, ErrNoSessionsAffected
+	}
+	modelId, err := strconv.Atoi(modelIdValue)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cached model id %q: %w", modelIdValue, err)
+	}
+
+	// the first turn may have been applied since the sessions were moved to the trash
+	query := `
+	INSERT INTO Session_Details (Session_Id, User_Id, Model_Id, Session_Name, Deleted_At, Updated_At)
+	VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	ON CONFLICT (Session_Id) DO UPDATE SET Deleted_At = EXCLUDED.Deleted_At, Updated_At = EXCLUDED.Updated_At
+	WHERE Session_Details.User_Id = EXCLUDED.User_Id AND Session_Details.Deleted_At IS NULL
+	RETURNING Deleted_At
+	`
+	var deletedAt time.Time
+	err = dataBase.Db.QueryRowContext(ctx, query, sessionId, userId, modelId, sessionName).Scan(&deletedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	if err := dataBase.Cache.Del(ctx, key).Err(); err != nil {
+		return time.Time{}, err
+	}
+	if deletedAt.IsZero() {
+		return time.Time{}, ErrNoSessionsAffected
+	}
+	return deletedAt, nil
+}
+
+// RestoreSessions takes the user's sessions out of the trash, they are read into the cache again on first use
+func (dataBase *Database) RestoreSessions(userId string, sessionIds []string) (structures.SessionTrashResponse, error) {
+	query := `
+	UPDATE Session_Details SET Deleted_At = NULL, Updated_At = CURRENT_TIMESTAMP
+	WHERE Session_Id = ANY($2) AND User_Id = $1 AND Deleted_At IS NOT NULL
+	RETURNING Session_Id
+	`
+	var restored []string
+	if err := dataBase.Db.SelectContext(context.Background(), &restored, query, userId, pq.Array(sessionIds)); err != nil {
+		return structures.SessionTrashResponse{}, fmt.Errorf("failed to execute query: %w", err)
+	}
+	if len(restored) == 0 {
+		return structures.SessionTrashResponse{}, ErrNoSessionsAffected
+	}
+
+	return structures.SessionTrashResponse{
+		UserId:     userId,
+		SessionIds: restored,
+	}, nil
+}
+
+// PurgeSessions permanently deletes the user's sessions from the trash together with their files,
+// no session ids empties the whole trash of the user.
+func (dataBase *Database) PurgeSessions(userId string, sessionIds []string) (structures.SessionTrashResponse, error) {
+	query := `
+	SELECT Session_Id FROM Session_Details
+	WHERE User_Id = $1 AND Deleted_At IS NOT NULL AND (cardinality($2::UUID[]) = 0 OR Session_Id = ANY($2))
+	FOR UPDATE
+	`
+	purged, err := dataBase.purgeSessions(context.Background(), query, userId, pq.Array(sessionIds))
+	if err != nil {
+		return structures.SessionTrashResponse{}, err
+	}
+
+	return structures.SessionTrashResponse{
+		UserId:     userId,
+		SessionIds: purged,
+	}, nil
+}
+
+// PurgeExpiredTrash permanently deletes the sessions of every user which have been in the trash longer than retention
+func (dataBase *Database) PurgeExpiredTrash(ctx context.Context, retention time.Duration) (int, error) {
+	query := `SELECT Session_Id FROM Session_Details WHERE Deleted_At < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second' FOR UPDATE`
+	purged, err := dataBase.purgeSessions(ctx, query, retention.Seconds())
+	return len(purged), err
+}
+
+// purgeSessions deletes the sessions selected and locked by query, their chats and file records are deleted
+// by the cascade and the uploaded files are removed from the storage once the rows are gone.
+func (dataBase *Database) purgeSessions(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	tx, err := dataBase.Db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var sessionIds []string
+	if err = tx.SelectContext(ctx, &sessionIds, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to select sessions: %w", err)
+	}
+	if len(sessionIds) == 0 {
+		err = ErrNoSessionsAffected
+		return nil, err
+	}
+
+	var fileNames []string
+	filesQuery := `
+	SELECT DISTINCT File_Name FROM (
+		SELECT File_Name FROM File_Metadata WHERE Session_Id = ANY($1)
+		UNION
+		SELECT unnest(Attachments) FROM Chat_Messages WHERE Session_Id = ANY($1)
+	) files
+	`
+	if err = tx.SelectContext(ctx, &fileNames, filesQuery, pq.Array(sessionIds)); err != nil {
+		return nil, fmt.Errorf("failed to select session files: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM Session_Details WHERE Session_Id = ANY($1)`, pq.Array(sessionIds)); err != nil {
+		return nil, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// the sessions are gone, a file which can't be removed is only left behind in the storage
+	for _, fileName := range fileNames {
+		err := dataBase.Files.Delete(ctx, fileName)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Println("delete file error --> ", err)
+		}
+	}
+	if err := dataBase.DeleteSessionEmbeddings(ctx, sessionIds); err != nil {
+		log.Println("Unable to delete session embeddings:", err)
+	}
+	return sessionIds, nil
+}
+
+// RunTrashPurger purges the sessions which stayed in the trash longer than TrashRetention every
+// TRASH_PURGE_INTERVAL_MINUTES minutes until the context is cancelled, an interval of 0 disables it.
+func RunTrashPurger(ctx context.Context, dataBase *Database) {
+	interval, err := strconv.Atoi(os.Getenv("TRASH_PURGE_INTERVAL_MINUTES"))
+	if err != nil || interval < 0 {
+		interval = defaultTrashPurgeInterval
+	}
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := dataBase.PurgeExpiredTrash(ctx, TrashRetention())
+			if err != nil && !errors.Is(err, ErrNoSessionsAffected) {
+				log.Println("Unable to purge trash:", err)
+			} else if purged > 0 {
+				log.Println("Purged sessions from trash:", purged)
+			}
+		}
+	}
+}
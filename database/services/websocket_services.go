@@ -3,20 +3,21 @@ package services
 import (
 	"ai-chat/api_call"
 	"ai-chat/database/initialize"
+	"ai-chat/database/storage"
 	"ai-chat/database/structures"
 	"ai-chat/sync_worker/worker"
+	"ai-chat/utils/helper_functions"
 	"ai-chat/utils/model_data"
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/redis/go-redis/v9"
-	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Database struct {
@@ -24,6 +25,7 @@ type Database struct {
 	Cache     *redis.Client
 	Stream    *worker.StreamDataBase
 	AIService *api_call.AIClient
+	Files     storage.Storage
 }
 
 func GetDataBase() *Database {
@@ -32,40 +34,10 @@ func GetDataBase() *Database {
 		Cache:     initialize.InitRedis(),
 		Stream:    worker.GetStreamDataBase(),
 		AIService: api_call.InitAIClient(),
+		Files:     initialize.InitStorage(),
 	}
 }
 
-func (dataBase *Database) DeleteSession(userId string, sessionId string) (structures.SessionDeleteResponse, error) {
-	key := fmt.Sprintf("user:%s:session:%s", userId, sessionId)
-	_, err := dataBase.Cache.Del(context.Background(), key).Result()
-	if err != nil {
-		return structures.SessionDeleteResponse{}, err
-	}
-
-	tx := dataBase.Db.MustBegin()
-	query := `DELETE FROM Session_Details WHERE Session_Id = $1`
-	rows := tx.MustExec(query, sessionId)
-
-	affected, err := rows.RowsAffected()
-	if err != nil {
-		return structures.SessionDeleteResponse{}, errors.New("unable To Get Affected Rows")
-	}
-
-	if affected <= 0 {
-		fmt.Println("User Not Exist")
-		return structures.SessionDeleteResponse{}, errors.New("no Rows Get Affected")
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		return structures.SessionDeleteResponse{}, errors.New("unable To Commit")
-	}
-
-	return structures.SessionDeleteResponse{
-		UserId: userId,
-	}, nil
-}
-
 func (dataBase *Database) GetUserDetails(userId string) (*structures.UserDataResponse, error) {
 	var data structures.UserDataResponse
 	err := dataBase.Db.Get(&data, "select user_id, username from user_data where user_id=$1", userId)
@@ -80,11 +52,6 @@ func (dataBase *Database) CreateNewSession(userId string, sessionData structures
 	// Generate a new UUID for the session
 	sessionId := uuid.New().String()
 
-	chatsJSON, err := json.Marshal(sessionData.Chats)
-	if err != nil {
-		return "", err
-	}
-
 	fileNameJSON, err := json.Marshal(sessionData.FileName)
 	if err != nil {
 		return "", err
@@ -93,12 +60,18 @@ func (dataBase *Database) CreateNewSession(userId string, sessionData structures
 	// Update Redis with the new session information
 	key := fmt.Sprintf("user:%s:session:%s", userId, sessionId)
 	data := map[string]interface{}{
-		"model_id":       sessionData.ModelId,
-		"session_prompt": sessionData.Prompt,
-		"chats":          "[]", // Start with an empty chats array
-		"session_name":   sessionData.SessionName,
-		"chat_summary":   chatsJSON,
-		"file_name":      fileNameJSON,
+		"model_id":        sessionData.ModelId,
+		"session_prompt":  sessionData.Prompt,
+		"chats":           "[]", // Start with an empty chats array
+		"session_name":    sessionData.SessionName,
+		"chat_summary":    sessionData.ChatSummary,
+		"file_name":       fileNameJSON,
+		"active_leaf":     "",
+		"summary_leaf":    "",
+		"last_message_at": "",
+		"pinned":          false,
+		"archived":        false,
+		"tags":            "[]",
 	}
 
 	_, err = dataBase.Cache.HSet(context.Background(), key, data).Result()
@@ -106,23 +79,34 @@ func (dataBase *Database) CreateNewSession(userId string, sessionData structures
 		return "", err
 	}
 
+	if err = dataBase.touchCache(context.Background(), key); err != nil {
+		return "", err
+	}
+
 	return sessionId, nil
 }
 
-func (dataBase *Database) SetUserValues(userId string, balance float64) error {
-	// Redis key for storing user data
-	userKey := fmt.Sprintf("user:%s", userId)
-
-	_, err := dataBase.Cache.HMSet(context.Background(), userKey, map[string]interface{}{
-		"balance": balance,
-	}).Result()
+// DeleteCachedSession removes the session from the cache only, for sessions which never reached the stream.
+// ErrNoSessionsAffected is returned when the session isn't cached.
+func (dataBase *Database) DeleteCachedSession(ctx context.Context, userId, sessionId string) error {
+	deleted, err := dataBase.Cache.Del(ctx, fmt.Sprintf("user:%s:session:%s", userId, sessionId)).Result()
 	if err != nil {
 		return err
 	}
-
+	if deleted == 0 {
+		return ErrNoSessionsAffected
+	}
 	return nil
 }
 
+// formatCacheTime stores times in the cache, the zero time is stored as an empty string
+func formatCacheTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
 func (dataBase *Database) SetSessionValues(userId string, sessionData structures.SessionData) error {
 	chatsJSON, err := json.Marshal(sessionData.Chats)
 	if err != nil {
@@ -134,31 +118,65 @@ func (dataBase *Database) SetSessionValues(userId string, sessionData structures
 		return err
 	}
 
+	tagsJSON, err := json.Marshal(sessionData.Tags)
+	if err != nil {
+		return err
+	}
+
 	// Update Redis with the new session information
 	key := fmt.Sprintf("user:%s:session:%s", userId, sessionData.SessionId)
 	data := map[string]interface{}{
-		"session_name":   sessionData.SessionName,
-		"model_id":       sessionData.ModelId,
-		"session_prompt": sessionData.Prompt,
-		"chats":          chatsJSON, // Start with an empty chats array
-		"chat_summary":   sessionData.ChatSummary,
-		"file_name":      fileNameJSON,
+		"session_name":    sessionData.SessionName,
+		"model_id":        sessionData.ModelId,
+		"session_prompt":  sessionData.Prompt,
+		"chats":           chatsJSON, // Start with an empty chats array
+		"chat_summary":    sessionData.ChatSummary,
+		"file_name":       fileNameJSON,
+		"active_leaf":     sessionData.ActiveLeafId,
+		"summary_leaf":    sessionData.SummaryLeafId,
+		"last_message_at": formatCacheTime(sessionData.LastMessageAt),
+		"pinned":          sessionData.Pinned,
+		"archived":        sessionData.Archived,
+		"tags":            tagsJSON,
 	}
 
 	_, err = dataBase.Cache.HSet(context.Background(), key, data).Result()
 	if err != nil {
 		return err
 	}
-	return nil
+	return dataBase.touchCache(context.Background(), key)
 }
 
-func (dataBase *Database) AddNewFileInSessionData(userId string, sessionId string, fileName string) error {
+// SetSessionChats writes the chats of a cached session after a chat turn, leaving the settings its user may have
+// changed and the summary a summary job may have written in the meantime alone. A session which was deleted or
+// expired during the turn isn't cached again, its chats are read from the stream's writes on its next use.
+func (dataBase *Database) SetSessionChats(userId string, sessionData structures.SessionData) error {
+	chatsJSON, err := json.Marshal(sessionData.Chats)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("user:%s:session:%s", userId, sessionData.SessionId)
+	return dataBase.setCachedFields(context.Background(), key, map[string]interface{}{
+		"chats":           chatsJSON,
+		"active_leaf":     sessionData.ActiveLeafId,
+		"last_message_at": formatCacheTime(sessionData.LastMessageAt),
+	})
+}
+
+// AddNewFileInSessionData adds a file saved with SaveFile to the cached files of the session, only stored files
+// belong to it
+func (dataBase *Database) AddNewFileInSessionData(userId string, sessionId string, file structures.FileMetadata) error {
+	if file.Status != structures.FileStatusStored {
+		return fmt.Errorf("file %s is %s, not stored", file.FileName, file.Status)
+	}
+
 	sessionData, err := dataBase.GetUserSessionData(userId, sessionId)
 	if err != nil {
 		return err
 	}
 
-	sessionData.FileName = append(sessionData.FileName, fileName)
+	sessionData.FileName = append(sessionData.FileName, file.FileName)
 	err = dataBase.SetSessionValues(userId, sessionData)
 	if err != nil {
 		return err
@@ -191,6 +209,13 @@ func (dataBase *Database) DeleteFileFromSessionData(userId string, sessionId str
 	return nil
 }
 
+var (
+	// ErrSessionNotFound is returned when the session doesn't exist or is in the trash
+	ErrSessionNotFound = errors.New("no session data found")
+	// ErrSessionForbidden is returned when the session belongs to another user
+	ErrSessionForbidden = errors.New("session belongs to another user")
+)
+
 func (dataBase *Database) GetUserSessionData(userId string, sessionId string) (structures.SessionData, error) {
 	// Construct the key to access the session data in Redis
 	key := fmt.Sprintf("user:%s:session:%s", userId, sessionId)
@@ -202,7 +227,17 @@ func (dataBase *Database) GetUserSessionData(userId string, sessionId string) (s
 	}
 
 	if len(values) == 0 {
-		return structures.SessionData{}, errors.New("no session data found")
+		// not cached, read it through from the database
+		if err := dataBase.loadSessionIntoCache(context.Background(), userId, sessionId); err == redis.Nil {
+			return structures.SessionData{}, ErrSessionNotFound
+		} else if err != nil {
+			return structures.SessionData{}, err
+		}
+
+		values, err = dataBase.Cache.HGetAll(context.Background(), key).Result()
+		if err != nil {
+			return structures.SessionData{}, fmt.Errorf("error retrieving session data from Redis: %w", err)
+		}
 	}
 
 	// Parse the chats from the retrieved data
@@ -211,6 +246,21 @@ func (dataBase *Database) GetUserSessionData(userId string, sessionId string) (s
 		return structures.SessionData{}, fmt.Errorf("error parsing chats data: %w", err)
 	}
 
+	// chats cached before chats had ids are replaced by the stored ones, which get their ids when they're read
+	if len(chats) > 0 && chats[0].Id == "" {
+		if err := dataBase.loadSessionIntoCache(context.Background(), userId, sessionId); err == nil {
+			return dataBase.GetUserSessionData(userId, sessionId)
+		}
+		helper_functions.EnsureChatIds(sessionId, chats)
+	}
+
+	activeLeafId := values["active_leaf"]
+	if activeLeafId == "" && len(chats) > 0 {
+		activeLeafId = chats[len(chats)-1].Id
+	}
+	// files cached as chats of their own become attachments like the stored ones
+	chats, activeLeafId = helper_functions.FoldFileChats(chats, activeLeafId)
+
 	var fileName []string
 	if err := json.Unmarshal([]byte(values["file_name"]), &fileName); err != nil {
 		return structures.SessionData{}, fmt.Errorf("error parsing file_name data: %w", err)
@@ -221,15 +271,38 @@ func (dataBase *Database) GetUserSessionData(userId string, sessionId string) (s
 		return structures.SessionData{}, fmt.Errorf("error parsing modelId: %w", err)
 	}
 
+	// sessions cached before activity was tracked have no time yet
+	lastMessageAt, _ := time.Parse(time.RFC3339Nano, values["last_message_at"])
+
+	// sessions cached while summaries were updated after every turn are summarized up to their active leaf
+	summaryLeafId, ok := values["summary_leaf"]
+	if !ok && values["chat_summary"] != "" {
+		summaryLeafId = activeLeafId
+	}
+
+	// as have their settings
+	var tags []string
+	if values["tags"] != "" {
+		if err := json.Unmarshal([]byte(values["tags"]), &tags); err != nil {
+			return structures.SessionData{}, fmt.Errorf("error parsing tags data: %w", err)
+		}
+	}
+
 	// Construct the session data structure
 	sessionData := structures.SessionData{
-		SessionName: values["session_name"],
-		SessionId:   sessionId,
-		ModelId:     modelId,
-		Prompt:      values["session_prompt"],
-		ChatSummary: values["chat_summary"],
-		FileName:    fileName,
-		Chats:       chats,
+		SessionName:   values["session_name"],
+		SessionId:     sessionId,
+		ModelId:       modelId,
+		Prompt:        values["session_prompt"],
+		ChatSummary:   values["chat_summary"],
+		FileName:      fileName,
+		Chats:         chats,
+		ActiveLeafId:  activeLeafId,
+		SummaryLeafId: summaryLeafId,
+		LastMessageAt: lastMessageAt,
+		Pinned:        values["pinned"] == "1",
+		Archived:      values["archived"] == "1",
+		Tags:          tags,
 	}
 
 	return sessionData, nil
@@ -245,6 +318,18 @@ func (dataBase *Database) CheckModelAccessAndGetBalance(userId string, modelId i
 		return 0, err
 	}
 
+	if len(userData) == 0 {
+		// not cached, read it through from the database
+		if err := dataBase.loadUserIntoCache(context.Background(), userId); err != nil {
+			return 0, err
+		}
+
+		userData, err = dataBase.Cache.HGetAll(context.Background(), userKey).Result()
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	modelsJSON := userData["models"]
 	balance, err := strconv.ParseFloat(userData["balance"], 64)
 	if err != nil {
@@ -265,83 +350,80 @@ func (dataBase *Database) CheckModelAccessAndGetBalance(userId string, modelId i
 	return 0, errors.New("access denied: user does not have access to this model")
 }
 
-func (dataBase *Database) GetSessionsByUserId(userId string) (structures.UserSessionResponse, error) {
-	// Use parameterized query to prevent SQL injection
-	query := "SELECT session_id, session_name FROM session_details WHERE user_id=$1 ORDER BY session_name DESC"
+// GetSessionMessages returns the cached session along with every chat of every branch of the session: the stored
+// ones followed by the cached ones which aren't persisted yet.
+func (dataBase *Database) GetSessionMessages(userId string, sessionId string) (structures.SessionData, []structures.Chat, error) {
+	// reading the cached session first makes sure it belongs to the user
+	sessionData, err := dataBase.GetUserSessionData(userId, sessionId)
+	if err != nil {
+		return structures.SessionData{}, nil, err
+	}
 
-	rows, err := dataBase.Db.Query(query, userId)
+	stored, err := dataBase.GetSessionRecord(context.Background(), sessionId)
 	if err != nil {
-		return structures.UserSessionResponse{}, err
+		return structures.SessionData{}, nil, err
 	}
-	defer rows.Close()
 
-	var sessionInfo []structures.SessionInfo
-	for rows.Next() {
-		var sessionIDTemp, sessionNameTemp sql.NullString
-		if err := rows.Scan(&sessionIDTemp, &sessionNameTemp); err != nil {
-			return structures.UserSessionResponse{}, err
-		}
+	var chats []structures.Chat
+	if stored != nil {
+		chats = stored.Chats
+	}
 
-		// Check if SQL values are not null before appending
-		sessionId := ""
-		if sessionIDTemp.Valid {
-			sessionId = sessionIDTemp.String
-		}
-		sessionName := ""
-		if sessionNameTemp.Valid {
-			sessionName = sessionNameTemp.String
+	known := make(map[string]bool, len(chats))
+	for _, chat := range chats {
+		known[chat.Id] = true
+	}
+	for _, chat := range sessionData.Chats {
+		if !known[chat.Id] {
+			chats = append(chats, chat)
 		}
-
-		sessionInfo = append(sessionInfo, structures.SessionInfo{
-			SessionId:   sessionId,
-			SessionName: sessionName,
-		})
 	}
-
-	return structures.UserSessionResponse{
-		UserId:  userId,
-		Session: sessionInfo,
-	}, nil
+	return sessionData, chats, nil
 }
 
-func (dataBase *Database) GetUserSessionChat(sessionId string) (string, error) {
-	// Use parameterized query to prevent SQL injection
-	query := "SELECT Chats FROM Chat_Details WHERE Session_Id=$1"
-
-	rows, err := dataBase.Db.Query(query, sessionId)
+// SwitchBranch makes leafId the end of the active branch of the session, chats must be every chat of the session.
+// The cache gets the latest chats of the new branch and the switch is published to the stream to be persisted.
+func (dataBase *Database) SwitchBranch(userId string, sessionData structures.SessionData, chats []structures.Chat, leafId string) (structures.SessionData, error) {
+	sessionData.ActiveLeafId = leafId
+	sessionData.Chats = helper_functions.LatestChats(helper_functions.BranchPath(chats, leafId))
+	if err := dataBase.SetSessionValues(userId, sessionData); err != nil {
+		return structures.SessionData{}, err
+	}
+
+	err := dataBase.Stream.AddToStream(
+		context.Background(),
+		userId,
+		sessionData.SessionId,
+		fmt.Sprintf("%d", sessionData.ModelId),
+		sessionData.Prompt,
+		"[]",
+		sessionData.ChatSummary,
+		sessionData.SessionName,
+		false,
+		"",
+		leafId)
 	if err != nil {
-		return "", err
-	}
-	defer rows.Close()
-
-	var chats string = "[]"
-	for rows.Next() {
-		var chatsTemp sql.NullString
-		if err := rows.Scan(&chatsTemp); err != nil {
-			return "", err
-		}
-
-		// Check if SQL values are not null before appending
-		if chatsTemp.Valid {
-			chats = chatsTemp.String
-		}
+		return structures.SessionData{}, err
 	}
-	return chats, nil
+	return sessionData, nil
 }
 
 func (dataBase *Database) GetAIModel() (structures.AIModelsResponse, error) {
-	modelNames := model_data.GetModelsName()
-	sort.Strings(modelNames) // Sorting the slice to ensure the order is consistent
-	return structures.AIModelsResponse{Models: modelNames}, nil
+	models := model_data.GetModels() // ordered by name to ensure the order is consistent
+	modelNames := make([]string, 0, len(models))
+	for _, model := range models {
+		modelNames = append(modelNames, model.Name)
+	}
+	return structures.AIModelsResponse{Models: modelNames, Details: models}, nil
 
 }
 
-func (dataBase *Database) GetUpdatedSummary(existingSummary string, chat, modelName string) (string, float64, error) {
-	chatSummary, cost, err := dataBase.AIService.ApiSummary(existingSummary, chat, modelName)
+func (dataBase *Database) GetUpdatedSummary(existingSummary string, chat, modelName string) (*api_call.AIResponse, error) {
+	summary, err := dataBase.AIService.ApiSummary(existingSummary, chat, modelName)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to generate new summary: %w", err)
+		return nil, fmt.Errorf("failed to generate new summary: %w", err)
 	}
-	return chatSummary, cost, nil
+	return summary, nil
 }
 
 func (dataBase *Database) GetBalance(userId string) (float64, error) {
@@ -350,6 +432,13 @@ func (dataBase *Database) GetBalance(userId string) (float64, error) {
 
 	// Retrieve the models data from Redis
 	balance, err := dataBase.Cache.HGet(context.Background(), userKey, "balance").Result()
+	if err == redis.Nil {
+		// not cached, read it through from the database
+		if err := dataBase.loadUserIntoCache(context.Background(), userId); err != nil {
+			return 0, err
+		}
+		balance, err = dataBase.Cache.HGet(context.Background(), userKey, "balance").Result()
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -379,5 +468,11 @@ func (dataBase *Database) DeleteSessionFile(userId, sessionId string, fileName s
 		return fmt.Errorf("error while deleting file from database: %w", err)
 	}
 
+	// and its chunks from the document index
+	err = dataBase.DeleteDocument(context.Background(), sessionId, fileName)
+	if err != nil {
+		return fmt.Errorf("error while deleting document chunks: %w", err)
+	}
+
 	return nil
 }
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"ai-chat/utils/auth"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Local keeps the files in a directory of the server, so every replica needs the same directory mounted
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocal returns a storage keeping the files in dir, creating it when missing. Its URLs point below baseURL, where
+// the files are served from, and are checked by the server.
+func NewLocal(dir string, baseURL string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &Local{dir: dir, baseURL: baseURL}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || filepath.Base(key) != key {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(l.dir, key), nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	// the file is written next to its final name and renamed, so it is never read half written
+	file, err := os.CreateTemp(l.dir, "."+key+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	object, err := l.Stat(ctx, key)
+	if err != nil {
+		return nil, object, err
+	}
+
+	file, err := os.Open(filepath.Join(l.dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, object, ErrNotFound
+	}
+	return file, object, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (Object, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.IsDir()) {
+		return Object{}, ErrNotFound
+	} else if err != nil {
+		return Object{}, err
+	}
+
+	// the type isn't kept on disk, it follows from the extension
+	contentType := mime.TypeByExtension(filepath.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return Object{Key: key, Size: info.Size(), ContentType: contentType, LastModified: info.ModTime()}, nil
+}
+
+// PresignedURL returns the URL the file is served from, signed by the server with FILE_URL_SECRET_KEY
+func (l *Local) PresignedURL(ctx context.Context, key string, downloadName string, expiry time.Duration) (string, error) {
+	if _, err := l.path(key); err != nil {
+		return "", err
+	}
+	return auth.SignedFileURL(l.baseURL, key, downloadName, expiry)
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"ai-chat/utils/auth"
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalRoundTrip(t *testing.T) {
+	store, err := NewLocal(filepath.Join(t.TempDir(), "uploads"), "http://localhost/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRoundTrip(t, store)
+
+	// the temporary files of Put don't stay behind
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("storage directory holds %d entries after Delete()", len(entries))
+	}
+}
+
+func TestLocalInvalidKeys(t *testing.T) {
+	store, err := NewLocal(t.TempDir(), "http://localhost/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, key := range []string{"", ".", "..", "../secret.txt", "nested/file.txt"} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), 1, "text/plain"); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) error = %v, want ErrInvalidKey", key, err)
+		}
+		if _, _, err := store.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q) error = %v, want ErrInvalidKey", key, err)
+		}
+		if err := store.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q) error = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestLocalPresignedURL(t *testing.T) {
+	t.Setenv("FILE_URL_SECRET_KEY", "test-key")
+
+	store, err := NewLocal(t.TempDir(), "http://localhost/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	presigned, err := store.PresignedURL(context.Background(), "abc.pdf", "report.pdf", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(presigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Host != "localhost" || parsed.Path != "/uploads/abc.pdf" {
+		t.Errorf("PresignedURL() = %s", presigned)
+	}
+
+	query := parsed.Query()
+	if err := auth.VerifyFileURL("abc.pdf", query.Get("name"), query.Get("expires"), query.Get("signature")); err != nil {
+		t.Errorf("VerifyFileURL() error = %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"time"
+)
+
+// S3Config holds the connection details of an S3 compatible bucket
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+// S3 keeps the files in an S3 compatible bucket such as AWS S3 or MinIO, which all replicas share
+type S3 struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3 returns a storage keeping the files in the bucket of the config, creating the bucket when missing
+func NewS3(config S3Config) (*S3, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("S3_ENDPOINT and S3_BUCKET must be set for the s3 storage backend")
+	}
+
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check S3 bucket: %w", err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{Region: config.Region}); err != nil {
+			return nil, fmt.Errorf("failed to create S3 bucket: %w", err)
+		}
+	}
+	return &S3{client: client, bucket: config.Bucket}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, reader, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, Object{}, s3Error(err)
+	}
+
+	// the object is only requested once it is read or described
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, Object{}, s3Error(err)
+	}
+	return object, s3Object(info), nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	// S3 doesn't tell whether a removed object existed
+	if _, err := s.Stat(ctx, key); err != nil {
+		return err
+	}
+	return s3Error(s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}))
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Object, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return Object{}, s3Error(err)
+	}
+	return s3Object(info), nil
+}
+
+func (s *S3) PresignedURL(ctx context.Context, key string, downloadName string, expiry time.Duration) (string, error) {
+	// the type is only known once the object is described, the extension tells whether it is shown inline
+	params := url.Values{}
+	params.Set("response-content-disposition", ContentDisposition(mime.TypeByExtension(filepath.Ext(key)), downloadName))
+	presigned, err := s.client.PresignedGetObject(ctx, s.bucket, key, expiry, params)
+	if err != nil {
+		return "", s3Error(err)
+	}
+	return presigned.String(), nil
+}
+
+func s3Object(info minio.ObjectInfo) Object {
+	return Object{Key: info.Key, Size: info.Size, ContentType: info.ContentType, LastModified: info.LastModified}
+}
+
+// s3Error turns the errors of missing objects into ErrNotFound
+func s3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	response := minio.ToErrorResponse(err)
+	if response.Code == "NoSuchKey" || response.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return err
+}
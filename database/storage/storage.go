@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for a key which isn't stored
+	ErrNotFound = errors.New("file not found")
+	// ErrInvalidKey is returned for a key which isn't a plain file name
+	ErrInvalidKey = errors.New("invalid file key")
+)
+
+// Object describes a stored file
+type Object struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// Storage keeps the uploaded files. Keys are plain file names, such as the ones generated for uploads.
+type Storage interface {
+	// Put stores size bytes of reader under key, replacing the file stored there
+	Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error
+	// Get opens the file stored under key, the caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, Object, error)
+	// Delete removes the file stored under key, ErrNotFound when there is none
+	Delete(ctx context.Context, key string) error
+	// Stat describes the file stored under key
+	Stat(ctx context.Context, key string) (Object, error)
+	// PresignedURL returns a URL the file can be downloaded from for expiry without further credentials, saved as
+	// downloadName or the key when empty
+	PresignedURL(ctx context.Context, key string, downloadName string, expiry time.Duration) (string, error)
+}
+
+// ContentDisposition returns the Content-Disposition of a file of contentType downloaded as downloadName. Only
+// images and PDFs are shown inline; anything else, HTML and SVG in particular, is downloaded so it can't run
+// scripts on the server's origin.
+func ContentDisposition(contentType string, downloadName string) string {
+	disposition := "attachment"
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if (strings.HasPrefix(mediaType, "image/") && mediaType != "image/svg+xml") || mediaType == "application/pdf" {
+		disposition = "inline"
+	}
+	if downloadName == "" {
+		return disposition
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": downloadName})
+}
+
+// New returns the storage selected by STORAGE_BACKEND, local files in PUBLIC_DIR by default or an S3 compatible
+// bucket for s3
+func New() (Storage, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "local":
+		return NewLocal("./"+os.Getenv("PUBLIC_DIR"), fmt.Sprintf("http://%s/uploads", os.Getenv("SERVER_ADDRESS")))
+	case "s3":
+		return NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			UseSSL:    os.Getenv("S3_USE_SSL") == "true",
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"strings"
+	"testing"
+)
+
+// testRoundTrip stores, reads, replaces and deletes a file, then checks that missing files are reported as ErrNotFound
+func testRoundTrip(t *testing.T, store Storage) {
+	t.Helper()
+	ctx := context.Background()
+
+	put := func(content string) {
+		t.Helper()
+		if err := store.Put(ctx, "notes.txt", strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	get := func(want string) {
+		t.Helper()
+		reader, object, err := store.Get(ctx, "notes.txt")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		defer reader.Close()
+
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("reading the file: %v", err)
+		}
+		if string(content) != want {
+			t.Errorf("Get() content = %q, want %q", content, want)
+		}
+		if object.Key != "notes.txt" || object.Size != int64(len(want)) || object.LastModified.IsZero() {
+			t.Errorf("Get() object = %+v", object)
+		}
+		if mediaType, _, _ := mime.ParseMediaType(object.ContentType); mediaType != "text/plain" {
+			t.Errorf("Get() content type = %q, want text/plain", object.ContentType)
+		}
+	}
+
+	put("first version")
+	get("first version")
+
+	object, err := store.Stat(ctx, "notes.txt")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if object.Size != int64(len("first version")) {
+		t.Errorf("Stat() size = %d", object.Size)
+	}
+
+	put("second")
+	get("second")
+
+	if err := store.Delete(ctx, "notes.txt"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, _, err := store.Get(ctx, "notes.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+	if _, err := store.Stat(ctx, "notes.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() after Delete() error = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "notes.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete() error = %v, want ErrNotFound", err)
+	}
+	if _, _, err := store.Get(ctx, "missing.pdf"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a missing file error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		contentType  string
+		downloadName string
+		want         string
+	}{
+		{"image/png", "", "inline"},
+		{"application/pdf", "report.pdf", `inline; filename=report.pdf`},
+		{"image/svg+xml", "", "attachment"},
+		{"text/html; charset=utf-8", "page.html", `attachment; filename=page.html`},
+		{"", "notes 1.txt", `attachment; filename="notes 1.txt"`},
+	}
+	for _, test := range tests {
+		if got := ContentDisposition(test.contentType, test.downloadName); got != test.want {
+			t.Errorf("ContentDisposition(%q, %q) = %q, want %q", test.contentType, test.downloadName, got, test.want)
+		}
+	}
+}
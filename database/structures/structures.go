@@ -1,8 +1,10 @@
 package structures
 
 import (
+	"ai-chat/utils/model_data"
 	"encoding/json"
 	"log"
+	"time"
 )
 
 type ClientRequest struct {
@@ -25,28 +27,201 @@ type UserDataResponse struct {
 	Username string `json:"username" db:"username"`
 }
 
+// UserSessionsRequest asks for a page of the user's sessions, ordered by OrderBy (one of the SessionOrder
+// constants, latest activity by default) and limited to the sessions of ModelName and with Tag when they're set
 type UserSessionsRequest struct {
-	UserId string `json:"user_id"`
+	UserId    string `json:"user_id"`
+	OrderBy   string `json:"order_by"`
+	ModelName string `json:"model_name"`
+	// Pinned and Archived only list sessions with that state, archived sessions are left out unless Archived is set
+	Pinned   *bool  `json:"pinned"`
+	Archived *bool  `json:"archived"`
+	Tag      string `json:"tag"`
+	// Trashed lists the deleted sessions instead of the others
+	Trashed bool `json:"trashed"`
+	// Cursor is the next_cursor of the previous page, empty for the first page
+	Cursor string `json:"cursor"`
+	Limit  int    `json:"limit"`
+}
+
+const (
+	SessionOrderActivity = "activity"
+	SessionOrderCreated  = "created"
+	SessionOrderName     = "name"
+)
+
+// SessionListOptions selects and orders the sessions GetSessionsByUserId returns, ModelId 0 lists every model
+// and a nil Pinned or Archived every state, an empty Tag every tag. Trashed lists the trash instead of the other sessions.
+type SessionListOptions struct {
+	OrderBy  string
+	ModelId  int
+	Pinned   *bool
+	Archived *bool
+	Tag      string
+	Trashed  bool
+	Cursor   string
+	Limit    int
 }
 
 type SessionChatsRequest struct {
 	UserId    string `json:"user_id"`
 	SessionId string `json:"session_id"`
+	// LeafId switches to the branch holding this message, continuing with its latest replies
+	LeafId string `json:"leaf_id"`
+	// Cursor is the next_cursor of the previous page, empty for the latest chats
+	Cursor string `json:"cursor"`
+	Limit  int    `json:"limit"`
 }
 
+// SessionChatsResponse holds a page of the chats of the active branch, newest first. NextCursor asks for the
+// chats before them and is empty on the first chat of the session. Branches lists, for every message of the
+// page with alternatives, the ids of all messages sharing its parent in the order they were written.
 type SessionChatsResponse struct {
+	UserId       string              `json:"user_id"`
+	SessionId    string              `json:"session_id"`
+	Chats        []Chat              `json:"chats"`
+	NextCursor   string              `json:"next_cursor"`
+	ActiveLeafId string              `json:"active_leaf_id"`
+	Branches     map[string][]string `json:"branches,omitempty"`
+}
+
+// SessionUpdateRequest changes the settings of a session, fields which are left out keep their value
+type SessionUpdateRequest struct {
+	UserId      string    `json:"user_id"`
+	SessionId   string    `json:"session_id"`
+	SessionName *string   `json:"session_name"`
+	Pinned      *bool     `json:"pinned"`
+	Archived    *bool     `json:"archived"`
+	Tags        *[]string `json:"tags"`
+}
+
+type SessionUpdateResponse struct {
 	UserId    string `json:"user_id"`
 	SessionId string `json:"session_id"`
-	Chats     string `json:"chat"`
+	SessionDetails
 }
 
+// SessionDeleteRequest moves sessions to the trash, SessionIds deletes several sessions at once
 type SessionDeleteRequest struct {
-	UserId    string `json:"user_id"`
-	SessionId string `json:"session_id"`
+	UserId     string   `json:"user_id"`
+	SessionId  string   `json:"session_id"`
+	SessionIds []string `json:"session_ids"`
+}
+
+// Ids returns SessionId together with SessionIds
+func (m *SessionDeleteRequest) Ids() []string {
+	if m.SessionId == "" {
+		return m.SessionIds
+	}
+	return append([]string{m.SessionId}, m.SessionIds...)
 }
 
+// SessionDeleteResponse lists the sessions moved to the trash, they are purged at PurgeAt unless restored before
 type SessionDeleteResponse struct {
+	UserId     string    `json:"user_id"`
+	SessionIds []string  `json:"session_ids"`
+	PurgeAt    time.Time `json:"purge_at"`
+}
+
+type SessionRestoreRequest struct {
+	UserId     string   `json:"user_id"`
+	SessionIds []string `json:"session_ids"`
+}
+
+// SessionPurgeRequest permanently deletes sessions from the trash, no SessionIds empties the whole trash
+type SessionPurgeRequest struct {
+	UserId     string   `json:"user_id"`
+	SessionIds []string `json:"session_ids"`
+}
+
+// SessionTrashResponse lists the sessions which were restored or purged
+type SessionTrashResponse struct {
+	UserId     string   `json:"user_id"`
+	SessionIds []string `json:"session_ids"`
+}
+
+// SearchRequest searches the content of the messages and the names of all the user's sessions, Query takes the
+// web search syntax ("quoted phrases", or, -excluded words)
+type SearchRequest struct {
+	UserId string `json:"user_id"`
+	Query  string `json:"query"`
+	// Cursor is the next_cursor of the previous page, empty for the best hits
+	Cursor string `json:"cursor"`
+	Limit  int    `json:"limit"`
+}
+
+// SearchHit is a message or, when MessageId is empty, a session name matching the query. Snippet is the matching
+// text, HTML escaped, with the matched words wrapped in <mark></mark>. Position counts the messages of the session
+// in the order they were written, starting at 1, and is 0 for session names.
+type SearchHit struct {
+	SessionId   string    `json:"session_id"`
+	SessionName string    `json:"session_name"`
+	MessageId   string    `json:"message_id,omitempty"`
+	Role        string    `json:"role,omitempty"`
+	Position    int       `json:"position"`
+	Snippet     string    `json:"snippet"`
+	Rank        float64   `json:"rank"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SearchResponse holds a page of hits, best first, NextCursor asks for the next one and is empty on the last page
+type SearchResponse struct {
+	UserId     string      `json:"user_id"`
+	Query      string      `json:"query"`
+	Hits       []SearchHit `json:"hits"`
+	NextCursor string      `json:"next_cursor"`
+}
+
+// SemanticSearchRequest looks for the exchanges of the user's sessions closest in meaning to Query
+type SemanticSearchRequest struct {
 	UserId string `json:"user_id"`
+	Query  string `json:"query"`
+	Limit  int    `json:"limit"`
+}
+
+// SemanticSearchHit is an exchange of a session, MessageId is the reply which ends it. Score is the cosine similarity
+// of the exchange and the query, 1 for the same meaning.
+type SemanticSearchHit struct {
+	SessionId   string    `json:"session_id"`
+	SessionName string    `json:"session_name"`
+	MessageId   string    `json:"message_id"`
+	Content     string    `json:"content"`
+	Score       float64   `json:"score"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SemanticSearchResponse holds the closest exchanges, best first
+type SemanticSearchResponse struct {
+	UserId string              `json:"user_id"`
+	Query  string              `json:"query"`
+	Hits   []SemanticSearchHit `json:"hits"`
+}
+
+// DocumentStatusRequest asks for the ingestion status of the files of a session
+type DocumentStatusRequest struct {
+	UserId    string `json:"user_id"`
+	SessionId string `json:"session_id"`
+}
+
+// DocumentStatusResponse lists the ingestion status of the files of a session. It is also sent unrequested
+// whenever the status of a file changes, with that file only.
+type DocumentStatusResponse struct {
+	UserId    string         `json:"user_id"`
+	SessionId string         `json:"session_id"`
+	Documents []DocumentInfo `json:"documents"`
+}
+
+// ListFilesRequest asks for the files uploaded to a session
+type ListFilesRequest struct {
+	UserId    string `json:"user_id"`
+	SessionId string `json:"session_id"`
+}
+
+// ListFilesResponse lists the files of a session in the order they were uploaded
+type ListFilesResponse struct {
+	UserId    string         `json:"user_id"`
+	SessionId string         `json:"session_id"`
+	Files     []FileMetadata `json:"files"`
 }
 
 type AIModelsRequest struct {
@@ -54,7 +229,8 @@ type AIModelsRequest struct {
 }
 
 type AIModelsResponse struct {
-	Models []string `json:"models"`
+	Models  []string           `json:"models"`
+	Details []model_data.Model `json:"details"`
 }
 
 type GetBalanceRequest struct {
@@ -72,18 +248,71 @@ type UserMessageRequest struct {
 	Message   string `json:"message" db:"message"`
 	Prompt    string `json:"session_prompt" db:"session_prompt"`
 	FileName  string `json:"file_name" db:"file_name"`
+	Stream    bool   `json:"stream" db:"stream"`
+	// Recall adds this many exchanges of the user's other sessions which relate to the message to the context
+	Recall int `json:"recall" db:"recall"`
 }
 
 type UserMessageResponse struct {
-	UserId      string `json:"user_id" db:"user_id"`
-	SessionId   string `json:"session_id" db:"session_id"`
-	SessionName string `json:"session_name" db:"session_name"`
-	Message     string `json:"message" db:"message"`
+	UserId        string `json:"user_id" db:"user_id"`
+	SessionId     string `json:"session_id" db:"session_id"`
+	SessionName   string `json:"session_name" db:"session_name"`
+	Message       string `json:"message" db:"message"`
+	MessageId     string `json:"message_id" db:"message_id"`
+	UserMessageId string `json:"user_message_id" db:"user_message_id"`
+	// Sources are the excerpts of the session's files the reply was given, the model cites them by their number
+	Sources []DocumentSource `json:"sources,omitempty" db:"sources"`
+}
+
+// RegenerateRequest asks for a new reply to the last user message of the active branch
+type RegenerateRequest struct {
+	UserId    string `json:"user_id"`
+	SessionId string `json:"session_id"`
+	Stream    bool   `json:"stream"`
+}
+
+// EditMessageRequest replaces a user message with a new one in a new branch and answers it
+type EditMessageRequest struct {
+	UserId    string `json:"user_id"`
+	SessionId string `json:"session_id"`
+	MessageId string `json:"message_id"`
+	Message   string `json:"message"`
+	Stream    bool   `json:"stream"`
+}
+
+// BranchRequest makes a message the end of the active branch, the next chat message continues from it
+type BranchRequest struct {
+	UserId    string `json:"user_id"`
+	SessionId string `json:"session_id"`
+	MessageId string `json:"message_id"`
+}
+
+type ChatChunkResponse struct {
+	UserId    string `json:"user_id"`
+	SessionId string `json:"session_id"`
+	Chunk     string `json:"chunk"`
 }
 
+type ChatErrorResponse struct {
+	UserId    string `json:"user_id"`
+	SessionId string `json:"session_id"`
+	Error     string `json:"error"`
+}
+
+// Chat is one message of a session. Messages form a tree through their parent ids, so edits and
+// regenerated replies become branches next to the original. Replies carry the model, tokens and cost
+// they were generated with, user messages the names of the files uploaded with them.
 type Chat struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Id           string    `json:"id,omitempty"`
+	ParentId     string    `json:"parent_id,omitempty"`
+	Role         string    `json:"role"`
+	Content      string    `json:"content"`
+	ModelId      int       `json:"model_id,omitempty"`
+	InputTokens  int       `json:"input_tokens,omitempty"`
+	OutputTokens int       `json:"output_tokens,omitempty"`
+	Cost         float64   `json:"cost,omitempty"`
+	Attachments  []string  `json:"attachments,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
 }
 
 type Vector struct {
@@ -98,6 +327,147 @@ type SessionData struct {
 	ChatSummary string   `json:"chat_summary" db:"chat_summary"`
 	FileName    []string `json:"file_name" db:"file_name"`
 	Chats       []Chat   `json:"chats" db:"chats"`
+	// ActiveLeafId is the last message of the branch the session continues on
+	ActiveLeafId string `json:"active_leaf_id" db:"active_leaf_id"`
+	// SummaryLeafId is the last message ChatSummary covers, the chats after it are not summarized yet
+	SummaryLeafId string `json:"summary_leaf_id" db:"summary_leaf_id"`
+	// LastMessageAt is the time of the latest chat turn, zero before the first one
+	LastMessageAt time.Time `json:"last_message_at" db:"last_message_at"`
+	Pinned        bool      `json:"pinned" db:"pinned"`
+	Archived      bool      `json:"archived" db:"archived"`
+	Tags          []string  `json:"tags" db:"tags"`
+}
+
+// Details returns the settings of the session its user manages
+func (m *SessionData) Details() SessionDetails {
+	return SessionDetails{
+		SessionName: m.SessionName,
+		Pinned:      m.Pinned,
+		Archived:    m.Archived,
+		Tags:        m.Tags,
+	}
+}
+
+// SessionDetails are the settings of a session its user manages
+type SessionDetails struct {
+	SessionName string   `json:"session_name"`
+	Pinned      bool     `json:"pinned"`
+	Archived    bool     `json:"archived"`
+	Tags        []string `json:"tags"`
+}
+
+// SummaryJob asks for the chats of a session which are not summarized yet to be added to its summary
+type SummaryJob struct {
+	UserId    string `json:"user_id"`
+	SessionId string `json:"session_id"`
+}
+
+// EmbeddingJob asks for an exchange of a session to be embedded, MessageId is the reply which ends it
+type EmbeddingJob struct {
+	UserId    string    `json:"user_id"`
+	SessionId string    `json:"session_id"`
+	MessageId string    `json:"message_id"`
+	Message   string    `json:"message"`
+	Reply     string    `json:"reply"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	FileStatusUploading = "uploading"
+	FileStatusStored    = "stored"
+	FileStatusFailed    = "failed"
+)
+
+// FileMetadata is a File_Metadata row. FileName is the generated name the file is stored under, OriginalName the
+// one it was uploaded with. The status is one of the FileStatus constants, only stored files belong to the session.
+type FileMetadata struct {
+	FileName     string    `json:"file_name" db:"file_name"`
+	SessionId    string    `json:"session_id" db:"session_id"`
+	OriginalName string    `json:"original_name" db:"original_name"`
+	MimeType     string    `json:"mime_type" db:"mime_type"`
+	Size         int64     `json:"size" db:"size"`
+	UploadedBy   string    `json:"uploaded_by" db:"uploaded_by"`
+	ContentHash  string    `json:"content_hash" db:"content_hash"`
+	Status       string    `json:"status" db:"status"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// IngestionJob asks for an uploaded file to be split into chunks which are embedded for retrieval. FileName is
+// the stored file, SourceName the name it was uploaded with.
+type IngestionJob struct {
+	UserId     string `json:"user_id"`
+	SessionId  string `json:"session_id"`
+	FileName   string `json:"file_name"`
+	SourceName string `json:"source_name"`
+}
+
+const (
+	DocumentStatusPending = "pending"
+	DocumentStatusReady   = "ready"
+	DocumentStatusFailed  = "failed"
+)
+
+// DocumentInfo is the ingestion status of an uploaded file, one of the DocumentStatus constants. Error tells why
+// a failed file couldn't be ingested.
+type DocumentInfo struct {
+	FileName   string    `json:"file_name" db:"file_name"`
+	SourceName string    `json:"source_name" db:"source_name"`
+	Status     string    `json:"status" db:"status"`
+	Chunks     int       `json:"chunks" db:"chunks"`
+	Error      string    `json:"error,omitempty" db:"error"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// DocumentSource is a chunk of a file of the session given to the model along with a message. Page is 0 for
+// files without pages.
+type DocumentSource struct {
+	Number     int     `json:"number"`
+	FileName   string  `json:"file_name"`
+	SourceName string  `json:"source_name"`
+	Page       int     `json:"page,omitempty"`
+	Chunk      int     `json:"chunk"`
+	Content    string  `json:"content"`
+	Score      float64 `json:"score"`
+}
+
+// UserRecord is a User_Data row as stored in Postgres
+type UserRecord struct {
+	UserId   string
+	UserName string
+	Models   string
+	Balance  float64
+	Tier     string
+}
+
+// SessionRecord is a session as stored in Postgres together with its owner
+type SessionRecord struct {
+	UserId string
+	SessionData
+	// DeletedAt is set while the session is in the trash
+	DeletedAt *time.Time
+}
+
+// Reasons recorded in Credit_Ledger
+const (
+	LedgerReasonChat           = "chat"
+	LedgerReasonSummary        = "summary"
+	LedgerReasonTitle          = "title"
+	LedgerReasonTopUp          = "top_up"
+	LedgerReasonAdjustment     = "adjustment"
+	LedgerReasonOpeningBalance = "opening_balance"
+)
+
+// LedgerEntry is one Credit_Ledger row, debits have a negative amount.
+// The entry id is generated when the balance changes in Redis, so replaying it is a no-op.
+type LedgerEntry struct {
+	EntryId      string  `json:"entry_id" db:"entry_id"`
+	UserId       string  `json:"user_id" db:"user_id"`
+	Amount       float64 `json:"amount" db:"amount"`
+	Reason       string  `json:"reason" db:"reason"`
+	ModelId      *int    `json:"model_id,omitempty" db:"model_id"`
+	SessionId    *string `json:"session_id,omitempty" db:"session_id"`
+	InputTokens  int     `json:"input_tokens" db:"input_tokens"`
+	OutputTokens int     `json:"output_tokens" db:"output_tokens"`
 }
 
 type FormData struct {
@@ -108,13 +478,27 @@ type FormData struct {
 }
 
 type SessionInfo struct {
-	SessionId   string `json:"session_id"`
-	SessionName string `json:"session_name"`
-}
-
+	SessionId     string     `json:"session_id"`
+	SessionName   string     `json:"session_name"`
+	ModelName     string     `json:"model_name"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	LastMessageAt *time.Time `json:"last_message_at"`
+	MessageCount  int        `json:"message_count"`
+	TotalCost     float64    `json:"total_cost"`
+	Pinned        bool       `json:"pinned"`
+	Archived      bool       `json:"archived"`
+	Tags          []string   `json:"tags"`
+	// DeletedAt and PurgeAt are only set on sessions in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty"`
+}
+
+// UserSessionResponse holds a page of sessions, NextCursor asks for the next one and is empty on the last page
 type UserSessionResponse struct {
-	UserId  string        `json:"user_id"`
-	Session []SessionInfo `json:"session_info"`
+	UserId     string        `json:"user_id"`
+	Session    []SessionInfo `json:"session_info"`
+	NextCursor string        `json:"next_cursor"`
 }
 
 func (m *AIModelsRequest) Unmarshal(data []byte) {
@@ -165,6 +549,93 @@ func (m *SessionDeleteResponse) Marshal() ([]byte, error) {
 	return data, err
 }
 
+func (m *SessionRestoreRequest) Unmarshal(data []byte) {
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (m *SessionPurgeRequest) Unmarshal(data []byte) {
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (m *SessionTrashResponse) Marshal() ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return data, err
+}
+
+func (m *SearchRequest) Unmarshal(data []byte) {
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (m *SearchResponse) Marshal() ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return data, err
+}
+
+func (m *SemanticSearchRequest) Unmarshal(data []byte) {
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (m *SemanticSearchResponse) Marshal() ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return data, err
+}
+
+func (m *DocumentStatusRequest) Unmarshal(data []byte) {
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (m *DocumentStatusResponse) Marshal() ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return data, err
+}
+
+func (m *ListFilesRequest) Unmarshal(data []byte) {
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (m *ListFilesResponse) Marshal() ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return data, err
+}
+
 func (m *ClientResponse) Marshal() ([]byte, error) {
 	data, err := json.Marshal(m)
 	if err != nil {
@@ -190,6 +661,24 @@ func (m *UserMessageResponse) Marshal() ([]byte, error) {
 	return data, err
 }
 
+func (m *ChatChunkResponse) Marshal() ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return data, err
+}
+
+func (m *ChatErrorResponse) Marshal() ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return data, err
+}
+
 func (m *SessionChatsResponse) Unmarshal(data []byte) {
 	err := json.Unmarshal(data, &m)
 	if err != nil {
@@ -301,3 +790,40 @@ func (m *UserMessageRequest) Unmarshal(data []byte) {
 		log.Println(err)
 	}
 }
+
+func (m *RegenerateRequest) Unmarshal(data []byte) {
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (m *EditMessageRequest) Unmarshal(data []byte) {
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (m *BranchRequest) Unmarshal(data []byte) {
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (m *SessionUpdateRequest) Unmarshal(data []byte) {
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (m *SessionUpdateResponse) Marshal() ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return data, err
+}
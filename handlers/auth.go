@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"ai-chat/utils/auth"
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"log"
+)
+
+// authenticatedUserKey is the locals key the authenticated user id is stored under
+const authenticatedUserKey = "authenticated_user_id"
+
+// Authenticate validates the signed token sent with the request and binds the authenticated user id to it.
+// Browsers can't set headers on a WebSocket upgrade, so the token is also accepted as the "token" query parameter.
+func Authenticate() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := auth.ExtractToken(c.Get(fiber.HeaderAuthorization))
+		if token == "" {
+			token = c.Query("token")
+		}
+
+		userId, err := auth.ValidateToken(token)
+		if err != nil {
+			log.Println("authentication error --> ", err)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"message": "Unauthorized",
+				"data":    nil,
+			})
+		}
+
+		c.Locals(authenticatedUserKey, userId)
+		return c.Next()
+	}
+}
+
+// isRequestAuthorized reports whether the user_id carried by a WebSocket request matches the authenticated user
+func isRequestAuthorized(data []byte, authenticatedUserId string) bool {
+	var request struct {
+		UserId string `json:"user_id"`
+	}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return false
+	}
+	return request.UserId != "" && request.UserId == authenticatedUserId
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"ai-chat/database/services"
+	"ai-chat/database/storage"
+	"ai-chat/utils/auth"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"log"
+	"net/http"
+)
+
+// FileServeHandler sets up the route serving the uploaded files from the storage backend, the file is named by
+// the last segment of the path. A file is served for a signed URL, see auth.SignedFileURL, or to the authenticated
+// user whose session it belongs to.
+func FileServeHandler(url string, app *fiber.App, database *services.Database) {
+	app.Get(url+"/:fileName", func(ctx *fiber.Ctx) error {
+		return serveFile(ctx, database)
+	})
+}
+
+func serveFile(c *fiber.Ctx, database *services.Database) error {
+	fileName := c.Params("fileName")
+
+	var downloadName string
+	if signature := c.Query("signature"); signature != "" {
+		downloadName = c.Query("name")
+		if err := auth.VerifyFileURL(fileName, downloadName, c.Query("expires"), signature); err != nil {
+			log.Println("file url error --> ", err)
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Invalid or expired file url",
+				"data":    nil,
+			})
+		}
+	} else {
+		token := auth.ExtractToken(c.Get(fiber.HeaderAuthorization))
+		if token == "" {
+			token = c.Query("token")
+		}
+		userId, err := auth.ValidateToken(token)
+		if err != nil {
+			log.Println("authentication error --> ", err)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"message": "Unauthorized",
+				"data":    nil,
+			})
+		}
+
+		// files of other users are answered as missing so their names can't be probed
+		metadata, err := database.GetFileMetadata(c.Context(), userId, fileName)
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"message": "File not found",
+				"data":    nil,
+			})
+		} else if err != nil {
+			fmt.Println("Unable to check file owner: ", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Server error",
+				"data":    nil,
+			})
+		}
+		downloadName = metadata.OriginalName
+	}
+
+	file, object, err := database.Files.Get(c.Context(), fileName)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "File not found",
+			"data":    nil,
+		})
+	} else if err != nil {
+		fmt.Println("Unable to read file: ", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Server error",
+			"data":    nil,
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, object.ContentType)
+	c.Set(fiber.HeaderContentDisposition, storage.ContentDisposition(object.ContentType, downloadName))
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderCacheControl, "private")
+	c.Set(fiber.HeaderLastModified, object.LastModified.UTC().Format(http.TimeFormat))
+	// the stream is closed by fiber once it is sent
+	return c.SendStream(file, int(object.Size))
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"ai-chat/database/services"
 	"ai-chat/database/structures"
+	"ai-chat/utils/documents"
 	"ai-chat/utils/helper_functions"
 	"ai-chat/utils/model_data"
 	"ai-chat/utils/response_code/error_code"
@@ -22,7 +23,7 @@ const convertTOMB = 1024 * 1024 // 10 MB
 
 // WebsocketHandler sets up the WebSocket route
 func FileUploadHandler(url string, app *fiber.App, database *services.Database) {
-	app.Post(url, func(ctx *fiber.Ctx) error {
+	app.Post(url, Authenticate(), func(ctx *fiber.Ctx) error {
 		return fileUpload(ctx, database)
 	})
 }
@@ -34,17 +35,32 @@ func generateUniqueFileName(originalFileName string) string {
 }
 
 func fileUpload(c *fiber.Ctx, database *services.Database) error {
-	fmt.Println("File Upload")
-
 	formData, err := validateAndExtractFormData(c)
 	if err != nil {
-		fmt.Println("File Upload Error", formData, err)
+		log.Println("upload form error --> ", err)
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"message": err.Error(),
 			"data":    nil,
 		})
 	}
 
+	if formData.UserId != c.Locals(authenticatedUserKey) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "user ID does not match the authenticated user",
+			"data":    nil,
+		})
+	}
+
+	// uploads don't call a model, so only the upload limit applies
+	if retryAfter := checkRateLimit(database, formData.UserId, services.RateLimitActionUpload, ""); retryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"message":     string(error_code.Message(error_code.ErrorCodeRateLimited)),
+			"retry_after": retryAfter,
+			"data":        nil,
+		})
+	}
+
 	// parse incoming image file
 	file, err := c.FormFile("file")
 	if err != nil {
@@ -67,8 +83,8 @@ func fileUpload(c *fiber.Ctx, database *services.Database) error {
 	log.Printf("File Upload: UserID: %s, SessionID: %s, Model Name: %s, Prompt: %s\n",
 		formData.UserId, formData.SessionId, formData.ModelName, formData.Prompt)
 
-	var allSessionFiles []string
-	if formData.SessionId == "NEW" {
+	isNew := formData.SessionId == "NEW"
+	if isNew {
 		var err error
 		formData.SessionId, err = fileUploadForNewSession(database, formData.UserId, formData.ModelName, formData.Prompt, fileName)
 		if err != nil {
@@ -77,50 +93,74 @@ func fileUpload(c *fiber.Ctx, database *services.Database) error {
 				"data":    nil,
 			})
 		}
-	} else {
-		sessionData, err := database.GetUserSessionData(formData.UserId, formData.SessionId)
-		if err != nil {
-			return err
-		}
+	} else if _, err := database.GetUserSessionData(formData.UserId, formData.SessionId); errors.Is(err, services.ErrSessionNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "session not found",
+			"data":    nil,
+		})
+	} else if errors.Is(err, services.ErrSessionForbidden) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "session does not belong to the authenticated user",
+			"data":    nil,
+		})
+	} else if err != nil {
+		log.Println("session error --> ", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Server error",
+			"data":    nil,
+		})
+	}
 
-		err = database.AddNewFileInSessionData(formData.UserId, formData.SessionId, fileName)
-		if err != nil {
+	metadata, err := database.SaveFile(c.Context(), formData.UserId, formData.SessionId, fileName, file)
+	if err != nil {
+		log.Println("save error --> ", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Server error",
+			"data":    nil,
+		})
+	}
+
+	// a new session is created with the file, others get it once it is stored
+	if !isNew {
+		if err := database.AddNewFileInSessionData(formData.UserId, formData.SessionId, metadata); err != nil {
 			log.Println("cache save error --> ", err)
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"message": "Server error",
 				"data":    nil,
 			})
 		}
-
-		allSessionFiles = sessionData.FileName
 	}
 
-	isNew := "OLD"
-	if len(allSessionFiles) == 0 {
-		isNew = "NEW"
+	// documents are split and embedded in the background, the status is sent over the WebSocket as it changes
+	ingestion := ""
+	if documents.Supported(file.Filename) {
+		info, err := database.EnqueueIngestion(context.Background(), structures.IngestionJob{
+			UserId:     formData.UserId,
+			SessionId:  formData.SessionId,
+			FileName:   fileName,
+			SourceName: metadata.OriginalName,
+		})
+		if err != nil {
+			log.Println("ingestion error --> ", err)
+		} else {
+			ingestion = info.Status
+		}
 	}
 
-	fmt.Println("session_id: ", formData.SessionId)
-	err = database.SaveFile(c, formData.SessionId, fileName, isNew, file)
+	// generate the signed url the client downloads the file from, it expires after STORAGE_URL_EXPIRY_MINUTES
+	fileUrl, err := database.Files.PresignedURL(c.Context(), fileName, metadata.OriginalName, services.FileURLExpiry())
 	if err != nil {
-		log.Println("save error --> ", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"message": "Server error",
-			"data":    nil,
-		})
+		log.Println("file url error --> ", err)
 	}
-	fmt.Println("File Saved ..!!")
-
-	// generate image url to serve to client using CDN
-	fileUrl := fmt.Sprintf("http://%s/%s/%s", os.Getenv("SERVER_ADDRESS"), os.Getenv("PUBLIC_DIR"), fileName)
 
 	// create metadata and send to client
 	data := map[string]interface{}{
 		"fileName":  fileName,
 		"sessionId": formData.SessionId,
 		"imageUrl":  fileUrl,
-		"header":    file.Header,
-		"size":      file.Size,
+		"size":      metadata.Size,
+		"file":      metadata,
+		"ingestion": ingestion,
 	}
 
 	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
@@ -130,11 +170,14 @@ func fileUpload(c *fiber.Ctx, database *services.Database) error {
 }
 
 func fileUploadForNewSession(database *services.Database, userId string, modelName string, sessionPrompt, fileName string) (string, error) {
-	modelIdInt := model_data.ModelNumber(modelName)
+	modelIdInt, err := model_data.ModelNumber(modelName)
+	if err != nil {
+		return "", errors.New(string(error_code.Error(error_code.ErrorCodeUnknownModel)))
+	}
 
 	sessionData := structures.SessionData{
 		ModelId:     modelIdInt,
-		SessionName: helper_functions.TruncateText("New Chat", 20),
+		SessionName: helper_functions.DefaultSessionName,
 		Prompt:      sessionPrompt,
 		ChatSummary: "",
 		FileName:    []string{fileName},
@@ -151,7 +194,7 @@ func fileUploadForNewSession(database *services.Database, userId string, modelNa
 		return "", errors.New(string(error_code.Error(error_code.ErrorCodeUnableToCreateSession)))
 	}
 
-	err = database.AddChat(context.Background(), sessionId, sessionPrompt, "[]", sessionData.ChatSummary)
+	err = database.AddChat(context.Background(), sessionId, sessionPrompt, "[]", sessionData.ChatSummary, "")
 	if err != nil {
 		return "", errors.New(string(error_code.Error(error_code.ErrorCodeUnableToCreateSession)))
 	}
@@ -176,6 +219,9 @@ func validateAndExtractFormData(c *fiber.Ctx) (*structures.FormData, error) {
 	if formData.ModelName == "" {
 		return nil, errors.New("model Name is required")
 	}
+	if _, err := model_data.ModelNumber(formData.ModelName); err != nil {
+		return nil, fmt.Errorf("model %s is not available: %w", formData.ModelName, err)
+	}
 
 	return formData, nil
 }
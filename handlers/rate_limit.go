@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"ai-chat/database/services"
+	"ai-chat/utils/model_data"
+	"context"
+	"log"
+	"math"
+)
+
+// checkRateLimit returns how many seconds the user has to wait before the action is allowed, 0 if it may go ahead.
+// Requests are let through when the limiter itself fails, so a Redis hiccup doesn't block every user.
+func checkRateLimit(database *services.Database, userId string, action string, modelName string) int {
+	wait, err := database.CheckRateLimit(context.Background(), userId, action, modelName)
+	if err != nil {
+		log.Println("rate limit error:", err)
+		return 0
+	}
+	return int(math.Ceil(wait.Seconds()))
+}
+
+// sessionModelName is the model of the session for the per model limits, empty if the session can't be loaded
+func sessionModelName(database *services.Database, userId string, sessionId string) string {
+	sessionData, err := database.GetUserSessionData(userId, sessionId)
+	if err != nil {
+		return ""
+	}
+	return model_data.ModelName(sessionData.ModelId)
+}
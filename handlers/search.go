@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"ai-chat/database/services"
+	"ai-chat/database/structures"
+	"ai-chat/utils/response_code/error_code"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SearchHandler sets up the route searching the authenticated user's chats, it takes the query as q along with
+// the cursor and limit of the WebSocket search
+func SearchHandler(url string, app *fiber.App, database *services.Database) {
+	app.Get(url, Authenticate(), func(ctx *fiber.Ctx) error {
+		return search(ctx, database)
+	})
+}
+
+func search(c *fiber.Ctx, database *services.Database) error {
+	// only the authenticated user is searched, whatever user the client asks for
+	userId, _ := c.Locals(authenticatedUserKey).(string)
+	request := structures.SearchRequest{
+		UserId: userId,
+		Query:  c.Query("q"),
+		Cursor: c.Query("cursor"),
+		Limit:  c.QueryInt("limit"),
+	}
+
+	data, err := database.SearchChats(userId, request)
+	if errors.Is(err, services.ErrInvalidSearchQuery) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": string(error_code.Message(error_code.ErrorCodeInvalidSearchQuery)),
+			"data":    nil,
+		})
+	} else if errors.Is(err, services.ErrInvalidCursor) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": string(error_code.Message(error_code.ErrorCodeInvalidCursor)),
+			"data":    nil,
+		})
+	} else if err != nil {
+		fmt.Println("Unable to search chats: ", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": string(error_code.Message(error_code.ErrorCodeUnableToSearch)),
+			"data":    nil,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Search completed successfully",
+		"data":    data,
+	})
+}
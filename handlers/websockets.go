@@ -6,7 +6,9 @@ import (
 	"ai-chat/messaging_service"
 	"ai-chat/utils/response_code/error_code"
 	"ai-chat/utils/response_code/messages"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
@@ -15,23 +17,30 @@ import (
 
 // WebsocketHandler sets up the WebSocket route
 func WebsocketHandler(url string, app *fiber.App, database *services.Database) {
-	app.Use(url, websocket.New(func(c *websocket.Conn) {
-		fmt.Println("New WebSocket Connection")
+	app.Use(url, Authenticate(), websocket.New(func(c *websocket.Conn) {
 		defer c.Close() // Ensure the connection is closed after return1
 
-		NewConnection(c, database)
+		userId, _ := c.Locals(authenticatedUserKey).(string)
+		conn := messaging_service.NewConnection(c)
+
+		// events of background jobs are forwarded while the connection is open
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go messaging_service.ForwardUserEvents(ctx, database, userId, conn)
+
+		NewConnection(conn, database, userId)
 	}))
 }
 
 // Send error message over WebSocket connection
-func sendErrorOverWebSocket(c *websocket.Conn, errMsg string) {
+func sendErrorOverWebSocket(c *messaging_service.Connection, errMsg string) {
 	if err := c.WriteMessage(websocket.TextMessage, []byte(errMsg)); err != nil {
 		log.Printf("Failed to send error message over WebSocket: %v", err)
 	}
 }
 
-// NewConnection handles incoming messages and sends responses
-func NewConnection(conn *websocket.Conn, database *services.Database) {
+// NewConnection handles incoming messages and sends responses; every request must belong to the authenticated user
+func NewConnection(conn *messaging_service.Connection, database *services.Database, userId string) {
 	msg := &structures.ClientRequest{}
 	for {
 		messageType, data, err := conn.ReadMessage()
@@ -46,6 +55,12 @@ func NewConnection(conn *websocket.Conn, database *services.Database) {
 			continue
 		}
 
+		if !isRequestAuthorized(msg.Data, userId) {
+			log.Println("unauthorized request for user:", userId)
+			sendErrorOverWebSocket(conn, string(error_code.Error(error_code.ErrorCodeUnauthorized)))
+			continue
+		}
+
 		switch msg.MessageType {
 		// Define your cases here as in your original handler
 		case messages.MessageCodeUserDetails:
@@ -63,18 +78,70 @@ func NewConnection(conn *websocket.Conn, database *services.Database) {
 		case messages.MessageCodeChatMessage:
 			var dataReceived structures.UserMessageRequest
 			dataReceived.Unmarshal(msg.Data)
+			// a limited message is sent again later with its file, which must be kept
+			if retryAfter := checkRateLimit(database, userId, services.RateLimitActionChat, dataReceived.ModelName); retryAfter > 0 {
+				sendErrorOverWebSocket(conn, string(error_code.ErrorWithRetryAfter(error_code.ErrorCodeRateLimited, retryAfter)))
+				continue
+			}
 			err = messaging_service.GetChatResponse(database, &dataReceived, messageType, conn)
 			if dataReceived.FileName != "" && err != nil {
-				fmt.Println("Here is the file name:", dataReceived.FileName)
 				err1 := database.DeleteSessionFile(dataReceived.UserId, dataReceived.SessionId, dataReceived.FileName)
 				if err1 != nil {
 					err = fmt.Errorf("while processing two error occured : %v and %v", err.Error(), err1)
 				}
 			}
+		case messages.MessageCodeChatRegenerate:
+			var dataReceived structures.RegenerateRequest
+			dataReceived.Unmarshal(msg.Data)
+			if retryAfter := checkRateLimit(database, userId, services.RateLimitActionChat, sessionModelName(database, userId, dataReceived.SessionId)); retryAfter > 0 {
+				err = errors.New(string(error_code.ErrorWithRetryAfter(error_code.ErrorCodeRateLimited, retryAfter)))
+			} else {
+				err = messaging_service.RegenerateChat(database, &dataReceived, messageType, conn)
+			}
+		case messages.MessageCodeChatEdit:
+			var dataReceived structures.EditMessageRequest
+			dataReceived.Unmarshal(msg.Data)
+			if retryAfter := checkRateLimit(database, userId, services.RateLimitActionChat, sessionModelName(database, userId, dataReceived.SessionId)); retryAfter > 0 {
+				err = errors.New(string(error_code.ErrorWithRetryAfter(error_code.ErrorCodeRateLimited, retryAfter)))
+			} else {
+				err = messaging_service.EditChat(database, &dataReceived, messageType, conn)
+			}
+		case messages.MessageCodeChatBranch:
+			var dataReceived structures.BranchRequest
+			dataReceived.Unmarshal(msg.Data)
+			err = messaging_service.BranchChat(database, &dataReceived, messageType, conn)
+		case messages.MessageCodeSessionUpdate:
+			var dataReceived structures.SessionUpdateRequest
+			dataReceived.Unmarshal(msg.Data)
+			err = messaging_service.UpdateSession(database, &dataReceived, messageType, conn)
 		case messages.MessageCodeSessionDelete:
 			var dataReceived structures.SessionDeleteRequest
 			dataReceived.Unmarshal(msg.Data)
 			err = messaging_service.DeleteSession(database, &dataReceived, messageType, conn)
+		case messages.MessageCodeSessionRestore:
+			var dataReceived structures.SessionRestoreRequest
+			dataReceived.Unmarshal(msg.Data)
+			err = messaging_service.RestoreSession(database, &dataReceived, messageType, conn)
+		case messages.MessageCodeSessionPurge:
+			var dataReceived structures.SessionPurgeRequest
+			dataReceived.Unmarshal(msg.Data)
+			err = messaging_service.PurgeSession(database, &dataReceived, messageType, conn)
+		case messages.MessageCodeSearch:
+			var dataReceived structures.SearchRequest
+			dataReceived.Unmarshal(msg.Data)
+			err = messaging_service.SearchChats(database, &dataReceived, messageType, conn)
+		case messages.MessageCodeSemanticSearch:
+			var dataReceived structures.SemanticSearchRequest
+			dataReceived.Unmarshal(msg.Data)
+			err = messaging_service.SemanticSearch(database, &dataReceived, messageType, conn)
+		case messages.MessageCodeDocumentStatus:
+			var dataReceived structures.DocumentStatusRequest
+			dataReceived.Unmarshal(msg.Data)
+			err = messaging_service.GetDocumentStatus(database, &dataReceived, messageType, conn)
+		case messages.MessageCodeListFiles:
+			var dataReceived structures.ListFilesRequest
+			dataReceived.Unmarshal(msg.Data)
+			err = messaging_service.ListSessionFiles(database, &dataReceived, messageType, conn)
 		case messages.MessageCodeGetAIModels:
 			var dataReceived structures.AIModelsRequest
 			dataReceived.Unmarshal(msg.Data)
@@ -88,8 +155,9 @@ func NewConnection(conn *websocket.Conn, database *services.Database) {
 			return
 		}
 
-		if err != nil {
-			fmt.Println("Connection Closed ..!!")
+		// aborted streaming replies were already answered with their own error frame
+		if err != nil && !errors.Is(err, messaging_service.ErrReplyAborted) {
+			log.Println("Unable to handle message:", err)
 			sendErrorOverWebSocket(conn, err.Error())
 		}
 	}
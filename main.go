@@ -1,8 +1,12 @@
 package main
 
 import (
+	"ai-chat/database/migrations"
 	"ai-chat/database/services"
 	"ai-chat/handlers"
+	"ai-chat/sync_worker/consumer"
+	"ai-chat/sync_worker/reconciler"
+	"context"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -15,13 +19,6 @@ import (
 	"github.com/joho/godotenv"
 )
 
-/*
-We need one more service which will perodically checks is database and cache is consistent or not;
-this is to make sure that if some bad happens then still all things remain consistent;
-this could be achieved by monitoring the logs metrics very carefully.
-
-*/
-
 func main() {
 	err := godotenv.Load(".env")
 	if err != nil {
@@ -29,26 +26,67 @@ func main() {
 		return
 	}
 
+	if os.Getenv("AUTH_SECRET_KEY") == "" {
+		log.Println("AUTH_SECRET_KEY must be set to authenticate clients")
+		return
+	}
+
 	database := services.GetDataBase()
 	log.Println("Database connected")
 
+	if err = migrations.Run(database.Db); err != nil {
+		log.Println("Unable to migrate database", err)
+		return
+	}
+	log.Println("Database migrated")
+
 	if err = services.LoadAllModels(database.Db); err != nil {
 		log.Println("Unable to load model data in database", err)
 		return
 	}
-	log.Println("AI Models Loaded successfully")
 
-	if err := services.LoadAllUsers(database); err != nil {
-		log.Println("Unable to load users data in cache", err)
+	if err = services.LoadModelRegistry(database.Db); err != nil {
+		log.Println("Unable to load model registry", err)
 		return
 	}
-	log.Println("Users Data Loaded successfully")
+	go services.RefreshModelRegistry(context.Background(), database.Db)
+	log.Println("AI Models Loaded successfully")
 
-	if err := services.PopulateRedisCache(database); err != nil {
-		log.Println("Unable to load session data in cache", err)
+	// users and sessions are read through on first use, only the recently active ones are loaded upfront
+	if err := services.WarmUpCache(database); err != nil {
+		log.Println("Unable to warm up cache", err)
 		return
 	}
-	log.Println("Session Loaded successfully")
+	log.Println("Cache warmed up successfully")
+
+	if os.Getenv("SYNC_WORKER_IN_PROCESS") == "true" {
+		go func() {
+			if err := consumer.NewConsumer(database).Run(context.Background()); err != nil {
+				log.Println("Stream consumer failed:", err)
+			}
+		}()
+	}
+
+	// periodically checks that the cache and the database are consistent, see RECONCILE_INTERVAL_MINUTES
+	go reconciler.NewReconciler(database).RunEvery(context.Background())
+
+	// permanently deletes sessions which stayed in the trash longer than TRASH_RETENTION_DAYS
+	go services.RunTrashPurger(context.Background(), database)
+
+	// summarizes sessions in the background after their chat turns, see SUMMARY_POLICY
+	go services.RunSummaryWorkers(context.Background(), database)
+
+	// embeds the exchanges in the background for semantic search and recall, which need Redis Stack
+	if err := database.EnsureEmbeddingIndex(context.Background()); err != nil {
+		log.Println("Unable to create embedding index, semantic search is unavailable", err)
+	}
+	go services.RunEmbeddingWorkers(context.Background(), database)
+
+	// splits and embeds uploaded documents in the background, their chunks are retrieved for the session's messages
+	if err := database.EnsureDocumentIndex(context.Background()); err != nil {
+		log.Println("Unable to create document index, uploaded documents are not retrieved", err)
+	}
+	go services.RunIngestionWorkers(context.Background(), database)
 
 	maxFileSize, _ := strconv.Atoi(os.Getenv("MAX_FILE_SIZE"))
 	app := fiber.New(fiber.Config{
@@ -59,13 +97,13 @@ func main() {
 
 	app.Use(logger.New())
 	app.Use(cors.New(cors.Config{
-		AllowHeaders: "Origin,Content-Type,Accept,Content-Length,Accept-Language,Accept-Encoding,Connection,Access-Control-Allow-Origin",
+		AllowHeaders: "Origin,Content-Type,Accept,Content-Length,Accept-Language,Accept-Encoding,Connection,Access-Control-Allow-Origin,Authorization",
 		AllowOrigins: "*",
 		AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
 	}))
 
-	// Static files
-	app.Static("/uploads", "./"+os.Getenv("PUBLIC_DIR"))
+	// uploaded files, read from the storage backend
+	handlers.FileServeHandler("/uploads", app, database)
 
 	// websockets
 	handlers.WebsocketHandler("/ws", app, database)
@@ -73,6 +111,9 @@ func main() {
 	// file upload
 	handlers.FileUploadHandler("/upload", app, database)
 
+	// full text search over the user's chats
+	handlers.SearchHandler("/search", app, database)
+
 	log.Printf("Server is starting at %s\n", os.Getenv("SERVER_ADDRESS"))
 	log.Fatal(app.Listen(fmt.Sprintf("%s:%s", os.Getenv("SERVER_HOST"), os.Getenv("SERVER_PORT"))))
 }
@@ -0,0 +1,23 @@
+package messaging_service
+
+import (
+	"github.com/gofiber/contrib/websocket"
+	"sync"
+)
+
+// Connection is a client WebSocket whose writes are serialized, so events sent by background jobs
+// such as the title generator can't interleave with the responses to the client's requests.
+type Connection struct {
+	*websocket.Conn
+	mutex sync.Mutex
+}
+
+func NewConnection(conn *websocket.Conn) *Connection {
+	return &Connection{Conn: conn}
+}
+
+func (c *Connection) WriteMessage(messageType int, data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.Conn.WriteMessage(messageType, data)
+}
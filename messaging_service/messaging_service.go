@@ -1,8 +1,10 @@
 package messaging_service
 
 import (
+	"ai-chat/api_call"
 	"ai-chat/database/services"
 	"ai-chat/database/structures"
+	"ai-chat/utils/auth"
 	"ai-chat/utils/helper_functions"
 	"ai-chat/utils/model_data"
 	"ai-chat/utils/response_code/error_code"
@@ -12,56 +14,42 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
+	"log"
 	"os"
-	"strconv"
+	"slices"
+	"strings"
+	"time"
 )
 
-func GetChatResponse(database *services.Database, received *structures.UserMessageRequest, messageType int, conn *websocket.Conn) error {
-	fmt.Println("Received File Name: ", received.FileName)
-	fmt.Println("Received Session Id: ", received.SessionId)
-	fmt.Println("Received Model : ", received.ModelName)
-
-	maxHistoryLength, err := strconv.Atoi(os.Getenv("MAX_CHAT_HISTORY_CONTEXT"))
+func GetChatResponse(database *services.Database, received *structures.UserMessageRequest, messageType int, conn *Connection) error {
+	modelId, err := model_data.ModelNumber(received.ModelName)
 	if err != nil {
-		return errors.New(string(error_code.Error(error_code.ErrorCodeInternalServerError)))
+		fmt.Println("Unknown model: ", received.ModelName, err)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnknownModel)))
 	}
 
-	var isNew bool = false
-	var balance float64 = 0
-	if balance, err = database.CheckModelAccessAndGetBalance(received.UserId, model_data.ModelNumber(received.ModelName)); err == redis.Nil {
-		fmt.Println("User Not Exists ..!!")
-		return errors.New(string(error_code.Error(error_code.ErrorCodeUserDoesNotExists)))
-	} else if err != nil {
-		fmt.Println("User dont have access ..!!")
-		return errors.New(string(error_code.Error(error_code.ErrorCodeUserDoesNotHaveModelAccess)))
-	} else if balance <= 0 {
-		fmt.Println("Insufficient balance ..!!")
-		return errors.New(string(error_code.Error(error_code.ErrorCodeInSufficientBalance)))
+	balance, err := checkModelAccess(database, received.UserId, modelId)
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("User have the access ..!!")
-
 	var sessionData structures.SessionData
 	if received.SessionId == "NEW" {
-		// create the session
+		// the message names the session until its title is generated
+		sessionName := helper_functions.TruncateText(received.Message, 20)
+		if sessionName == "" {
+			sessionName = helper_functions.DefaultSessionName
+		}
 		sessionData = structures.SessionData{
-			ModelId:     model_data.ModelNumber(received.ModelName),
-			SessionName: helper_functions.TruncateText(received.Message, 20),
+			ModelId:     modelId,
+			SessionName: sessionName,
 			Prompt:      received.Prompt,
 			FileName:    nil,
 			ChatSummary: "",
 			Chats:       nil,
 		}
-		sessionId, err := database.CreateNewSession(received.UserId, sessionData)
-		if err != nil {
-			return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToCreateSession)))
-		}
-		sessionData.SessionId = sessionId
-
-		isNew = true
-		fmt.Println("ADDED TO NEW SESSION")
-
 	} else {
 		var err error
 		sessionData, err = database.GetUserSessionData(received.UserId, received.SessionId)
@@ -70,29 +58,282 @@ func GetChatResponse(database *services.Database, received *structures.UserMessa
 		}
 	}
 
-	fmt.Println("SESSION ID: ", sessionData.SessionId)
-	fmt.Println("In OPEN AI ")
+	// the message continues the active branch
+	userMessage := structures.Chat{Id: uuid.NewString(), ParentId: sessionData.ActiveLeafId, Role: "user", Content: received.Message, CreatedAt: time.Now().UTC()}
+	if received.FileName != "" {
+		userMessage.Attachments = []string{received.FileName}
+	}
+
+	return replyToMessage(database, chatTurn{
+		userId:           received.UserId,
+		sessionData:      sessionData,
+		balance:          balance,
+		isNew:            received.SessionId == "NEW",
+		userMessage:      userMessage,
+		storeUserMessage: true,
+		fileName:         received.FileName,
+		stream:           received.Stream,
+		recall:           received.Recall,
+		// sessions started with a file upload are named once their first message is answered
+		generateTitle: received.SessionId == "NEW" || (len(sessionData.Chats) == 0 && sessionData.SessionName == helper_functions.DefaultSessionName),
+	}, messageType, conn)
+}
+
+// RegenerateChat answers the last user message of the active branch again. The previous replies stay in the session
+// as another branch.
+func RegenerateChat(database *services.Database, received *structures.RegenerateRequest, messageType int, conn *Connection) error {
+	sessionData, err := database.GetUserSessionData(received.UserId, received.SessionId)
+	if err != nil {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToLoadSession)))
+	}
+
+	balance, err := checkModelAccess(database, received.UserId, sessionData.ModelId)
+	if err != nil {
+		return err
+	}
+
+	userIndex := -1
+	for i := len(sessionData.Chats) - 1; i >= 0; i-- {
+		if sessionData.Chats[i].Role == "user" {
+			userIndex = i
+			break
+		}
+	}
+	if userIndex < 0 {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnknownChatMessage)))
+	}
+
+	// a file uploaded with the message is sent along again as long as it's still part of the session
+	userMessage := sessionData.Chats[userIndex]
+	var fileName string
+	for _, attachment := range userMessage.Attachments {
+		if slices.Contains(sessionData.FileName, attachment) {
+			fileName = attachment
+		}
+	}
+
+	sessionData.Chats = sessionData.Chats[:userIndex:userIndex]
+	return replyToMessage(database, chatTurn{
+		userId:      received.UserId,
+		sessionData: sessionData,
+		balance:     balance,
+		userMessage: userMessage,
+		fileName:    fileName,
+		stream:      received.Stream,
+	}, messageType, conn)
+}
+
+// EditChat answers a new version of a user message. It's added next to the original message, which stays in the
+// session as another branch.
+func EditChat(database *services.Database, received *structures.EditMessageRequest, messageType int, conn *Connection) error {
+	sessionData, chats, err := database.GetSessionMessages(received.UserId, received.SessionId)
+	if err != nil {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToLoadSession)))
+	}
+
+	balance, err := checkModelAccess(database, received.UserId, sessionData.ModelId)
+	if err != nil {
+		return err
+	}
+
+	original, ok := helper_functions.FindChat(chats, received.MessageId)
+	if !ok || original.Role != "user" {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnknownChatMessage)))
+	}
+
+	sessionData.Chats = nil
+	if original.ParentId != "" {
+		sessionData.Chats = helper_functions.BranchPath(chats, original.ParentId)
+	}
+	return replyToMessage(database, chatTurn{
+		userId:           received.UserId,
+		sessionData:      sessionData,
+		balance:          balance,
+		userMessage:      structures.Chat{Id: uuid.NewString(), ParentId: original.ParentId, Role: "user", Content: received.Message, CreatedAt: time.Now().UTC()},
+		storeUserMessage: true,
+		stream:           received.Stream,
+	}, messageType, conn)
+}
+
+// BranchChat forks the session at a message, the next chat message is added as another reply to it
+func BranchChat(database *services.Database, received *structures.BranchRequest, messageType int, conn *Connection) error {
+	sessionData, chats, err := database.GetSessionMessages(received.UserId, received.SessionId)
+	if err != nil {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToLoadChats)))
+	}
+
+	if _, ok := helper_functions.FindChat(chats, received.MessageId); !ok {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnknownChatMessage)))
+	}
+
+	if sessionData, err = database.SwitchBranch(received.UserId, sessionData, chats, received.MessageId); err != nil {
+		fmt.Println("Unable to switch branch: ", err)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToLoadChats)))
+	}
+
+	return sendSessionChats(database, conn, messageType, messages.MessageCodeChatBranch, received.UserId, sessionData, "", 0)
+}
+
+// checkModelAccess makes sure the user may use the model and has some balance left, the balance is returned
+func checkModelAccess(database *services.Database, userId string, modelId int) (float64, error) {
+	balance, err := database.CheckModelAccessAndGetBalance(userId, modelId)
+	if err == redis.Nil {
+		fmt.Println("User Not Exists ..!!")
+		return 0, errors.New(string(error_code.Error(error_code.ErrorCodeUserDoesNotExists)))
+	} else if err != nil {
+		fmt.Println("User dont have access ..!!")
+		return 0, errors.New(string(error_code.Error(error_code.ErrorCodeUserDoesNotHaveModelAccess)))
+	} else if balance <= 0 {
+		fmt.Println("Insufficient balance ..!!")
+		return 0, errors.New(string(error_code.Error(error_code.ErrorCodeInSufficientBalance)))
+	}
+
+	return balance, nil
+}
+
+// chatTurn is a user message to be answered
+type chatTurn struct {
+	userId string
+	// sessionData is the session the reply is added to, its chats are the history before the user message
+	sessionData structures.SessionData
+	balance     float64
+	// isNew sessions are created once the cost of the reply is held
+	isNew       bool
+	userMessage structures.Chat
+	// storeUserMessage is false when a message of the session is answered again
+	storeUserMessage bool
+	fileName         string
+	stream           bool
+	// generateTitle names the session after the reply, without delaying it
+	generateTitle bool
+	// recall is the number of related exchanges of the user's other sessions added to the context
+	recall int
+}
+
+// replyToMessage gets the AI response to the user message of the turn, sends it to the client and adds the new
+// messages to the session, where the reply becomes the end of the active branch.
+func replyToMessage(database *services.Database, turn chatTurn, messageType int, conn *Connection) error {
+	sessionData := turn.sessionData
+	modelName := model_data.ModelName(sessionData.ModelId)
+	message := turn.userMessage.Content
+
+	// only the latest chats which fit the context window of the model are sent along
+	maxOutputTokens := helper_functions.MaxOutputTokens()
+	contextData := sessionData
+	var sources []structures.DocumentSource
+	var err error
+	contextData.Prompt, sources = contextPrompt(database, turn, message)
+	contextData.Chats, err = helper_functions.FitContextWindow(contextData, message, maxOutputTokens)
+	if errors.Is(err, helper_functions.ErrMessageTooLong) && contextData.Prompt != sessionData.Prompt {
+		// the recalled exchanges and file excerpts are left out rather than the message
+		contextData.Prompt, sources = sessionData.Prompt, nil
+		contextData.Chats, err = helper_functions.FitContextWindow(contextData, message, maxOutputTokens)
+	}
+	if errors.Is(err, helper_functions.ErrMessageTooLong) {
+		fmt.Println("Message does not fit the context window of ", modelName)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeMessageTooLong)))
+	} else if err != nil {
+		fmt.Println("Unable to count tokens: ", err)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToTokenizeData)))
+	}
+
+	// hold the most the request can cost, so concurrent requests can't spend more than the balance
+	estimate, err := helper_functions.EstimateMaxCost(contextData, message, maxOutputTokens)
+	if err != nil {
+		fmt.Println("Unable to estimate cost: ", err)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToTokenizeData)))
+	}
+
+	holdId, ok, err := database.ReserveBalance(context.Background(), turn.userId, estimate)
+	if err != nil {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToGetBalanceDetails)))
+	} else if !ok {
+		fmt.Println("Balance does not cover the estimated cost ..!!", estimate)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeBalanceDoesNotCoverEstimate)))
+	}
+
+	settled := false
+	defer func() {
+		if !settled {
+			if err := database.ReleaseHold(context.Background(), turn.userId, holdId); err != nil {
+				fmt.Println("Unable to release hold: ", err)
+			}
+		}
+	}()
 
+	if turn.isNew {
+		// create the session
+		sessionId, err := database.CreateNewSession(turn.userId, sessionData)
+		if err != nil {
+			return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToCreateSession)))
+		}
+		sessionData.SessionId = sessionId
+	}
+
+	// the AI service downloads the file through a signed url, the files aren't public
 	var fileURL []string
-	if received.FileName != "" {
-		fileURL = append(fileURL, fmt.Sprintf("http://app:%s/uploads/%s", os.Getenv("SERVER_PORT"), received.FileName))
+	if turn.fileName != "" {
+		signedURL, err := auth.SignedFileURL(fmt.Sprintf("http://app:%s/uploads", os.Getenv("SERVER_PORT")), turn.fileName, "", services.FileURLExpiry())
+		if err != nil {
+			fmt.Println("Unable to sign file url: ", err)
+		} else {
+			fileURL = append(fileURL, signedURL)
+		}
 	}
 
 	// API Call
-	// Here use sessionData.FileName instead of received.FileName for all session Files
-	AiResponse, sessionCost, err := database.AIService.AIApiCall(received.UserId, sessionData.SessionId,
-		received.Message, fileURL, sessionData.Prompt, sessionData.Chats, sessionData.ChatSummary, model_data.ModelName(sessionData.ModelId), model_data.GetModelProvider(model_data.ModelName(sessionData.ModelId)), balance)
+	// Here use sessionData.FileName instead of turn.fileName for all session Files
+	var aiResponse *api_call.AIResponse
+	if turn.stream {
+		aiResponse, err = database.AIService.AIApiCallStream(turn.userId, sessionData.SessionId,
+			message, fileURL, contextData.Prompt, contextData.Chats, sessionData.ChatSummary, modelName, model_data.GetModelProvider(modelName), turn.balance,
+			func(chunk string) error {
+				return sendChatChunk(conn, messageType, turn.userId, sessionData.SessionId, chunk)
+			})
+	} else {
+		aiResponse, err = database.AIService.AIApiCall(turn.userId, sessionData.SessionId,
+			message, fileURL, contextData.Prompt, contextData.Chats, sessionData.ChatSummary, modelName, model_data.GetModelProvider(modelName), turn.balance)
+	}
 	if err != nil {
+		// a new session only exists in the cache until its first reply is streamed, without one it is dropped
+		if turn.isNew {
+			if err := database.DeleteCachedSession(context.Background(), turn.userId, sessionData.SessionId); err != nil {
+				log.Println("Unable to remove unanswered session:", err)
+			}
+		}
+		if turn.stream {
+			sendChatError(conn, messageType, turn.userId, sessionData.SessionId, error_code.ErrorCodeUnableToReceiveResponseToQuery)
+			return ErrReplyAborted
+		}
 		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToReceiveResponseToQuery)))
 	}
 
-	sessionData.Chats = append(sessionData.Chats, structures.Chat{"user", received.Message})
+	reply := structures.Chat{
+		Id:           uuid.NewString(),
+		ParentId:     turn.userMessage.Id,
+		Role:         "assistant",
+		Content:      aiResponse.Text,
+		ModelId:      sessionData.ModelId,
+		InputTokens:  aiResponse.InputTokens,
+		OutputTokens: aiResponse.OutputTokens,
+		Cost:         aiResponse.Cost,
+		CreatedAt:    time.Now().UTC(),
+	}
 
 	data := structures.UserMessageResponse{
-		UserId:      received.UserId,
-		SessionId:   sessionData.SessionId,
-		SessionName: helper_functions.TruncateText(received.Message, 20),
-		Message:     AiResponse,
+		UserId:        turn.userId,
+		SessionId:     sessionData.SessionId,
+		SessionName:   sessionData.SessionName,
+		Message:       aiResponse.Text,
+		MessageId:     reply.Id,
+		UserMessageId: turn.userMessage.Id,
+		Sources:       sources,
+	}
+
+	// streaming clients get the assembled message in the done frame
+	responseCode := messages.MessageCodeChatMessage
+	if turn.stream {
+		responseCode = messages.MessageCodeChatDone
 	}
 
 	var response []byte
@@ -100,7 +341,7 @@ func GetChatResponse(database *services.Database, received *structures.UserMessa
 		err = conn.WriteMessage(messageType, error_code.Error(error_code.ErrorCodeJSONMarshal))
 	} else {
 		toSend := structures.ClientResponse{
-			MessageType: messages.MessageCodeChatMessage,
+			MessageType: responseCode,
 			Data:        response,
 		}
 
@@ -109,55 +350,195 @@ func GetChatResponse(database *services.Database, received *structures.UserMessa
 	}
 
 	var newConversion []structures.Chat
-	newConversion = append(newConversion, structures.Chat{Role: "user", Content: received.Message}, structures.Chat{Role: "assistant", Content: AiResponse})
-	if received.FileName != "" {
-		sessionData.Chats = append(sessionData.Chats, structures.Chat{Role: "assistant", Content: AiResponse}, structures.Chat{Role: "file", Content: received.FileName})
-		newConversion = append(newConversion, structures.Chat{Role: "file", Content: received.FileName})
-	} else {
-		// Load the changes in cache
-		sessionData.Chats = append(sessionData.Chats, structures.Chat{Role: "assistant", Content: AiResponse})
+	if turn.storeUserMessage {
+		newConversion = append(newConversion, turn.userMessage)
 	}
+	newConversion = append(newConversion, reply)
 
-	// Keep only the latest 10 chats
-	if len(sessionData.Chats) > maxHistoryLength {
-		sessionData.Chats = sessionData.Chats[len(sessionData.Chats)-maxHistoryLength:]
-	}
+	// Load the changes in cache
+	sessionData.Chats = append(sessionData.Chats, turn.userMessage, reply)
+	sessionData.ActiveLeafId = reply.Id
+	sessionData.LastMessageAt = reply.CreatedAt
+
+	sessionData.Chats = helper_functions.LatestChats(sessionData.Chats)
 
 	newConversionStr, err := json.Marshal(newConversion)
 	if err != nil {
 		return errors.New(string(error_code.Error(error_code.ErrorCodeJSONMarshal)))
 	}
 
-	var summaryCost float64 = 0
-	sessionData.ChatSummary, summaryCost, err = database.GetUpdatedSummary(sessionData.ChatSummary, fmt.Sprintf("User: %s\n\nAssistant: %s", received.Message, AiResponse), model_data.ModelName(sessionData.ModelId))
-	fmt.Println("Summary Generation Error: ", err)
-	err = database.SetSessionValues(received.UserId, sessionData)
-	fmt.Println("Session Value Update Error: ", err)
+	ledger := []structures.LedgerEntry{{
+		Amount:       -aiResponse.Cost,
+		Reason:       structures.LedgerReasonChat,
+		ModelId:      &sessionData.ModelId,
+		SessionId:    &sessionData.SessionId,
+		InputTokens:  aiResponse.InputTokens,
+		OutputTokens: aiResponse.OutputTokens,
+	}}
+
+	if err := database.SetSessionChats(turn.userId, sessionData); err != nil {
+		log.Println("Unable to cache session chats:", err)
+	}
 
-	fmt.Printf("API Cost: %f, summary cost: %f, total cost: %f, remaining balance: %f",
-		sessionCost, summaryCost, sessionCost+summaryCost, balance-(sessionCost+summaryCost))
+	// the cost was incurred either way, so the ledger entries are persisted even if the cached balance couldn't be changed
+	_, err = database.SettleHold(context.Background(), turn.userId, holdId, ledger)
+	settled = err == nil
+	if err != nil {
+		log.Println("Unable to settle balance hold:", err)
+	}
 
-	sessionCost = sessionCost + summaryCost
-	balance = balance - sessionCost
-	err = database.SetUserValues(received.UserId, balance)
-	fmt.Println("Session Value Balance Update Error: ", err)
+	ledgerStr, err := json.Marshal(ledger)
+	if err != nil {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeJSONMarshal)))
+	}
 
 	err = database.Stream.AddToStream(
 		context.Background(),
-		received.UserId,
+		turn.userId,
 		sessionData.SessionId,
 		fmt.Sprintf("%d", sessionData.ModelId),
 		sessionData.Prompt,
 		string(newConversionStr),
-		sessionData.ChatSummary,
+		"", // summaries are persisted by the summary jobs
 		sessionData.SessionName,
-		isNew,
-		balance)
-	fmt.Println("Add To Stream Error: ", err)
+		turn.isNew,
+		string(ledgerStr),
+		sessionData.ActiveLeafId)
+	if err != nil {
+		log.Println("Unable to add chat turn to stream:", err)
+	}
+
+	// the summary is written in the background, after the turn is in the stream
+	if services.SummaryDue(sessionData, contextData.Chats) {
+		if err := database.EnqueueSummary(context.Background(), turn.userId, sessionData.SessionId); err != nil {
+			fmt.Println("Unable to enqueue summary: ", err)
+		}
+	}
+
+	// the exchange is embedded in the background so later messages can recall it
+	if err := database.EnqueueEmbedding(context.Background(), structures.EmbeddingJob{
+		UserId:    turn.userId,
+		SessionId: sessionData.SessionId,
+		MessageId: reply.Id,
+		Message:   message,
+		Reply:     reply.Content,
+		CreatedAt: reply.CreatedAt,
+	}); err != nil {
+		fmt.Println("Unable to enqueue embedding: ", err)
+	}
+
+	if turn.generateTitle {
+		go sendSessionTitle(database, conn, messageType, turn.userId, sessionData.SessionId, sessionData.SessionName, message, aiResponse.Text)
+	}
 	return nil
 }
 
-func GetUserDetails(database *services.Database, received *structures.UserDataRequest, messageType int, conn *websocket.Conn) error {
+// contextPrompt returns the prompt of the session followed by the excerpts of the session's files and the exchanges
+// of the user's other sessions which relate to the message, along with the excerpts. What can't be searched is left out.
+func contextPrompt(database *services.Database, turn chatTurn, message string) (string, []structures.DocumentSource) {
+	prompt := turn.sessionData.Prompt
+
+	sources, err := database.RetrieveDocumentChunks(context.Background(), turn.sessionData.SessionId, turn.sessionData.FileName, message)
+	if err != nil {
+		fmt.Println("Unable to retrieve document chunks: ", err)
+	} else if len(sources) > 0 {
+		prompt += "\n\n" + api_call.GetDocumentPrompt(sources)
+	}
+
+	if turn.recall > 0 {
+		exchanges, err := database.RecallExchanges(context.Background(), turn.userId, turn.sessionData.SessionId, message, turn.recall)
+		if err != nil {
+			fmt.Println("Unable to recall exchanges: ", err)
+		} else if len(exchanges) > 0 {
+			prompt += "\n\n" + api_call.GetRecallPrompt(exchanges)
+		}
+	}
+	return strings.TrimSpace(prompt), sources
+}
+
+// sendSessionTitle renames the session with a title generated from its first exchange and tells the client
+func sendSessionTitle(database *services.Database, conn *Connection, messageType int, userId, sessionId, sessionName, message, reply string) {
+	details, err := database.GenerateSessionTitle(userId, sessionId, sessionName, message, reply)
+	if errors.Is(err, services.ErrSessionRenamed) || errors.Is(err, services.ErrBalanceNotCovered) {
+		return
+	} else if err != nil {
+		fmt.Println("Unable to generate session title: ", err)
+		return
+	}
+
+	data := structures.SessionUpdateResponse{
+		UserId:         userId,
+		SessionId:      sessionId,
+		SessionDetails: details,
+	}
+
+	response, err := data.Marshal()
+	if err != nil {
+		return
+	}
+
+	toSend := structures.ClientResponse{
+		MessageType: messages.MessageCodeSessionRenamed,
+		Data:        response,
+	}
+
+	response, _ = toSend.Marshal()
+	if err := conn.WriteMessage(messageType, response); err != nil {
+		fmt.Println("Unable to send session title: ", err)
+	}
+}
+
+// sendChatChunk writes one partial piece of a streamed AI response to the client.
+func sendChatChunk(conn *Connection, messageType int, userId, sessionId, chunk string) error {
+	data := structures.ChatChunkResponse{
+		UserId:    userId,
+		SessionId: sessionId,
+		Chunk:     chunk,
+	}
+
+	response, err := data.Marshal()
+	if err != nil {
+		return err
+	}
+
+	toSend := structures.ClientResponse{
+		MessageType: messages.MessageCodeChatChunk,
+		Data:        response,
+	}
+
+	response, _ = toSend.Marshal()
+	return conn.WriteMessage(messageType, response)
+}
+
+// ErrReplyAborted is returned when a streaming client was already sent a MessageCodeChatError frame, so no other
+// error is sent for the message
+var ErrReplyAborted = errors.New("reply aborted")
+
+// sendChatError tells a streaming client that the response was aborted and the chunks received so far must be discarded.
+func sendChatError(conn *Connection, messageType int, userId, sessionId string, errorCode int) {
+	data := structures.ChatErrorResponse{
+		UserId:    userId,
+		SessionId: sessionId,
+		Error:     string(error_code.Message(errorCode)),
+	}
+
+	response, err := data.Marshal()
+	if err != nil {
+		return
+	}
+
+	toSend := structures.ClientResponse{
+		MessageType: messages.MessageCodeChatError,
+		Data:        response,
+	}
+
+	response, _ = toSend.Marshal()
+	if err := conn.WriteMessage(messageType, response); err != nil {
+		fmt.Println("Unable to send chat error: ", err)
+	}
+}
+
+func GetUserDetails(database *services.Database, received *structures.UserDataRequest, messageType int, conn *Connection) error {
 	data, err := database.GetUserDetails(received.UserId)
 	if err != nil {
 		return errors.New(string(error_code.Error(error_code.ErrorCodeUserDoesNotExists)))
@@ -178,20 +559,75 @@ func GetUserDetails(database *services.Database, received *structures.UserDataRe
 	return err
 }
 
-func GetChatsBySessionId(database *services.Database, received *structures.SessionChatsRequest, messageType int, conn *websocket.Conn) error {
-	data, err := database.GetUserSessionChat(received.SessionId)
+// GetChatsBySessionId sends a page of the active branch of the session, newest first. A leaf id in the request
+// switches to the branch holding that message first.
+func GetChatsBySessionId(database *services.Database, received *structures.SessionChatsRequest, messageType int, conn *Connection) error {
+	sessionData, err := database.GetUserSessionData(received.UserId, received.SessionId)
+	if err != nil {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToLoadChats)))
+	}
+
+	if received.LeafId != "" {
+		_, chats, err := database.GetSessionMessages(received.UserId, received.SessionId)
+		if err != nil {
+			return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToLoadChats)))
+		}
+		if _, ok := helper_functions.FindChat(chats, received.LeafId); !ok {
+			return errors.New(string(error_code.Error(error_code.ErrorCodeUnknownChatMessage)))
+		}
+
+		if leafId := helper_functions.LatestLeaf(chats, received.LeafId); leafId != sessionData.ActiveLeafId {
+			if sessionData, err = database.SwitchBranch(received.UserId, sessionData, chats, leafId); err != nil {
+				fmt.Println("Unable to switch branch: ", err)
+				return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToLoadChats)))
+			}
+		}
+	}
+
+	return sendSessionChats(database, conn, messageType, messages.MessageCodeChatsBySessionId, received.UserId, sessionData, received.Cursor, received.Limit)
+}
+
+// sendSessionChats sends a page of the active branch starting at cursor, or at its latest chat without one, along
+// with the alternatives of its messages
+func sendSessionChats(database *services.Database, conn *Connection, messageType int, responseCode int, userId string, sessionData structures.SessionData, cursor string, limit int) error {
+	startId := sessionData.ActiveLeafId
+	if cursor != "" {
+		startId = cursor
+	}
+
+	page, nextCursor, err := database.GetBranchPage(context.Background(), sessionData, startId, services.ChatPageSize(limit))
+	if err != nil {
+		fmt.Println("Unable to load chats: ", err)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToLoadChats)))
+	}
+	if cursor != "" && len(page) == 0 {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnknownChatMessage)))
+	}
+
+	branches, err := database.GetPageBranches(context.Background(), sessionData, page)
 	if err != nil {
+		fmt.Println("Unable to load branches: ", err)
 		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToLoadChats)))
 	}
 
-	resp := structures.SessionChatsResponse{UserId: received.UserId, SessionId: received.SessionId, Chats: data}
+	if page == nil {
+		page = []structures.Chat{}
+	}
+	resp := structures.SessionChatsResponse{
+		UserId:       userId,
+		SessionId:    sessionData.SessionId,
+		Chats:        page,
+		NextCursor:   nextCursor,
+		ActiveLeafId: sessionData.ActiveLeafId,
+		Branches:     branches,
+	}
 
 	var response []byte
 	if response, err = resp.Marshal(); err != nil {
 		err = conn.WriteMessage(messageType, error_code.Error(error_code.ErrorCodeJSONMarshal))
 	} else {
 		toSend := structures.ClientResponse{
-			MessageType: messages.MessageCodeChatsBySessionId,
+			MessageType: responseCode,
 			Data:        response,
 		}
 
@@ -201,9 +637,37 @@ func GetChatsBySessionId(database *services.Database, received *structures.Sessi
 	return err
 }
 
-func GetListOfSessions(database *services.Database, received *structures.UserSessionsRequest, messageType int, conn *websocket.Conn) error {
-	data, err := database.GetSessionsByUserId(received.UserId)
-	if err != nil {
+func GetListOfSessions(database *services.Database, received *structures.UserSessionsRequest, messageType int, conn *Connection) error {
+	// archived sessions are only listed when asked for, the trash lists every deleted session
+	archived := received.Archived
+	if archived == nil && !received.Trashed {
+		archived = new(bool)
+	}
+
+	options := structures.SessionListOptions{
+		OrderBy:  received.OrderBy,
+		Pinned:   received.Pinned,
+		Archived: archived,
+		Tag:      received.Tag,
+		Trashed:  received.Trashed,
+		Cursor:   received.Cursor,
+		Limit:    received.Limit,
+	}
+	if received.ModelName != "" {
+		modelId, err := model_data.ModelNumber(received.ModelName)
+		if err != nil {
+			return errors.New(string(error_code.Error(error_code.ErrorCodeUnknownModel)))
+		}
+		options.ModelId = modelId
+	}
+
+	data, err := database.GetSessionsByUserId(received.UserId, options)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeInvalidCursor)))
+	} else if errors.Is(err, services.ErrUnknownSessionOrder) {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnknownSortOrder)))
+	} else if err != nil {
+		fmt.Println("Unable to list sessions: ", err)
 		return errors.New(string(error_code.Error(error_code.ErrorCodeUserDoesNotExists)))
 	}
 
@@ -222,9 +686,55 @@ func GetListOfSessions(database *services.Database, received *structures.UserSes
 	return err
 }
 
-func DeleteSession(database *services.Database, received *structures.SessionDeleteRequest, messageType int, conn *websocket.Conn) error {
-	data, err := database.DeleteSession(received.UserId, received.SessionId)
+// UpdateSession renames, pins, archives or tags a session
+func UpdateSession(database *services.Database, received *structures.SessionUpdateRequest, messageType int, conn *Connection) error {
+	details, err := database.UpdateSessionDetails(received.UserId, received.SessionId, func(details *structures.SessionDetails) {
+		if received.SessionName != nil {
+			details.SessionName = *received.SessionName
+		}
+		if received.Pinned != nil {
+			details.Pinned = *received.Pinned
+		}
+		if received.Archived != nil {
+			details.Archived = *received.Archived
+		}
+		if received.Tags != nil {
+			details.Tags = *received.Tags
+		}
+	}, nil)
+	if errors.Is(err, services.ErrInvalidSessionDetails) {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeInvalidSessionDetails)))
+	} else if err != nil {
+		fmt.Println("Unable to update session: ", err)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToLoadSession)))
+	}
+
+	data := structures.SessionUpdateResponse{
+		UserId:         received.UserId,
+		SessionId:      received.SessionId,
+		SessionDetails: details,
+	}
+
+	var response []byte
+	if response, err = data.Marshal(); err != nil {
+		err = conn.WriteMessage(messageType, error_code.Error(error_code.ErrorCodeJSONMarshal))
+	} else {
+		toSend := structures.ClientResponse{
+			MessageType: messages.MessageCodeSessionUpdate,
+			Data:        response,
+		}
+
+		response, _ = toSend.Marshal()
+		err = conn.WriteMessage(messageType, response)
+	}
+	return err
+}
+
+// DeleteSession moves one or several sessions to the trash
+func DeleteSession(database *services.Database, received *structures.SessionDeleteRequest, messageType int, conn *Connection) error {
+	data, err := database.DeleteSession(received.UserId, received.Ids())
 	if err != nil {
+		fmt.Println("Unable to delete session: ", err)
 		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToDeleteSession)))
 	}
 
@@ -243,7 +753,194 @@ func DeleteSession(database *services.Database, received *structures.SessionDele
 	return err
 }
 
-func AIModesList(database *services.Database, s *structures.AIModelsRequest, messageType int, conn *websocket.Conn) error {
+// RestoreSession takes sessions out of the trash
+func RestoreSession(database *services.Database, received *structures.SessionRestoreRequest, messageType int, conn *Connection) error {
+	data, err := database.RestoreSessions(received.UserId, received.SessionIds)
+	if err != nil {
+		fmt.Println("Unable to restore session: ", err)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToRestoreSession)))
+	}
+
+	var response []byte
+	if response, err = data.Marshal(); err != nil {
+		err = conn.WriteMessage(messageType, error_code.Error(error_code.ErrorCodeJSONMarshal))
+	} else {
+		toSend := structures.ClientResponse{
+			MessageType: messages.MessageCodeSessionRestore,
+			Data:        response,
+		}
+
+		response, _ = toSend.Marshal()
+		err = conn.WriteMessage(messageType, response)
+	}
+	return err
+}
+
+// PurgeSession permanently deletes sessions from the trash
+func PurgeSession(database *services.Database, received *structures.SessionPurgeRequest, messageType int, conn *Connection) error {
+	data, err := database.PurgeSessions(received.UserId, received.SessionIds)
+	if err != nil {
+		fmt.Println("Unable to purge session: ", err)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToPurgeSession)))
+	}
+
+	var response []byte
+	if response, err = data.Marshal(); err != nil {
+		err = conn.WriteMessage(messageType, error_code.Error(error_code.ErrorCodeJSONMarshal))
+	} else {
+		toSend := structures.ClientResponse{
+			MessageType: messages.MessageCodeSessionPurge,
+			Data:        response,
+		}
+
+		response, _ = toSend.Marshal()
+		err = conn.WriteMessage(messageType, response)
+	}
+	return err
+}
+
+// SearchChats sends a page of the user's messages and session names matching the query
+func SearchChats(database *services.Database, received *structures.SearchRequest, messageType int, conn *Connection) error {
+	data, err := database.SearchChats(received.UserId, *received)
+	if errors.Is(err, services.ErrInvalidSearchQuery) {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeInvalidSearchQuery)))
+	} else if errors.Is(err, services.ErrInvalidCursor) {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeInvalidCursor)))
+	} else if err != nil {
+		fmt.Println("Unable to search chats: ", err)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToSearch)))
+	}
+
+	var response []byte
+	if response, err = data.Marshal(); err != nil {
+		err = conn.WriteMessage(messageType, error_code.Error(error_code.ErrorCodeJSONMarshal))
+	} else {
+		toSend := structures.ClientResponse{
+			MessageType: messages.MessageCodeSearch,
+			Data:        response,
+		}
+
+		response, _ = toSend.Marshal()
+		err = conn.WriteMessage(messageType, response)
+	}
+	return err
+}
+
+// SemanticSearch sends the exchanges of the user's sessions closest in meaning to the query
+func SemanticSearch(database *services.Database, received *structures.SemanticSearchRequest, messageType int, conn *Connection) error {
+	hits, err := database.SemanticSearch(context.Background(), received.UserId, received.Query, "", received.Limit)
+	if errors.Is(err, services.ErrInvalidSearchQuery) {
+		return errors.New(string(error_code.Error(error_code.ErrorCodeInvalidSearchQuery)))
+	} else if errors.Is(err, services.ErrUnableToEmbed) {
+		fmt.Println("Unable to embed search query: ", err)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToCreateEmbedding)))
+	} else if err != nil {
+		fmt.Println("Unable to search embeddings: ", err)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToSearchForEmbedding)))
+	}
+
+	data := structures.SemanticSearchResponse{
+		UserId: received.UserId,
+		Query:  received.Query,
+		Hits:   hits,
+	}
+
+	var response []byte
+	if response, err = data.Marshal(); err != nil {
+		err = conn.WriteMessage(messageType, error_code.Error(error_code.ErrorCodeJSONMarshal))
+	} else {
+		toSend := structures.ClientResponse{
+			MessageType: messages.MessageCodeSemanticSearch,
+			Data:        response,
+		}
+
+		response, _ = toSend.Marshal()
+		err = conn.WriteMessage(messageType, response)
+	}
+	return err
+}
+
+// GetDocumentStatus sends the ingestion status of the files of a session
+func GetDocumentStatus(database *services.Database, received *structures.DocumentStatusRequest, messageType int, conn *Connection) error {
+	statuses, err := database.GetDocumentStatuses(context.Background(), received.UserId, received.SessionId)
+	if err != nil {
+		fmt.Println("Unable to load document status: ", err)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToLoadDocuments)))
+	}
+
+	data := structures.DocumentStatusResponse{
+		UserId:    received.UserId,
+		SessionId: received.SessionId,
+		Documents: statuses,
+	}
+
+	var response []byte
+	if response, err = data.Marshal(); err != nil {
+		err = conn.WriteMessage(messageType, error_code.Error(error_code.ErrorCodeJSONMarshal))
+	} else {
+		toSend := structures.ClientResponse{
+			MessageType: messages.MessageCodeDocumentStatus,
+			Data:        response,
+		}
+
+		response, _ = toSend.Marshal()
+		err = conn.WriteMessage(messageType, response)
+	}
+	return err
+}
+
+// ListSessionFiles sends the metadata of the files uploaded to a session
+func ListSessionFiles(database *services.Database, received *structures.ListFilesRequest, messageType int, conn *Connection) error {
+	files, err := database.ListSessionFiles(context.Background(), received.UserId, received.SessionId)
+	if err != nil {
+		fmt.Println("Unable to load files: ", err)
+		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToLoadFiles)))
+	}
+
+	data := structures.ListFilesResponse{
+		UserId:    received.UserId,
+		SessionId: received.SessionId,
+		Files:     files,
+	}
+
+	var response []byte
+	if response, err = data.Marshal(); err != nil {
+		err = conn.WriteMessage(messageType, error_code.Error(error_code.ErrorCodeJSONMarshal))
+	} else {
+		toSend := structures.ClientResponse{
+			MessageType: messages.MessageCodeListFiles,
+			Data:        response,
+		}
+
+		response, _ = toSend.Marshal()
+		err = conn.WriteMessage(messageType, response)
+	}
+	return err
+}
+
+// ForwardUserEvents sends the messages published for the user by background jobs, such as the status of uploaded
+// files, to the connection until the context is cancelled
+func ForwardUserEvents(ctx context.Context, database *services.Database, userId string, conn *Connection) {
+	subscription := database.Cache.Subscribe(ctx, services.UserEventsChannel(userId))
+	defer subscription.Close()
+
+	events := subscription.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(event.Payload)); err != nil {
+				fmt.Println("Unable to forward event: ", err)
+			}
+		}
+	}
+}
+
+func AIModesList(database *services.Database, s *structures.AIModelsRequest, messageType int, conn *Connection) error {
 	data, err := database.GetAIModel()
 	if err != nil {
 		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToGenerateAIModelList)))
@@ -264,7 +961,7 @@ func AIModesList(database *services.Database, s *structures.AIModelsRequest, mes
 	return err
 }
 
-func GetBalance(database *services.Database, request *structures.GetBalanceRequest, messageType int, conn *websocket.Conn) error {
+func GetBalance(database *services.Database, request *structures.GetBalanceRequest, messageType int, conn *Connection) error {
 	balance, err := database.GetBalance(request.UserId)
 	if err != nil {
 		return errors.New(string(error_code.Error(error_code.ErrorCodeUnableToGetBalanceDetails)))
@@ -19,7 +19,8 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	AIService_Process_FullMethodName = "/ai_service.AIService/Process"
+	AIService_Process_FullMethodName       = "/ai_service.AIService/Process"
+	AIService_ProcessStream_FullMethodName = "/ai_service.AIService/ProcessStream"
 )
 
 // AIServiceClient is the client API for AIService service.
@@ -30,6 +31,8 @@ const (
 type AIServiceClient interface {
 	// Processes a chat message and returns a response
 	Process(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	// Processes a chat message and streams the response back chunk by chunk
+	ProcessStream(ctx context.Context, in *Request, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamResponse], error)
 }
 
 type aIServiceClient struct {
@@ -50,6 +53,25 @@ func (c *aIServiceClient) Process(ctx context.Context, in *Request, opts ...grpc
 	return out, nil
 }
 
+func (c *aIServiceClient) ProcessStream(ctx context.Context, in *Request, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &AIService_ServiceDesc.Streams[0], AIService_ProcessStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Request, StreamResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AIService_ProcessStreamClient = grpc.ServerStreamingClient[StreamResponse]
+
 // AIServiceServer is the server API for AIService service.
 // All implementations must embed UnimplementedAIServiceServer
 // for forward compatibility.
@@ -58,6 +80,8 @@ func (c *aIServiceClient) Process(ctx context.Context, in *Request, opts ...grpc
 type AIServiceServer interface {
 	// Processes a chat message and returns a response
 	Process(context.Context, *Request) (*Response, error)
+	// Processes a chat message and streams the response back chunk by chunk
+	ProcessStream(*Request, grpc.ServerStreamingServer[StreamResponse]) error
 	mustEmbedUnimplementedAIServiceServer()
 }
 
@@ -71,6 +95,9 @@ type UnimplementedAIServiceServer struct{}
 func (UnimplementedAIServiceServer) Process(context.Context, *Request) (*Response, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Process not implemented")
 }
+func (UnimplementedAIServiceServer) ProcessStream(*Request, grpc.ServerStreamingServer[StreamResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method ProcessStream not implemented")
+}
 func (UnimplementedAIServiceServer) mustEmbedUnimplementedAIServiceServer() {}
 func (UnimplementedAIServiceServer) testEmbeddedByValue()                   {}
 
@@ -110,6 +137,17 @@ func _AIService_Process_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _AIService_ProcessStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Request)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AIServiceServer).ProcessStream(m, &grpc.GenericServerStream[Request, StreamResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AIService_ProcessStreamServer = grpc.ServerStreamingServer[StreamResponse]
+
 // AIService_ServiceDesc is the grpc.ServiceDesc for AIService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -122,6 +160,12 @@ var AIService_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _AIService_Process_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ProcessStream",
+			Handler:       _AIService_ProcessStream_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "ai_service.proto",
 }
@@ -0,0 +1,192 @@
+package consumer
+
+import (
+	"ai-chat/database/services"
+	"ai-chat/sync_worker/worker"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	readCount    = 50
+	readBlock    = 5 * time.Second
+	retryBackoff = time.Second
+)
+
+// Consumer reads the chat turns published to REDIS_STREAM through a consumer group and persists them in Postgres.
+// Entries are only acknowledged once they are stored, entries left pending by a crashed consumer are claimed
+// after REDIS_STREAM_CLAIM_IDLE seconds and entries which keep failing are moved to the dead letter stream.
+type Consumer struct {
+	database      *services.Database
+	cache         *redis.Client
+	stream        string
+	group         string
+	name          string
+	claimIdle     time.Duration
+	maxDeliveries int64
+}
+
+func NewConsumer(database *services.Database) *Consumer {
+	claimIdle, err := strconv.Atoi(os.Getenv("REDIS_STREAM_CLAIM_IDLE"))
+	if err != nil || claimIdle <= 0 {
+		claimIdle = 60
+	}
+
+	maxDeliveries, err := strconv.ParseInt(os.Getenv("REDIS_STREAM_MAX_DELIVERIES"), 10, 64)
+	if err != nil || maxDeliveries <= 0 {
+		maxDeliveries = 5
+	}
+
+	return &Consumer{
+		database:      database,
+		cache:         database.Stream.Cache,
+		stream:        os.Getenv("REDIS_STREAM"),
+		group:         os.Getenv("REDIS_STREAM_GROUP"),
+		name:          os.Getenv("REDIS_WORKER_NAME"),
+		claimIdle:     time.Duration(claimIdle) * time.Second,
+		maxDeliveries: maxDeliveries,
+	}
+}
+
+// Run consumes the stream until the context is cancelled
+func (c *Consumer) Run(ctx context.Context) error {
+	if err := c.createGroup(ctx); err != nil {
+		return err
+	}
+	log.Printf("Stream consumer %s started on %s/%s\n", c.name, c.stream, c.group)
+
+	for ctx.Err() == nil {
+		if err := c.claimPending(ctx); err != nil && ctx.Err() == nil {
+			log.Println("Unable to claim pending stream entries:", err)
+		}
+
+		streams, err := c.cache.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.name,
+			Streams:  []string{c.stream, ">"},
+			Count:    readCount,
+			Block:    readBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Println("Unable to read from stream:", err)
+			time.Sleep(retryBackoff)
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				c.process(ctx, message)
+			}
+		}
+	}
+
+	log.Printf("Stream consumer %s stopped\n", c.name)
+	return nil
+}
+
+func (c *Consumer) createGroup(ctx context.Context) error {
+	err := c.cache.XGroupCreateMkStream(ctx, c.stream, c.group, "0").Err()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("unable to create consumer group: %w", err)
+	}
+	return nil
+}
+
+// claimPending takes over entries which another consumer read but never acknowledged
+func (c *Consumer) claimPending(ctx context.Context) error {
+	pending, err := c.cache.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: c.stream,
+		Group:  c.group,
+		Idle:   c.claimIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  readCount,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for _, entry := range pending {
+		// RetryCount counts every delivery, including the first read
+		if entry.RetryCount >= c.maxDeliveries {
+			c.deadLetter(ctx, entry.ID)
+			continue
+		}
+		ids = append(ids, entry.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	messages, err := c.cache.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   c.stream,
+		Group:    c.group,
+		Consumer: c.name,
+		MinIdle:  c.claimIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		c.process(ctx, message)
+	}
+	return nil
+}
+
+func (c *Consumer) process(ctx context.Context, message redis.XMessage) {
+	entry, err := worker.ParseStreamEntry(message.Values)
+	if err != nil {
+		// a malformed entry will never succeed, don't keep it pending
+		log.Printf("Dropping malformed stream entry %s: %v\n", message.ID, err)
+		c.deadLetter(ctx, message.ID)
+		return
+	}
+
+	if err := c.database.ApplyStreamEntry(ctx, entry); err != nil {
+		// left pending, it is retried once it has been idle for claimIdle
+		log.Printf("Unable to apply stream entry %s: %v\n", message.ID, err)
+		return
+	}
+
+	if err := c.cache.XAck(ctx, c.stream, c.group, message.ID).Err(); err != nil {
+		log.Printf("Unable to acknowledge stream entry %s: %v\n", message.ID, err)
+	}
+}
+
+// deadLetter copies the entry to the <stream>:dead stream for inspection and acknowledges it
+func (c *Consumer) deadLetter(ctx context.Context, id string) {
+	messages, err := c.cache.XRange(ctx, c.stream, id, id).Result()
+	if err == nil && len(messages) > 0 {
+		values := messages[0].Values
+		values["originalId"] = id
+		err = c.cache.XAdd(ctx, &redis.XAddArgs{
+			Stream: c.stream + ":dead",
+			Values: values,
+		}).Err()
+	}
+	if err != nil {
+		log.Printf("Unable to dead letter stream entry %s: %v\n", id, err)
+		return
+	}
+
+	log.Printf("Stream entry %s moved to %s:dead\n", id, c.stream)
+	if err := c.cache.XAck(ctx, c.stream, c.group, id).Err(); err != nil {
+		log.Printf("Unable to acknowledge stream entry %s: %v\n", id, err)
+	}
+}
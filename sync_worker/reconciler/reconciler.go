@@ -0,0 +1,434 @@
+package reconciler
+
+import (
+	"ai-chat/database/services"
+	"ai-chat/database/structures"
+	"ai-chat/utils/helper_functions"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RepairDirection tells the reconciler which side wins when the cache and the database disagree
+type RepairDirection string
+
+const (
+	// RepairNone only reports the differences
+	RepairNone RepairDirection = "none"
+	// RepairCache overwrites the cache with the values stored in Postgres
+	RepairCache RepairDirection = "cache"
+	// RepairDatabase overwrites Postgres with the values stored in the cache
+	RepairDatabase RepairDirection = "database"
+)
+
+const (
+	IssueMissingInDatabase = "missing_in_database"
+	IssueInTrash           = "in_trash"
+	IssueValueMismatch     = "value_mismatch"
+	IssueUnreadable        = "unreadable"
+
+	scanCount      = 500
+	balanceEpsilon = 1e-6
+)
+
+// Difference is one disagreement between a cache key and its database rows
+type Difference struct {
+	Key           string `json:"key"`
+	UserId        string `json:"user_id"`
+	SessionId     string `json:"session_id,omitempty"`
+	Issue         string `json:"issue"`
+	Field         string `json:"field,omitempty"`
+	CacheValue    string `json:"cache_value,omitempty"`
+	DatabaseValue string `json:"database_value,omitempty"`
+	Repaired      bool   `json:"repaired"`
+	RepairError   string `json:"repair_error,omitempty"`
+}
+
+// Report is the result of one reconciliation run
+type Report struct {
+	StartedAt       time.Time       `json:"started_at"`
+	FinishedAt      time.Time       `json:"finished_at"`
+	Direction       RepairDirection `json:"direction"`
+	RepairSkipped   string          `json:"repair_skipped,omitempty"`
+	UsersChecked    int             `json:"users_checked"`
+	SessionsChecked int             `json:"sessions_checked"`
+	Differences     []Difference    `json:"differences"`
+}
+
+// Reconciler compares the user:<id> and user:<id>:session:<id> hashes in Redis against
+// User_Data, Session_Details, Chat_Details, Chat_Messages and File_Metadata in Postgres.
+type Reconciler struct {
+	database *services.Database
+	mutex    sync.Mutex
+}
+
+func NewReconciler(database *services.Database) *Reconciler {
+	return &Reconciler{database: database}
+}
+
+// ParseRepairDirection validates a repair direction, an empty value means RepairNone
+func ParseRepairDirection(value string) (RepairDirection, error) {
+	switch RepairDirection(strings.ToLower(value)) {
+	case "", RepairNone:
+		return RepairNone, nil
+	case RepairCache:
+		return RepairCache, nil
+	case RepairDatabase:
+		return RepairDatabase, nil
+	default:
+		return "", fmt.Errorf("unknown repair direction: %s", value)
+	}
+}
+
+// RunEvery reconciles every RECONCILE_INTERVAL_MINUTES minutes, repairing in the RECONCILE_REPAIR direction,
+// until the context is cancelled. Nothing runs when the interval is not set.
+func (r *Reconciler) RunEvery(ctx context.Context) {
+	interval, err := strconv.Atoi(os.Getenv("RECONCILE_INTERVAL_MINUTES"))
+	if err != nil || interval <= 0 {
+		return
+	}
+
+	direction, err := ParseRepairDirection(os.Getenv("RECONCILE_REPAIR"))
+	if err != nil {
+		log.Println("Reconciler not started:", err)
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := r.Reconcile(ctx, direction)
+			if err != nil {
+				log.Println("Reconciliation failed:", err)
+				continue
+			}
+			r.logReport(report)
+		}
+	}
+}
+
+// Reconcile runs one comparison of the cache against the database and repairs the differences in the given direction.
+// Repairs are skipped while the chat stream still has entries which are not persisted, as they would look like drift.
+func (r *Reconciler) Reconcile(ctx context.Context, direction RepairDirection) (*Report, error) {
+	// runs triggered on demand must not overlap with the periodic ones
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := &Report{
+		StartedAt:   time.Now(),
+		Direction:   direction,
+		Differences: []Difference{},
+	}
+
+	if direction != RepairNone {
+		busy, err := r.streamBusy(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if busy {
+			report.RepairSkipped = "chat stream has entries which are not persisted yet"
+			direction = RepairNone
+		}
+	}
+
+	var cursor uint64
+	for {
+		keys, next, err := r.database.Cache.Scan(ctx, cursor, "user:*", scanCount).Result()
+		if err != nil {
+			return nil, fmt.Errorf("error scanning cache keys: %w", err)
+		}
+
+		for _, key := range keys {
+			parts := strings.Split(key, ":")
+			switch {
+			case len(parts) == 2:
+				report.UsersChecked++
+				report.Differences = append(report.Differences, r.reconcileUser(ctx, key, parts[1], direction)...)
+			case len(parts) == 4 && parts[2] == "session":
+				report.SessionsChecked++
+				report.Differences = append(report.Differences, r.reconcileSession(ctx, key, parts[1], parts[3], direction)...)
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// streamBusy reports whether the consumer group still has unread or unacknowledged entries
+func (r *Reconciler) streamBusy(ctx context.Context) (bool, error) {
+	groups, err := r.database.Cache.XInfoGroups(ctx, os.Getenv("REDIS_STREAM")).Result()
+	if err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return false, nil
+		}
+		return false, fmt.Errorf("error reading stream groups: %w", err)
+	}
+
+	for _, group := range groups {
+		if group.Name == os.Getenv("REDIS_STREAM_GROUP") {
+			return group.Pending > 0 || group.Lag > 0, nil
+		}
+	}
+	// nothing has consumed the stream yet
+	length, err := r.database.Cache.XLen(ctx, os.Getenv("REDIS_STREAM")).Result()
+	if err != nil {
+		return false, fmt.Errorf("error reading stream length: %w", err)
+	}
+	return length > 0, nil
+}
+
+func (r *Reconciler) reconcileUser(ctx context.Context, key, userId string, direction RepairDirection) []Difference {
+	values, err := r.database.Cache.HGetAll(ctx, key).Result()
+	if err != nil {
+		return []Difference{{Key: key, UserId: userId, Issue: IssueUnreadable, RepairError: err.Error()}}
+	}
+
+	user, err := r.database.GetUserRecord(ctx, userId)
+	if err != nil {
+		return []Difference{{Key: key, UserId: userId, Issue: IssueUnreadable, RepairError: err.Error()}}
+	}
+	if user == nil {
+		difference := Difference{Key: key, UserId: userId, Issue: IssueMissingInDatabase}
+		// users are only created in Postgres, a cached user without a row is stale
+		if direction == RepairCache {
+			repair(&difference, r.database.Cache.Del(ctx, key).Err())
+		}
+		return []Difference{difference}
+	}
+
+	var differences []Difference
+	cacheBalance, _ := strconv.ParseFloat(values["balance"], 64)
+	if math.Abs(cacheBalance-user.Balance) > balanceEpsilon {
+		differences = append(differences, mismatch(key, userId, "", "balance", values["balance"], strconv.FormatFloat(user.Balance, 'f', -1, 64)))
+	}
+	if values["models"] != user.Models {
+		differences = append(differences, mismatch(key, userId, "", "models", values["models"], user.Models))
+	}
+	if values["username"] != user.UserName {
+		differences = append(differences, mismatch(key, userId, "", "username", values["username"], user.UserName))
+	}
+	if len(differences) == 0 {
+		return nil
+	}
+
+	var repairErr error
+	switch direction {
+	case RepairCache:
+		repairErr = r.database.CacheUserRecord(ctx, *user)
+	case RepairDatabase:
+		// the cache only owns the balance, models and names are managed in Postgres.
+		// The difference is booked as an adjustment so the ledger still adds up to the balance.
+		if math.Abs(cacheBalance-user.Balance) > balanceEpsilon {
+			repairErr = r.database.AddLedgerEntries(ctx, []structures.LedgerEntry{{
+				EntryId: uuid.New().String(),
+				UserId:  userId,
+				Amount:  cacheBalance - user.Balance,
+				Reason:  structures.LedgerReasonAdjustment,
+			}})
+		}
+		if repairErr == nil {
+			repairErr = r.database.CacheUserRecord(ctx, structures.UserRecord{UserId: userId, UserName: user.UserName, Models: user.Models, Balance: cacheBalance, Tier: user.Tier})
+		}
+	default:
+		return differences
+	}
+	for i := range differences {
+		repair(&differences[i], repairErr)
+	}
+	return differences
+}
+
+func (r *Reconciler) reconcileSession(ctx context.Context, key, userId, sessionId string, direction RepairDirection) []Difference {
+	cached, err := r.database.GetUserSessionData(userId, sessionId)
+	if err != nil {
+		return []Difference{{Key: key, UserId: userId, SessionId: sessionId, Issue: IssueUnreadable, RepairError: err.Error()}}
+	}
+
+	stored, err := r.database.GetSessionRecord(ctx, sessionId)
+	if err != nil {
+		return []Difference{{Key: key, UserId: userId, SessionId: sessionId, Issue: IssueUnreadable, RepairError: err.Error()}}
+	}
+	if stored == nil || stored.UserId != userId {
+		difference := Difference{Key: key, UserId: userId, SessionId: sessionId, Issue: IssueMissingInDatabase}
+		switch direction {
+		case RepairCache:
+			repair(&difference, r.database.Cache.Del(ctx, key).Err())
+		case RepairDatabase:
+			repair(&difference, r.createSession(ctx, userId, cached))
+		}
+		return []Difference{difference}
+	}
+	if stored.DeletedAt != nil {
+		// a chat finishing after the session was deleted cached it again, the deletion wins in both directions
+		difference := Difference{Key: key, UserId: userId, SessionId: sessionId, Issue: IssueInTrash}
+		if direction != RepairNone {
+			repair(&difference, r.database.Cache.Del(ctx, key).Err())
+		}
+		return []Difference{difference}
+	}
+
+	var differences []Difference
+	if cached.SessionName != stored.SessionName {
+		differences = append(differences, mismatch(key, userId, sessionId, "session_name", cached.SessionName, stored.SessionName))
+	}
+	if cached.ModelId != stored.ModelId {
+		differences = append(differences, mismatch(key, userId, sessionId, "model_id", strconv.Itoa(cached.ModelId), strconv.Itoa(stored.ModelId)))
+	}
+	if cached.Prompt != stored.Prompt {
+		differences = append(differences, mismatch(key, userId, sessionId, "session_prompt", cached.Prompt, stored.Prompt))
+	}
+	if cached.ChatSummary != stored.ChatSummary {
+		differences = append(differences, mismatch(key, userId, sessionId, "chat_summary", cached.ChatSummary, stored.ChatSummary))
+	}
+	if cached.Pinned != stored.Pinned {
+		differences = append(differences, mismatch(key, userId, sessionId, "pinned", strconv.FormatBool(cached.Pinned), strconv.FormatBool(stored.Pinned)))
+	}
+	if cached.Archived != stored.Archived {
+		differences = append(differences, mismatch(key, userId, sessionId, "archived", strconv.FormatBool(cached.Archived), strconv.FormatBool(stored.Archived)))
+	}
+	if !sameSet(cached.Tags, stored.Tags) {
+		differences = append(differences, mismatch(key, userId, sessionId, "tags", toJSON(cached.Tags), toJSON(stored.Tags)))
+	}
+	if !sameSet(cached.FileName, stored.FileName) {
+		differences = append(differences, mismatch(key, userId, sessionId, "file_name", toJSON(cached.FileName), toJSON(stored.FileName)))
+	}
+	// the cache only keeps the latest chats of the active branch, they must be the tail of the stored branch
+	storedBranch := helper_functions.BranchPath(stored.Chats, stored.ActiveLeafId)
+	if !isChatTail(cached.Chats, storedBranch) {
+		differences = append(differences, mismatch(key, userId, sessionId, "chats", toJSON(cached.Chats), toJSON(tail(storedBranch, len(cached.Chats)))))
+	}
+	if len(differences) == 0 {
+		return nil
+	}
+
+	var repairErr error
+	switch direction {
+	case RepairCache:
+		repairErr = r.database.CacheSessionRecord(*stored)
+	case RepairDatabase:
+		repairErr = r.database.UpdateSessionRecord(ctx, cached)
+	default:
+		return differences
+	}
+	for i := range differences {
+		// chats can't be rebuilt from the partial cached history
+		if direction == RepairDatabase && differences[i].Field == "chats" {
+			continue
+		}
+		repair(&differences[i], repairErr)
+	}
+	return differences
+}
+
+// createSession stores a session which only exists in the cache
+func (r *Reconciler) createSession(ctx context.Context, userId string, sessionData structures.SessionData) error {
+	if err := r.database.AddSession(ctx, userId, sessionData.SessionId, sessionData.ModelId, sessionData.SessionName); err != nil {
+		return err
+	}
+
+	chats, err := json.Marshal(sessionData.Chats)
+	if err != nil {
+		return err
+	}
+	if sessionData.Chats == nil {
+		chats = []byte("[]")
+	}
+	if err := r.database.AddChat(ctx, sessionData.SessionId, sessionData.Prompt, string(chats), sessionData.ChatSummary, sessionData.ActiveLeafId); err != nil {
+		return err
+	}
+
+	return r.database.UpdateSessionRecord(ctx, sessionData)
+}
+
+func (r *Reconciler) logReport(report *Report) {
+	log.Printf("Reconciliation checked %d users and %d sessions, found %d differences\n",
+		report.UsersChecked, report.SessionsChecked, len(report.Differences))
+	if report.RepairSkipped != "" {
+		log.Println("Reconciliation repair skipped:", report.RepairSkipped)
+	}
+	for _, difference := range report.Differences {
+		data, _ := json.Marshal(difference)
+		log.Println("Reconciliation difference:", string(data))
+	}
+}
+
+func mismatch(key, userId, sessionId, field, cacheValue, databaseValue string) Difference {
+	return Difference{
+		Key:           key,
+		UserId:        userId,
+		SessionId:     sessionId,
+		Issue:         IssueValueMismatch,
+		Field:         field,
+		CacheValue:    cacheValue,
+		DatabaseValue: databaseValue,
+	}
+}
+
+func repair(difference *Difference, err error) {
+	if err != nil {
+		difference.RepairError = err.Error()
+		return
+	}
+	difference.Repaired = true
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isChatTail compares chats by id and content, Postgres stores their timestamps and costs with less precision
+func isChatTail(cached, stored []structures.Chat) bool {
+	if len(cached) > len(stored) {
+		return false
+	}
+	offset := len(stored) - len(cached)
+	for i := range cached {
+		if cached[i].Id != stored[offset+i].Id || cached[i].Content != stored[offset+i].Content {
+			return false
+		}
+	}
+	return true
+}
+
+func tail(chats []structures.Chat, n int) []structures.Chat {
+	if n > len(chats) {
+		return chats
+	}
+	return chats[len(chats)-n:]
+}
+
+func toJSON(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
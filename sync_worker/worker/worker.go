@@ -2,23 +2,51 @@ package worker
 
 import (
 	"ai-chat/database/initialize"
+	"ai-chat/database/structures"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/redis/go-redis/v9"
 	"os"
+	"strconv"
 )
 
 type StreamDataBase struct {
 	Cache *redis.Client
 }
 
+// Kinds of stream entries, entries without a kind are chat turns
+const (
+	EntryKindChat    = "chat"
+	EntryKindSession = "session"
+	EntryKindSummary = "summary"
+)
+
+// StreamEntry is one chat turn published by AddToStream, the new settings of a session published by
+// AddSessionDetailsToStream or a new summary published by AddSummaryToStream
+type StreamEntry struct {
+	Kind          string
+	UserId        string
+	SessionId     string
+	ModelId       int
+	SessionPrompt string
+	Chats         string
+	ChatsSummary  string
+	SessionName   string
+	IsNew         bool
+	Ledger        []structures.LedgerEntry
+	ActiveLeafId  string
+	Details       structures.SessionDetails
+	SummaryLeafId string
+}
+
 func GetStreamDataBase() *StreamDataBase {
 	return &StreamDataBase{
 		Cache: initialize.InitRedis(),
 	}
 }
 
-func (dataBase *StreamDataBase) AddToStream(ctx context.Context, userId string, sessionId string, modelId string, sessionPrompt string, chats string, chatsSummary string, sessionName string, isNew bool, balance float64) error {
+func (dataBase *StreamDataBase) AddToStream(ctx context.Context, userId string, sessionId string, modelId string, sessionPrompt string, chats string, chatsSummary string, sessionName string, isNew bool, ledger string, activeLeafId string) error {
 	var isNewStr string
 	if isNew {
 		isNewStr = "new"
@@ -30,7 +58,7 @@ func (dataBase *StreamDataBase) AddToStream(ctx context.Context, userId string,
 		Stream: os.Getenv("REDIS_STREAM"),
 		MaxLen: 0,
 		ID:     "",
-		Values: []string{"userId", userId, "sessionId", sessionId, "sessionPrompt", sessionPrompt, "modelId", modelId, "chats", chats, "chatsSummary", chatsSummary, "sessionName", sessionName, "isNew", isNewStr, "balance", fmt.Sprintf("%f", balance)},
+		Values: []string{"userId", userId, "sessionId", sessionId, "sessionPrompt", sessionPrompt, "modelId", modelId, "chats", chats, "chatsSummary", chatsSummary, "sessionName", sessionName, "isNew", isNewStr, "ledger", ledger, "activeLeaf", activeLeafId},
 	}).Err()
 	if err != nil {
 		return err
@@ -38,3 +66,86 @@ func (dataBase *StreamDataBase) AddToStream(ctx context.Context, userId string,
 
 	return nil
 }
+
+// AddSessionDetailsToStream publishes the settings of a session along with the ledger entries of generating them,
+// entries are applied in order with the chat turns so they can't reach Postgres before the session itself
+func (dataBase *StreamDataBase) AddSessionDetailsToStream(ctx context.Context, userId string, sessionId string, details structures.SessionDetails, ledger []structures.LedgerEntry) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	ledgerJSON, err := json.Marshal(ledger)
+	if err != nil {
+		return err
+	}
+
+	return dataBase.Cache.XAdd(ctx, &redis.XAddArgs{
+		Stream: os.Getenv("REDIS_STREAM"),
+		Values: []string{"kind", EntryKindSession, "userId", userId, "sessionId", sessionId, "details", string(detailsJSON), "ledger", string(ledgerJSON)},
+	}).Err()
+}
+
+// AddSummaryToStream publishes the summary of a session up to summaryLeafId along with the ledger entries of generating it
+func (dataBase *StreamDataBase) AddSummaryToStream(ctx context.Context, userId string, sessionId string, summary string, summaryLeafId string, ledger []structures.LedgerEntry) error {
+	ledgerJSON, err := json.Marshal(ledger)
+	if err != nil {
+		return err
+	}
+
+	return dataBase.Cache.XAdd(ctx, &redis.XAddArgs{
+		Stream: os.Getenv("REDIS_STREAM"),
+		Values: []string{"kind", EntryKindSummary, "userId", userId, "sessionId", sessionId, "chatsSummary", summary, "summaryLeaf", summaryLeafId, "ledger", string(ledgerJSON)},
+	}).Err()
+}
+
+// ParseStreamEntry converts the values of a stream message written by AddToStream, AddSessionDetailsToStream or
+// AddSummaryToStream back into a StreamEntry
+func ParseStreamEntry(values map[string]interface{}) (StreamEntry, error) {
+	field := func(name string) string {
+		value, _ := values[name].(string)
+		return value
+	}
+
+	entry := StreamEntry{
+		Kind:          field("kind"),
+		UserId:        field("userId"),
+		SessionId:     field("sessionId"),
+		SessionPrompt: field("sessionPrompt"),
+		Chats:         field("chats"),
+		ChatsSummary:  field("chatsSummary"),
+		SessionName:   field("sessionName"),
+		IsNew:         field("isNew") == "new",
+		ActiveLeafId:  field("activeLeaf"),
+		SummaryLeafId: field("summaryLeaf"),
+	}
+	if entry.UserId == "" || entry.SessionId == "" {
+		return StreamEntry{}, fmt.Errorf("stream entry is missing user or session id")
+	}
+
+	if ledger := field("ledger"); ledger != "" {
+		if err := json.Unmarshal([]byte(ledger), &entry.Ledger); err != nil {
+			return StreamEntry{}, fmt.Errorf("error parsing ledger: %w", err)
+		}
+	}
+
+	if entry.Kind == EntryKindSession {
+		if err := json.Unmarshal([]byte(field("details")), &entry.Details); err != nil {
+			return StreamEntry{}, fmt.Errorf("error parsing details: %w", err)
+		}
+		return entry, nil
+	}
+	if entry.Kind == EntryKindSummary {
+		return entry, nil
+	}
+	entry.Kind = EntryKindChat
+
+	var err error
+	if entry.ModelId, err = strconv.Atoi(field("modelId")); err != nil {
+		return StreamEntry{}, fmt.Errorf("error parsing modelId: %w", err)
+	}
+	if entry.Chats == "" {
+		entry.Chats = "[]"
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"os"
+	"strings"
+	"time"
+)
+
+// Tokens are HS256 signed JWTs issued by the login service with the user id in the "sub" claim.
+// The signing key is shared through AUTH_SECRET_KEY.
+
+func secretKey() ([]byte, error) {
+	key := os.Getenv("AUTH_SECRET_KEY")
+	if key == "" {
+		return nil, errors.New("AUTH_SECRET_KEY is not configured")
+	}
+	return []byte(key), nil
+}
+
+// ValidateToken verifies the signature and expiry of the token and returns the user id it was issued for.
+func ValidateToken(tokenString string) (string, error) {
+	key, err := secretKey()
+	if err != nil {
+		return "", err
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", fmt.Errorf("invalid token: %w", err)
+	}
+
+	userId, err := token.Claims.GetSubject()
+	if err != nil || userId == "" {
+		return "", errors.New("invalid token: missing subject")
+	}
+	return userId, nil
+}
+
+// GenerateToken signs a token for the user which expires after ttl.
+func GenerateToken(userId string, ttl time.Duration) (string, error) {
+	key, err := secretKey()
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.RegisteredClaims{
+		Subject:   userId,
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+// ExtractToken returns the bearer token from an Authorization header value.
+func ExtractToken(authorization string) string {
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/golang-jwt/jwt/v5"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret"
+
+func signedToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestValidateToken(t *testing.T) {
+	t.Setenv("AUTH_SECRET_KEY", testSecret)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := jwt.RegisteredClaims{Subject: "user-1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+	expired := jwt.RegisteredClaims{Subject: "user-1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))}
+	noSubject := jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+	noExpiry := jwt.RegisteredClaims{Subject: "user-1"}
+
+	generated, err := GenerateToken("user-2", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		want    string
+		wantErr bool
+	}{
+		{"valid", signedToken(t, jwt.SigningMethodHS256, []byte(testSecret), valid), "user-1", false},
+		{"generated", generated, "user-2", false},
+		{"expired", signedToken(t, jwt.SigningMethodHS256, []byte(testSecret), expired), "", true},
+		{"without expiry", signedToken(t, jwt.SigningMethodHS256, []byte(testSecret), noExpiry), "", true},
+		{"alg none", signedToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid), "", true},
+		{"RS256", signedToken(t, jwt.SigningMethodRS256, rsaKey, valid), "", true},
+		{"HS512", signedToken(t, jwt.SigningMethodHS512, []byte(testSecret), valid), "", true},
+		{"missing sub", signedToken(t, jwt.SigningMethodHS256, []byte(testSecret), noSubject), "", true},
+		{"wrong secret", signedToken(t, jwt.SigningMethodHS256, []byte("other-secret"), valid), "", true},
+		{"malformed", "not.a.token", "", true},
+		{"empty", "", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			userId, err := ValidateToken(test.token)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ValidateToken() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if userId != test.want {
+				t.Errorf("ValidateToken() = %q, want %q", userId, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateTokenWithoutSecret(t *testing.T) {
+	t.Setenv("AUTH_SECRET_KEY", testSecret)
+	token, err := GenerateToken("user-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("AUTH_SECRET_KEY", "")
+	if _, err := ValidateToken(token); err == nil {
+		t.Error("ValidateToken() succeeded without AUTH_SECRET_KEY")
+	}
+}
+
+func TestExtractToken(t *testing.T) {
+	tests := []struct {
+		authorization string
+		want          string
+	}{
+		{"Bearer abc.def.ghi", "abc.def.ghi"},
+		{"bearer abc.def.ghi", "abc.def.ghi"},
+		{"BEARER   abc.def.ghi  ", "abc.def.ghi"},
+		{"abc.def.ghi", ""},
+		{"Basic dXNlcjpwYXNz", ""},
+		{"Bearer", ""},
+		{"Bearer ", ""},
+		{"Bearerabc.def.ghi", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := ExtractToken(test.authorization); got != test.want {
+			t.Errorf("ExtractToken(%q) = %q, want %q", test.authorization, got, test.want)
+		}
+	}
+}
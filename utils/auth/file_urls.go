@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// File URLs carry an expiry and an HMAC-SHA256 signature over the file, the name it is downloaded as and the
+// expiry, so they can be handed to clients and the AI service without a token. The key is FILE_URL_SECRET_KEY,
+// AUTH_SECRET_KEY when it isn't set.
+
+var (
+	// ErrInvalidFileSignature is returned for a file URL which wasn't signed with the key or was altered
+	ErrInvalidFileSignature = errors.New("invalid file signature")
+	// ErrFileURLExpired is returned for a file URL whose expiry has passed
+	ErrFileURLExpired = errors.New("file url expired")
+)
+
+func fileURLKey() ([]byte, error) {
+	if key := os.Getenv("FILE_URL_SECRET_KEY"); key != "" {
+		return []byte(key), nil
+	}
+	return secretKey()
+}
+
+func fileSignature(key []byte, fileName string, downloadName string, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "file\n%s\n%s\n%d", fileName, downloadName, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignedFileURL returns the URL of the file below baseURL which stays valid for ttl. downloadName is the name the
+// file is saved as, the stored name when empty.
+func SignedFileURL(baseURL string, fileName string, downloadName string, ttl time.Duration) (string, error) {
+	key, err := fileURLKey()
+	if err != nil {
+		return "", err
+	}
+
+	expires := time.Now().Add(ttl).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	if downloadName != "" {
+		query.Set("name", downloadName)
+	}
+	query.Set("signature", fileSignature(key, fileName, downloadName, expires))
+	return fmt.Sprintf("%s/%s?%s", baseURL, url.PathEscape(fileName), query.Encode()), nil
+}
+
+// VerifyFileURL checks the expiry and signature of a file URL given as its query parameters
+func VerifyFileURL(fileName string, downloadName string, expires string, signature string) error {
+	key, err := fileURLKey()
+	if err != nil {
+		return err
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidFileSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(fileSignature(key, fileName, downloadName, expiresAt))) {
+		return ErrInvalidFileSignature
+	}
+	if time.Now().Unix() > expiresAt {
+		return ErrFileURLExpired
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// signedFileQuery signs a URL for the file and returns its path and query parameters
+func signedFileQuery(t *testing.T, fileName string, downloadName string, ttl time.Duration) (string, url.Values) {
+	t.Helper()
+	signed, err := SignedFileURL("http://localhost/uploads", fileName, downloadName, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Path, parsed.Query()
+}
+
+func TestSignedFileURL(t *testing.T) {
+	t.Setenv("FILE_URL_SECRET_KEY", "file-key")
+
+	path, query := signedFileQuery(t, "abc 1.pdf", "report.pdf", time.Minute)
+	if path != "/uploads/abc 1.pdf" {
+		t.Errorf("path = %q", path)
+	}
+	if query.Get("name") != "report.pdf" || query.Get("signature") == "" {
+		t.Errorf("query = %v", query)
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		t.Fatalf("expires = %q", query.Get("expires"))
+	}
+	if left := time.Until(time.Unix(expires, 0)); left <= 0 || left > time.Minute {
+		t.Errorf("expires in %v, want within a minute", left)
+	}
+
+	// without a download name the file keeps its stored name
+	if _, query := signedFileQuery(t, "abc.pdf", "", time.Minute); query.Has("name") {
+		t.Errorf("query without a download name = %v", query)
+	}
+}
+
+func TestVerifyFileURL(t *testing.T) {
+	t.Setenv("FILE_URL_SECRET_KEY", "file-key")
+
+	_, query := signedFileQuery(t, "abc.pdf", "report.pdf", time.Minute)
+	_, expiredQuery := signedFileQuery(t, "abc.pdf", "report.pdf", -time.Minute)
+	_, unnamedQuery := signedFileQuery(t, "abc.pdf", "", time.Minute)
+	later := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name         string
+		fileName     string
+		downloadName string
+		expires      string
+		signature    string
+		want         error
+	}{
+		{"valid", "abc.pdf", "report.pdf", query.Get("expires"), query.Get("signature"), nil},
+		{"valid without name", "abc.pdf", "", unnamedQuery.Get("expires"), unnamedQuery.Get("signature"), nil},
+		{"other file", "other.pdf", "report.pdf", query.Get("expires"), query.Get("signature"), ErrInvalidFileSignature},
+		{"other download name", "abc.pdf", "invoice.pdf", query.Get("expires"), query.Get("signature"), ErrInvalidFileSignature},
+		{"download name added", "abc.pdf", "report.pdf", unnamedQuery.Get("expires"), unnamedQuery.Get("signature"), ErrInvalidFileSignature},
+		{"expiry extended", "abc.pdf", "report.pdf", later, query.Get("signature"), ErrInvalidFileSignature},
+		{"expired", "abc.pdf", "report.pdf", expiredQuery.Get("expires"), expiredQuery.Get("signature"), ErrFileURLExpired},
+		{"malformed expiry", "abc.pdf", "report.pdf", "soon", query.Get("signature"), ErrInvalidFileSignature},
+		{"malformed signature", "abc.pdf", "report.pdf", query.Get("expires"), "not-a-signature", ErrInvalidFileSignature},
+		{"truncated signature", "abc.pdf", "report.pdf", query.Get("expires"), query.Get("signature")[:10], ErrInvalidFileSignature},
+		{"missing signature", "abc.pdf", "report.pdf", query.Get("expires"), "", ErrInvalidFileSignature},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyFileURL(test.fileName, test.downloadName, test.expires, test.signature)
+			if !errors.Is(err, test.want) {
+				t.Errorf("VerifyFileURL() error = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyFileURLKey(t *testing.T) {
+	t.Setenv("FILE_URL_SECRET_KEY", "")
+	t.Setenv("AUTH_SECRET_KEY", testSecret)
+
+	// AUTH_SECRET_KEY signs the URLs when FILE_URL_SECRET_KEY isn't set
+	_, query := signedFileQuery(t, "abc.pdf", "", time.Minute)
+	if err := VerifyFileURL("abc.pdf", "", query.Get("expires"), query.Get("signature")); err != nil {
+		t.Errorf("VerifyFileURL() with AUTH_SECRET_KEY error = %v", err)
+	}
+
+	t.Setenv("FILE_URL_SECRET_KEY", "file-key")
+	if err := VerifyFileURL("abc.pdf", "", query.Get("expires"), query.Get("signature")); !errors.Is(err, ErrInvalidFileSignature) {
+		t.Errorf("VerifyFileURL() with another key error = %v, want ErrInvalidFileSignature", err)
+	}
+
+	t.Setenv("FILE_URL_SECRET_KEY", "")
+	t.Setenv("AUTH_SECRET_KEY", "")
+	if _, err := SignedFileURL("http://localhost/uploads", "abc.pdf", "", time.Minute); err == nil {
+		t.Error("SignedFileURL() succeeded without a key")
+	}
+	if err := VerifyFileURL("abc.pdf", "", query.Get("expires"), query.Get("signature")); err == nil {
+		t.Error("VerifyFileURL() succeeded without a key")
+	}
+}
@@ -0,0 +1,250 @@
+package documents
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/net/html"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrUnsupportedFormat is returned for files whose text can't be extracted
+var ErrUnsupportedFormat = errors.New("unsupported document format")
+
+// Page is the text of a page of a PDF, other documents are a single page with Number 0
+type Page struct {
+	Number int
+	Text   string
+}
+
+// Chunk is a piece of a document small enough to be embedded, Page is the page it starts on
+type Chunk struct {
+	Index   int
+	Page    int
+	Content string
+}
+
+var extractors = map[string]func(data []byte) ([]Page, error){
+	".txt":      extractPlainText,
+	".text":     extractPlainText,
+	".md":       extractPlainText,
+	".markdown": extractPlainText,
+	".csv":      extractCSV,
+	".json":     extractJSON,
+	".html":     extractHTML,
+	".htm":      extractHTML,
+	".pdf":      extractPDF,
+}
+
+// Supported reports whether the text of the file can be extracted, judging by its extension
+func Supported(fileName string) bool {
+	_, ok := extractors[strings.ToLower(filepath.Ext(fileName))]
+	return ok
+}
+
+// Extract reads the text of the file from reader, the format is chosen by the extension of fileName
+func Extract(reader io.Reader, fileName string) ([]Page, error) {
+	extract, ok := extractors[strings.ToLower(filepath.Ext(fileName))]
+	if !ok {
+		return nil, ErrUnsupportedFormat
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return extract(data)
+}
+
+func extractPlainText(data []byte) ([]Page, error) {
+	return []Page{{Text: strings.ToValidUTF8(string(data), "")}}, nil
+}
+
+// extractCSV writes every row as "column: value" pairs, so each chunk keeps the meaning of its values
+func extractCSV(data []byte) ([]Page, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return []Page{{}}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read csv: %w", err)
+	}
+
+	var text strings.Builder
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read csv: %w", err)
+		}
+
+		fields := make([]string, 0, len(record))
+		for i, value := range record {
+			column := fmt.Sprintf("column %d", i+1)
+			if i < len(header) && strings.TrimSpace(header[i]) != "" {
+				column = strings.TrimSpace(header[i])
+			}
+			fields = append(fields, column+": "+strings.TrimSpace(value))
+		}
+		text.WriteString(strings.Join(fields, ", "))
+		text.WriteString("\n")
+	}
+	return []Page{{Text: strings.ToValidUTF8(text.String(), "")}}, nil
+}
+
+// extractJSON writes every value on its own line after its path, such as items[0].name: value
+func extractJSON(data []byte) ([]Page, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to parse json: %w", err)
+	}
+
+	var text strings.Builder
+	writeJSONValue(&text, "", value)
+	return []Page{{Text: text.String()}}, nil
+}
+
+func writeJSONValue(text *strings.Builder, path string, value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			writeJSONValue(text, childPath, value[key])
+		}
+	case []interface{}:
+		for i, child := range value {
+			writeJSONValue(text, fmt.Sprintf("%s[%d]", path, i), child)
+		}
+	default:
+		if path != "" {
+			text.WriteString(path + ": ")
+		}
+		text.WriteString(fmt.Sprint(value))
+		text.WriteString("\n")
+	}
+}
+
+// htmlSkipped are the elements whose content isn't text of the page
+var htmlSkipped = map[string]bool{"script": true, "style": true, "noscript": true, "template": true, "svg": true}
+
+// htmlBlocks are the elements which start a new line
+var htmlBlocks = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "section": true, "article": true, "header": true, "footer": true, "pre": true,
+	"blockquote": true, "table": true, "ul": true, "ol": true, "title": true,
+}
+
+func extractHTML(data []byte) ([]Page, error) {
+	root, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse html: %w", err)
+	}
+
+	var text strings.Builder
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && htmlSkipped[node.Data] {
+			return
+		}
+		if node.Type == html.TextNode {
+			if content := strings.Join(strings.Fields(node.Data), " "); content != "" {
+				text.WriteString(content)
+				text.WriteString(" ")
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		if node.Type == html.ElementNode && htmlBlocks[node.Data] {
+			text.WriteString("\n")
+		}
+	}
+	walk(root)
+	return []Page{{Text: strings.ToValidUTF8(text.String(), "")}}, nil
+}
+
+func extractPDF(data []byte) ([]Page, error) {
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pdf: %w", err)
+	}
+
+	var pages []Page
+	for number := 1; number <= reader.NumPage(); number++ {
+		page := reader.Page(number)
+		if page.V.IsNull() {
+			continue
+		}
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page %d: %w", number, err)
+		}
+		pages = append(pages, Page{Number: number, Text: strings.ToValidUTF8(text, "")})
+	}
+	return pages, nil
+}
+
+// Split cuts the pages into chunks of at most size characters, each one repeating the last overlap characters of
+// the chunk before it. Chunks end at a blank line, a line end or a space where one is close enough to the limit.
+func Split(pages []Page, size int, overlap int) []Chunk {
+	if overlap >= size {
+		overlap = size / 5
+	}
+
+	var chunks []Chunk
+	for _, page := range pages {
+		runes := []rune(strings.TrimSpace(page.Text))
+		for start := 0; start < len(runes); {
+			end := min(start+size, len(runes))
+			if end < len(runes) {
+				end = breakPoint(runes, start+size/2, end)
+			}
+
+			if content := strings.TrimSpace(string(runes[start:end])); content != "" {
+				chunks = append(chunks, Chunk{Index: len(chunks), Page: page.Number, Content: content})
+			}
+			if end == len(runes) {
+				break
+			}
+			start = max(end-overlap, start+1)
+			// the overlap starts at a word
+			for start < end && !unicode.IsSpace(runes[start-1]) {
+				start++
+			}
+		}
+	}
+	return chunks
+}
+
+// breakPoint returns the end of a chunk between from and to, preferring paragraphs over lines over words
+func breakPoint(runes []rune, from int, to int) int {
+	for _, separator := range []string{"\n\n", "\n", " "} {
+		text := string(runes[from:to])
+		if i := strings.LastIndex(text, separator); i >= 0 {
+			return from + utf8.RuneCountInString(text[:i]) + utf8.RuneCountInString(separator)
+		}
+	}
+	return to
+}
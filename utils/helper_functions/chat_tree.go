@@ -0,0 +1,168 @@
+package helper_functions
+
+import (
+	"ai-chat/database/structures"
+	"crypto/md5"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// LegacyChatId is the id of a chat stored before chats had ids, derived from the session and its position (1 based)
+// in the stored chats. It's the same value as md5('<session_id>:<position>')::uuid in Postgres.
+func LegacyChatId(sessionId string, position int) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%s:%d", sessionId, position)))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// EnsureChatIds gives the chats of a session stored before chats had ids their legacy id, each one following
+// the chat stored before it. chats must be all stored chats of the session in the order they were stored.
+func EnsureChatIds(sessionId string, chats []structures.Chat) {
+	for i := range chats {
+		if chats[i].Id != "" {
+			continue
+		}
+		chats[i].Id = LegacyChatId(sessionId, i+1)
+		if i > 0 && chats[i].ParentId == "" {
+			chats[i].ParentId = chats[i-1].Id
+		}
+	}
+}
+
+// BranchPath returns the chats from the start of the session down to leafId. An empty or unknown leafId
+// selects the chat written last.
+func BranchPath(chats []structures.Chat, leafId string) []structures.Chat {
+	if len(chats) == 0 {
+		return nil
+	}
+
+	byId := make(map[string]structures.Chat, len(chats))
+	for _, chat := range chats {
+		byId[chat.Id] = chat
+	}
+	if _, ok := byId[leafId]; !ok {
+		leafId = chats[len(chats)-1].Id
+	}
+
+	var path []structures.Chat
+	for id := leafId; id != ""; {
+		chat, ok := byId[id]
+		if !ok {
+			break
+		}
+		path = append(path, chat)
+		id = chat.ParentId
+		// a broken chain must not loop forever
+		if len(path) > len(chats) {
+			break
+		}
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// LatestLeaf follows the reply written last from messageId down to the end of its branch
+func LatestLeaf(chats []structures.Chat, messageId string) string {
+	latestChild := make(map[string]string, len(chats))
+	for _, chat := range chats {
+		latestChild[chat.ParentId] = chat.Id
+	}
+
+	leafId := messageId
+	for steps := 0; steps < len(chats); steps++ {
+		child, ok := latestChild[leafId]
+		if !ok {
+			break
+		}
+		leafId = child
+	}
+	return leafId
+}
+
+// FindChat looks a chat up by id
+func FindChat(chats []structures.Chat, id string) (structures.Chat, bool) {
+	for _, chat := range chats {
+		if chat.Id == id {
+			return chat, true
+		}
+	}
+	return structures.Chat{}, false
+}
+
+// Branches returns, for every chat on path which has alternatives, the ids of all chats sharing its parent
+func Branches(chats []structures.Chat, path []structures.Chat) map[string][]string {
+	children := make(map[string][]string)
+	for _, chat := range chats {
+		children[chat.ParentId] = append(children[chat.ParentId], chat.Id)
+	}
+
+	branches := make(map[string][]string)
+	for _, chat := range path {
+		if siblings := children[chat.ParentId]; len(siblings) > 1 {
+			branches[chat.Id] = siblings
+		}
+	}
+	return branches
+}
+
+// FoldFileChats turns the {"role": "file"} chats files used to be recorded with into attachments of the message
+// they were sent with, which is the parent of the reply they follow. Chats following a file chat follow the reply
+// instead, as does leafId, which is returned along with the remaining chats.
+func FoldFileChats(chats []structures.Chat, leafId string) ([]structures.Chat, string) {
+	index := make(map[string]int, len(chats))
+	for i, chat := range chats {
+		index[chat.Id] = i
+	}
+
+	replacedBy := make(map[string]string)
+	folded := make([]structures.Chat, 0, len(chats))
+	for _, chat := range chats {
+		if chat.Role != "file" {
+			continue
+		}
+		replacedBy[chat.Id] = chat.ParentId
+		if reply, ok := index[chat.ParentId]; ok {
+			if message, ok := index[chats[reply].ParentId]; ok {
+				chats[message].Attachments = append(chats[message].Attachments, chat.Content)
+			}
+		}
+	}
+	if len(replacedBy) == 0 {
+		return chats, leafId
+	}
+
+	for _, chat := range chats {
+		if chat.Role == "file" {
+			continue
+		}
+		if parentId, ok := replacedBy[chat.ParentId]; ok {
+			chat.ParentId = parentId
+		}
+		folded = append(folded, chat)
+	}
+	if parentId, ok := replacedBy[leafId]; ok {
+		leafId = parentId
+	}
+	return folded, leafId
+}
+
+// MaxCachedChats is the most chats of the active branch kept in the cache, read from MAX_CHAT_HISTORY_CONTEXT.
+// 0 keeps the whole branch; the history sent to a model is trimmed to its context window either way.
+func MaxCachedChats() int {
+	limit, err := strconv.Atoi(os.Getenv("MAX_CHAT_HISTORY_CONTEXT"))
+	if err != nil || limit <= 0 {
+		return 0
+	}
+	return limit
+}
+
+// LatestChats returns the last MaxCachedChats of chats
+func LatestChats(chats []structures.Chat) []structures.Chat {
+	if limit := MaxCachedChats(); limit > 0 && len(chats) > limit {
+		return chats[len(chats)-limit:]
+	}
+	return chats
+}
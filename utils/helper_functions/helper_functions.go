@@ -4,89 +4,60 @@ import (
 	"ai-chat/database/structures"
 	"ai-chat/utils/model_data"
 	"fmt"
-	"github.com/pkoukk/tiktoken-go"
-	"log"
 	"strings"
 )
 
 func EstimateOpenAIAPICost(model string, numTokensInput, numTokensOutput int) (float64, error) {
-	pricing, ok := model_data.ModelPricing[model]
+	pricing, ok := model_data.GetModel(model)
 	if !ok {
 		return 0, fmt.Errorf("unknown model: %s", model)
 	}
 
 	var inputCost, outputCost, totalCost float64
 
-	inputCost = (float64(numTokensInput) / 1000) * pricing.Input
-	outputCost = (float64(numTokensOutput) / 1000) * pricing.Output
+	inputCost = (float64(numTokensInput) / 1000) * pricing.InputPrice
+	outputCost = (float64(numTokensOutput) / 1000) * pricing.OutputPrice
 	totalCost = inputCost + outputCost
 
 	return totalCost, nil
 }
 
-// Function to simulate token counting.
-func countTokens(content string, model string) (int, error) {
-	tkm, err := tiktoken.EncodingForModel(model)
+// EstimateMaxCost is the most a chat turn can cost: the session prompt, summary, history and message as input
+// with maxOutputTokens of output. Summaries and titles are held and charged by their jobs.
+func EstimateMaxCost(sessionData structures.SessionData, message string, maxOutputTokens int) (float64, error) {
+	model := model_data.ModelName(sessionData.ModelId)
+
+	inputTokens, err := countSessionTokens(sessionData, message, TokenizerFor(model))
 	if err != nil {
-		err = fmt.Errorf("encoding for model: %v", err)
-		log.Println(err)
 		return 0, err
 	}
 
-	var tokensPerMessage = 4 // every message follows <|start|>{role/name}\n{content}<|end|>\n
-	var tokensPerName = -1   // if there's a name, the role is omitted
-
-	return len(tkm.Encode(content, nil, nil)) + tokensPerMessage + tokensPerName + 4, nil
+	return EstimateOpenAIAPICost(model, inputTokens, maxOutputTokens)
 }
 
-// Ensure the sessionData.Chats fit within the token limit.
-func LimitTokenSize(sessionData *structures.SessionData, maxTokens int) error {
-	// To make sure proper token encoding get applied
-	model := model_data.ModelName(sessionData.ModelId)
-	if strings.Contains(model, "gpt-4o") {
-		model = "gpt-4o"
-	} else if strings.Contains(model, "gpt-4") || strings.Contains(model, "gpt-3") {
-		model = "gpt-3.5-turbo"
-	} else if strings.Contains(model, "text-davinci-003") || strings.Contains(model, "text-davinci-002") {
-		model = "text-davinci-002"
-	} else {
-		model = "text-davinci-001"
+// EstimatePromptCost is the most a single prompt to model can cost with maxOutputTokens of output
+func EstimatePromptCost(model string, prompt string, maxOutputTokens int) (float64, error) {
+	inputTokens, err := countSessionTokens(structures.SessionData{}, prompt, TokenizerFor(model))
+	if err != nil {
+		return 0, err
 	}
 
-	totalTokens := 0
-	startIndex := len(sessionData.Chats)
-
-	// Count tokens from the end to start and find the index where tokens exceed the limit.
-	for i := len(sessionData.Chats) - 1; i >= 0; i-- {
-		chat := sessionData.Chats[i]
-
-		var contentTokens, roleTokens int
-		var err error
-		if contentTokens, err = countTokens(chat.Content+" content", model); err != nil {
-			return err
-		}
-
-		if roleTokens, err = countTokens(chat.Role+" role", model); err != nil {
-			return err
-		}
+	return EstimateOpenAIAPICost(model, inputTokens, maxOutputTokens)
+}
 
-		tokens := contentTokens + roleTokens // Sum tokens of content and role.
+// DefaultSessionName names sessions which were started without a message, such as file uploads
+const DefaultSessionName = "New Chat"
 
-		if totalTokens+tokens > maxTokens {
-			break
-		}
-		totalTokens += tokens
-		startIndex = i
+// TruncateText shortens s to at most max characters, cutting at the last space when there is one
+func TruncateText(s string, max int) string {
+	runes := []rune(strings.TrimSpace(s))
+	if len(runes) <= max {
+		return string(runes)
 	}
 
-	// Slice the array to contain only the latest fitting entries.
-	sessionData.Chats = sessionData.Chats[startIndex:]
-	return nil
-}
-
-func TruncateText(s string, max int) string {
-	if max > len(s) {
-		return s
+	cut := string(runes[:max])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
 	}
-	return s[:strings.LastIndex(s[:max], " ")]
+	return strings.TrimSpace(cut)
 }
@@ -0,0 +1,167 @@
+package helper_functions
+
+import (
+	"ai-chat/database/structures"
+	"ai-chat/utils/model_data"
+	"errors"
+	"fmt"
+	"github.com/pkoukk/tiktoken-go"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMaxOutputTokens = 1024
+
+	// every message follows <|start|>{role/name}\n{content}<|end|>\n
+	tokensPerMessage = 4
+	// llama style tokenizers average about four bytes a token on English text, three keeps the estimate on the safe side
+	approximateBytesPerToken = 3
+)
+
+// ErrMessageTooLong is returned when a message alone doesn't fit the context window of its model
+var ErrMessageTooLong = errors.New("message does not fit the context window of the model")
+
+// Tokenizer counts the tokens of a text the way a model does
+type Tokenizer func(text string) (int, error)
+
+// MaxOutputTokens is the number of tokens a reply may use, read from MAX_OUTPUT_TOKENS
+func MaxOutputTokens() int {
+	tokens, err := strconv.Atoi(os.Getenv("MAX_OUTPUT_TOKENS"))
+	if err != nil || tokens <= 0 {
+		return defaultMaxOutputTokens
+	}
+	return tokens
+}
+
+// TokenizerFor picks the tokenizer of a model: tiktoken for OpenAI models, an approximation for everything else
+func TokenizerFor(modelName string) Tokenizer {
+	provider := model_data.GetModelProvider(modelName)
+	if provider == "openai" || provider == "azure" || strings.HasPrefix(modelName, "gpt-") {
+		encoding := encodingModel(modelName)
+		return func(text string) (int, error) {
+			return countTokens(text, encoding)
+		}
+	}
+	return approximateTokens
+}
+
+// CountTokens counts the tokens of text with the tokenizer of the model
+func CountTokens(text string, modelName string) (int, error) {
+	return TokenizerFor(modelName)(text)
+}
+
+// FitContextWindow returns the latest chats of the session which fit the context length of its model together with
+// the session prompt, summary and message, leaving maxOutputTokens for the reply. ErrMessageTooLong is returned
+// when not even the message fits. Models without a known context length get the history unchanged.
+func FitContextWindow(sessionData structures.SessionData, message string, maxOutputTokens int) ([]structures.Chat, error) {
+	model := model_data.ModelName(sessionData.ModelId)
+	contextLength := model_data.ModelContextLength(sessionData.ModelId)
+	if contextLength <= 0 {
+		return sessionData.Chats, nil
+	}
+
+	tokenizer := TokenizerFor(model)
+	fixedTokens, err := countSessionTokens(structures.SessionData{Prompt: sessionData.Prompt, ChatSummary: sessionData.ChatSummary}, message, tokenizer)
+	if err != nil {
+		return nil, err
+	}
+
+	budget := contextLength - maxOutputTokens - fixedTokens
+	if budget < 0 {
+		return nil, ErrMessageTooLong
+	}
+
+	if err := LimitTokenSize(&sessionData, budget); err != nil {
+		return nil, err
+	}
+	return sessionData.Chats, nil
+}
+
+// LimitTokenSize drops the oldest sessionData.Chats until the rest fit within maxTokens.
+func LimitTokenSize(sessionData *structures.SessionData, maxTokens int) error {
+	tokenizer := TokenizerFor(model_data.ModelName(sessionData.ModelId))
+
+	totalTokens := 0
+	startIndex := len(sessionData.Chats)
+
+	// Count tokens from the end to start and find the index where tokens exceed the limit.
+	for i := len(sessionData.Chats) - 1; i >= 0; i-- {
+		tokens, err := countChatTokens(sessionData.Chats[i], tokenizer)
+		if err != nil {
+			return err
+		}
+
+		if totalTokens+tokens > maxTokens {
+			break
+		}
+		totalTokens += tokens
+		startIndex = i
+	}
+
+	// Slice the array to contain only the latest fitting entries.
+	sessionData.Chats = sessionData.Chats[startIndex:]
+	return nil
+}
+
+// countSessionTokens counts the tokens of everything sent to the model for a message
+func countSessionTokens(sessionData structures.SessionData, message string, tokenizer Tokenizer) (int, error) {
+	total := 0
+	for _, chat := range []structures.Chat{{Role: "system", Content: sessionData.Prompt}, {Role: "system", Content: sessionData.ChatSummary}, {Role: "user", Content: message}} {
+		tokens, err := countChatTokens(chat, tokenizer)
+		if err != nil {
+			return 0, err
+		}
+		total += tokens
+	}
+	for _, chat := range sessionData.Chats {
+		tokens, err := countChatTokens(chat, tokenizer)
+		if err != nil {
+			return 0, err
+		}
+		total += tokens
+	}
+	return total, nil
+}
+
+func countChatTokens(chat structures.Chat, tokenizer Tokenizer) (int, error) {
+	contentTokens, err := tokenizer(chat.Content)
+	if err != nil {
+		return 0, err
+	}
+	roleTokens, err := tokenizer(chat.Role)
+	if err != nil {
+		return 0, err
+	}
+	return contentTokens + roleTokens + tokensPerMessage, nil
+}
+
+// encodingModel maps a model to the model name tiktoken knows the encoding of
+func encodingModel(model string) string {
+	if strings.Contains(model, "gpt-4o") {
+		return "gpt-4o"
+	} else if strings.Contains(model, "gpt-4") || strings.Contains(model, "gpt-3") {
+		return "gpt-3.5-turbo"
+	} else if strings.Contains(model, "text-davinci-003") || strings.Contains(model, "text-davinci-002") {
+		return "text-davinci-002"
+	}
+	return "text-davinci-001"
+}
+
+func countTokens(content string, model string) (int, error) {
+	tkm, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		err = fmt.Errorf("encoding for model: %v", err)
+		log.Println(err)
+		return 0, err
+	}
+
+	return len(tkm.Encode(content, nil, nil)), nil
+}
+
+func approximateTokens(text string) (int, error) {
+	return int(math.Ceil(float64(len(text)) / approximateBytesPerToken)), nil
+}
@@ -1,5 +1,11 @@
 package model_data
 
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
 const (
 	GPTTurbo125      = 0
 	GPTTurbo         = 1
@@ -12,97 +18,134 @@ const (
 	LLAMA8B          = 8
 )
 
-var ProviderModelMapping = map[string]string{
-	"gpt-3.5-turbo-0125":     "openai",
-	"gpt-3.5-turbo":          "openai",
-	"gpt-3.5-turbo-1106":     "openai",
-	"gpt-3.5-turbo-instruct": "openai",
-	"gpt-4-turbo":            "openai",
-	"gpt-4-turbo-2024-04-09": "openai",
-	"gpt-4":                  "openai",
-	"gpt-4-32k":              "openai",
-	"llama3.1:8b":            "ollama",
+var (
+	ErrUnknownModel  = errors.New("unknown model")
+	ErrModelDisabled = errors.New("model is disabled")
+)
+
+// Model is one entry of the model registry, stored in Model_Details. Prices are per 1K tokens.
+type Model struct {
+	Id            int      `json:"id" db:"model_id"`
+	Name          string   `json:"name" db:"model_name"`
+	Provider      string   `json:"provider" db:"provider"`
+	ContextLength int      `json:"context_length" db:"context_length"`
+	InputPrice    float64  `json:"input_price" db:"input_price"`
+	OutputPrice   float64  `json:"output_price" db:"output_price"`
+	Capabilities  []string `json:"capabilities" db:"capabilities"`
+	Enabled       bool     `json:"enabled" db:"enabled"`
+	// RateLimitPerMinute is how many messages a user may send to the model per minute, 0 means no limit
+	RateLimitPerMinute int `json:"rate_limit_per_minute" db:"rate_limit_per_minute"`
 }
 
-var ModelNumberMapping = map[int]string{
-	0: "gpt-3.5-turbo-0125",
-	1: "gpt-3.5-turbo",
-	2: "gpt-3.5-turbo-1106",
-	3: "gpt-3.5-turbo-instruct",
-	4: "gpt-4-turbo",
-	5: "gpt-4-turbo-2024-04-09",
-	6: "gpt-4",
-	7: "gpt-4-32k",
-	8: "llama3.1:8b",
+// DefaultModels seeds Model_Details the first time the service starts on an empty database
+var DefaultModels = []Model{
+	{Id: GPTTurbo125, Name: "gpt-3.5-turbo-0125", Provider: "openai", ContextLength: 16385, InputPrice: 0.00050, OutputPrice: 0.00150, Capabilities: []string{"chat", "streaming"}, Enabled: true},
+	{Id: GPTTurbo, Name: "gpt-3.5-turbo", Provider: "openai", ContextLength: 16385, InputPrice: 0.0030, OutputPrice: 0.0060, Capabilities: []string{"chat", "streaming"}, Enabled: true},
+	{Id: GPTTurbo1106, Name: "gpt-3.5-turbo-1106", Provider: "openai", ContextLength: 16385, InputPrice: 0.0010, OutputPrice: 0.0020, Capabilities: []string{"chat", "streaming"}, Enabled: true},
+	{Id: GPTTurboInstruct, Name: "gpt-3.5-turbo-instruct", Provider: "openai", ContextLength: 4096, InputPrice: 0.00150, OutputPrice: 0.00200, Capabilities: []string{"completion"}, Enabled: true},
+	{Id: GPT4Turbo, Name: "gpt-4-turbo", Provider: "openai", ContextLength: 128000, InputPrice: 0.0100, OutputPrice: 0.0300, Capabilities: []string{"chat", "streaming", "vision"}, Enabled: true},
+	{Id: GPT4Turbo09, Name: "gpt-4-turbo-2024-04-09", Provider: "openai", ContextLength: 128000, InputPrice: 0.0100, OutputPrice: 0.0300, Capabilities: []string{"chat", "streaming", "vision"}, Enabled: true},
+	{Id: GPT4, Name: "gpt-4", Provider: "openai", ContextLength: 8192, InputPrice: 0.0300, OutputPrice: 0.0600, Capabilities: []string{"chat", "streaming"}, Enabled: true},
+	{Id: GPT432k, Name: "gpt-4-32k", Provider: "openai", ContextLength: 32768, InputPrice: 0.0600, OutputPrice: 0.1200, Capabilities: []string{"chat", "streaming"}, Enabled: true},
+	{Id: LLAMA8B, Name: "llama3.1:8b", Provider: "ollama", ContextLength: 4096, InputPrice: 0.00150, OutputPrice: 0.00200, Capabilities: []string{"chat", "streaming"}, Enabled: true},
+}
+
+// the registry is replaced as a whole on every refresh from the database
+var registry = struct {
+	sync.RWMutex
+	byId   map[int]Model
+	byName map[string]Model
+}{
+	byId:   map[int]Model{},
+	byName: map[string]Model{},
 }
 
-var ModelNameMapping = map[string]int{
-	"gpt-3.5-turbo-0125":     0,
-	"gpt-3.5-turbo":          1,
-	"gpt-3.5-turbo-1106":     2,
-	"gpt-3.5-turbo-instruct": 3,
-	"gpt-4-turbo":            4,
-	"gpt-4-turbo-2024-04-09": 5,
-	"gpt-4":                  6,
-	"gpt-4-32k":              7,
-	"llama3.1:8b":            8,
+// SetModels replaces the in memory registry
+func SetModels(models []Model) {
+	byId := make(map[int]Model, len(models))
+	byName := make(map[string]Model, len(models))
+	for _, model := range models {
+		byId[model.Id] = model
+		byName[model.Name] = model
+	}
+
+	registry.Lock()
+	registry.byId = byId
+	registry.byName = byName
+	registry.Unlock()
 }
 
-var modelNumberMappingContextLength = map[int]int{
-	0: 16385,
-	1: 16385,
-	2: 16385,
-	3: 4096,
-	4: 128000,
-	5: 128000,
-	6: 8192,
-	7: 32768,
-	8: 4096,
+// GetModel looks a model up by name, disabled models are returned as well
+func GetModel(name string) (Model, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	model, ok := registry.byName[name]
+	return model, ok
 }
 
-var ModelPricing = map[string]struct {
-	Input  float64
-	Output float64
-}{
-	"gpt-3.5-turbo-0125":     {Input: 0.00050, Output: 0.00150},
-	"gpt-3.5-turbo":          {Input: 0.0030, Output: 0.0060},
-	"gpt-3.5-turbo-1106":     {Input: 0.0010, Output: 0.0020},
-	"gpt-3.5-turbo-instruct": {Input: 0.00150, Output: 0.00200},
-	"gpt-4-turbo":            {Input: 0.0100, Output: 0.0300},
-	"gpt-4-turbo-2024-04-09": {Input: 0.0100, Output: 0.0300},
-	"gpt-4":                  {Input: 0.0300, Output: 0.0600},
-	"gpt-4-32k":              {Input: 0.0600, Output: 0.1200},
-	"llama3.1:8b":            {Input: 0.00150, Output: 0.00200},
+// GetModelById looks a model up by id, disabled models are returned as well
+func GetModelById(num int) (Model, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	model, ok := registry.byId[num]
+	return model, ok
 }
 
 func GetModelProvider(modelName string) string {
-	return ProviderModelMapping[modelName]
+	model, _ := GetModel(modelName)
+	return model.Provider
 }
 
-func GetModelNumberMapping() map[int]string {
-	return ModelNumberMapping
+// GetModels returns the enabled models ordered by name
+func GetModels() []Model {
+	registry.RLock()
+	models := make([]Model, 0, len(registry.byId))
+	for _, model := range registry.byId {
+		if model.Enabled {
+			models = append(models, model)
+		}
+	}
+	registry.RUnlock()
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Name < models[j].Name
+	})
+	return models
 }
 
 func GetModelsName() []string {
-	modelNames := make([]string, 0, GetModelLen())
-	for name := range ModelNameMapping {
-		modelNames = append(modelNames, name)
+	models := GetModels()
+	modelNames := make([]string, 0, len(models))
+	for _, model := range models {
+		modelNames = append(modelNames, model.Name)
 	}
 	return modelNames
 }
 
 func GetModelLen() int {
-	return len(ModelNumberMapping)
+	registry.RLock()
+	defer registry.RUnlock()
+	return len(registry.byId)
 }
 
 func ModelName(num int) string {
-	return ModelNumberMapping[num]
+	model, _ := GetModelById(num)
+	return model.Name
 }
 
-func ModelNumber(name string) int {
-	return ModelNameMapping[name]
+// ModelNumber returns the id of an enabled model
+func ModelNumber(name string) (int, error) {
+	model, ok := GetModel(name)
+	if !ok {
+		return 0, ErrUnknownModel
+	}
+	if !model.Enabled {
+		return 0, ErrModelDisabled
+	}
+	return model.Id, nil
 }
 
 func ModelContextLength(num int) int {
-	return modelNumberMappingContextLength[num]
+	model, _ := GetModelById(num)
+	return model.ContextLength
 }
@@ -1,5 +1,7 @@
 package error_code
 
+import "strconv"
+
 const (
 	ErrorCodeJSONUnmarshal                  = 0
 	ErrorCodeUnknownMessage                 = 1
@@ -18,6 +20,21 @@ const (
 	ErrorCodeInternalServerError            = 14
 	ErrorCodeInSufficientBalance            = 15
 	ErrorCodeUnableToGetBalanceDetails      = 16
+	ErrorCodeUnauthorized                   = 17
+	ErrorCodeUnknownModel                   = 18
+	ErrorCodeBalanceDoesNotCoverEstimate    = 19
+	ErrorCodeRateLimited                    = 20
+	ErrorCodeMessageTooLong                 = 21
+	ErrorCodeUnknownChatMessage             = 22
+	ErrorCodeInvalidCursor                  = 23
+	ErrorCodeUnknownSortOrder               = 24
+	ErrorCodeInvalidSessionDetails          = 25
+	ErrorCodeUnableToRestoreSession         = 26
+	ErrorCodeUnableToPurgeSession           = 27
+	ErrorCodeInvalidSearchQuery             = 28
+	ErrorCodeUnableToSearch                 = 29
+	ErrorCodeUnableToLoadDocuments          = 30
+	ErrorCodeUnableToLoadFiles              = 31
 )
 
 var errorCodeMapping = map[int]string{
@@ -38,8 +55,32 @@ var errorCodeMapping = map[int]string{
 	14: "Internal Server Error",
 	15: "Insufficient balance",
 	16: "Unable to get balance details",
+	17: "Unauthorized",
+	18: "Unknown Model",
+	19: "Balance does not cover the estimated cost",
+	20: "Rate limit exceeded",
+	21: "Message is too long for the model",
+	22: "Unknown chat message",
+	23: "Invalid cursor",
+	24: "Unknown sort order",
+	25: "Invalid session details",
+	26: "Unable to Restore Session",
+	27: "Unable to Purge Session",
+	28: "Invalid search query",
+	29: "Unable to Search",
+	30: "Unable to Load Documents",
+	31: "Unable to Load Files",
 }
 
 func Error(num int) []byte {
 	return []byte("{\"error\": \"" + errorCodeMapping[num] + "\"}")
 }
+
+// ErrorWithRetryAfter is Error with the number of seconds the client has to wait before trying again
+func ErrorWithRetryAfter(num int, retryAfter int) []byte {
+	return []byte("{\"error\": \"" + errorCodeMapping[num] + "\", \"retry_after\": " + strconv.Itoa(retryAfter) + "}")
+}
+
+func Message(num int) []byte {
+	return []byte(errorCodeMapping[num])
+}
@@ -8,16 +8,44 @@ const (
 	MessageCodeSessionDelete    = 4
 	MessageCodeGetAIModels      = 5
 	MessageCodeGetBalance       = 6
+	MessageCodeChatChunk        = 7
+	MessageCodeChatDone         = 8
+	MessageCodeChatError        = 9
+	MessageCodeChatRegenerate   = 10
+	MessageCodeChatEdit         = 11
+	MessageCodeChatBranch       = 12
+	MessageCodeSessionUpdate    = 13
+	MessageCodeSessionRestore   = 14
+	MessageCodeSessionPurge     = 15
+	MessageCodeSessionRenamed   = 16
+	MessageCodeSearch           = 17
+	MessageCodeSemanticSearch   = 18
+	MessageCodeDocumentStatus   = 19
+	MessageCodeListFiles        = 20
 )
 
 var messageCodeMapping = map[int]string{
-	0: "User Details",
-	1: "Message Listing",
-	2: "Chats By SessionId",
-	3: "Chat Message",
-	4: "Session Delete",
-	5: "Get AI Models",
-	6: "Get Balance",
+	0:  "User Details",
+	1:  "Message Listing",
+	2:  "Chats By SessionId",
+	3:  "Chat Message",
+	4:  "Session Delete",
+	5:  "Get AI Models",
+	6:  "Get Balance",
+	7:  "Chat Chunk",
+	8:  "Chat Done",
+	9:  "Chat Error",
+	10: "Chat Regenerate",
+	11: "Chat Edit",
+	12: "Chat Branch",
+	13: "Session Update",
+	14: "Session Restore",
+	15: "Session Purge",
+	16: "Session Renamed",
+	17: "Search",
+	18: "Semantic Search",
+	19: "Document Status",
+	20: "List Files",
 }
 
 func Message(num int) []byte {